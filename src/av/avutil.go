@@ -74,60 +74,45 @@ func CutMedia(ctx context.Context, inputPath, outputPath string, start, dur time
 		}
 	}
 
-	cmd := []string{
-		ffmpegBin, "-y",
-		"-loglevel", "error",
-	}
-	inputOpt := []string{
-		"-ss", strconv.FormatInt(start.Microseconds(), 10) + "us",
-		"-t", strconv.FormatInt(dur.Microseconds(), 10) + "us",
-	}
+	newOutputPath := fs.GetNameWithNewExt(outputPath, info.GetSuggestedExtFromCodec())
+
+	cmd := NewCommand().Overwrite().LogLevel("error")
+	input := cmd.Input(inputPath).Seek(start).Duration(dur)
 	if decodeName != "" {
-		inputOpt = append(inputOpt, "-c:v", decodeName)
-	}
-	outputOpt := []string{
-		"-i", inputPath,
+		input.Decoder(StreamVideo, decodeName)
 	}
+	output := cmd.Output(newOutputPath)
 	if encodeName != "" {
-		outputOpt = append(outputOpt, "-c:v", encodeName)
+		output.VideoCodec(encodeName)
 	}
 	if pixFmt != "" {
-		outputOpt = append(outputOpt, "-pix_fmt", pixFmt)
+		output.PixFmt(pixFmt)
 	}
-
-	newOutputPath := fs.GetNameWithNewExt(outputPath, info.GetSuggestedExtFromCodec())
-
-	cmd = append(cmd, inputOpt...)
-	cmd = append(cmd, outputOpt...)
-	cmd = append(cmd, newOutputPath)
-	return newOutputPath, fs.RunSysCommand(ctx, cmd, nil)
+	return newOutputPath, cmd.Run(ctx)
 }
 
 // MediaReverse reverse video
 func MediaReverse(ctx context.Context, inputPath, outputPath string) error {
-	cmd := []string{ffmpegBin, "-y", "-i", inputPath, "-vf", "reverse", "-af", "areverse", outputPath}
-	return fs.RunSysCommand(ctx, cmd, nil)
+	cmd := NewCommand().Overwrite()
+	cmd.Input(inputPath)
+	cmd.Output(outputPath).VideoFilter(NewFilter("reverse")).AudioFilter(NewFilter("areverse"))
+	return cmd.Run(ctx)
 }
 
 // MergeTS convert m3u8 to mp4
 func MergeTS(ctx context.Context, inputPath, outputPath string) error {
-	cmd := []string{ffmpegBin, "-y", "-loglevel", "error", "-i", inputPath, "-c", "copy", outputPath}
-	return fs.RunSysCommand(ctx, cmd, nil)
+	cmd := NewCommand().Overwrite().LogLevel("error")
+	cmd.Input(inputPath)
+	cmd.Output(outputPath).Copy()
+	return cmd.Run(ctx)
 }
 
 // ConvertToWav convert video to wav format
 func ConvertToWav(ctx context.Context, inputPath string, start, dur time.Duration, outputPath string) error {
-	cmd := []string{
-		ffmpegBin,
-		"-y",
-		"-loglevel", "error",
-		"-ss", strconv.FormatInt(start.Microseconds(), 10) + "us",
-		"-t", strconv.FormatInt(dur.Microseconds(), 10) + "us",
-		"-i", inputPath,
-		"-f", "wav",
-		outputPath,
-	}
-	return fs.RunSysCommand(ctx, cmd, nil)
+	cmd := NewCommand().Overwrite().LogLevel("error")
+	cmd.Input(inputPath).Seek(start).Duration(dur)
+	cmd.Output(outputPath).Format("wav")
+	return cmd.Run(ctx)
 }
 
 // SoundstretchProcess soundtouch, pitch=n : Change sound pitch by n semitones (n=-60..+60 semitones)
@@ -146,16 +131,10 @@ func SoundstretchProcess(ctx context.Context, inputPath, outputPath string, pitc
 // FormatConvert  ffmpeg -i inputPath -c copy outputPath
 func FormatConvert(ctx context.Context, inputPath, ext string) (string, error) {
 	outputPath := filepath.Join(filepath.Dir(inputPath), fs.GetFileName(inputPath)+"_convert"+ext)
-	cmd := []string{
-		ffmpegBin,
-		"-y",
-		"-loglevel", "error",
-		"-i", inputPath,
-		"-c", "copy",
-		"-strict", "-2",
-		outputPath,
-	}
-	return outputPath, fs.RunSysCommand(ctx, cmd, nil)
+	cmd := NewCommand().Overwrite().LogLevel("error")
+	cmd.Input(inputPath)
+	cmd.Output(outputPath).Copy().Strict()
+	return outputPath, cmd.Run(ctx)
 }
 
 // ResizeMediaFitIn resize media file in the specified size
@@ -188,16 +167,12 @@ func ResizeMediaFitIn(ctx context.Context, inputPath string, width int, height i
 		}
 
 		outputPath := fs.FileNameAppend(inputPath, fmt.Sprintf("_%vx%v", newWidth, newHeight), defaultExt)
-		cmd := []string{
-			ffmpegBin,
-			"-y",
-			"-loglevel", "error",
-			"-i", inputPath,
-			"-vf", fmt.Sprintf("scale=%d:%d", newWidth, newHeight),
-			"-strict", "-2",
-			outputPath,
-		}
-		if err = fs.RunSysCommand(ctx, cmd, nil); err != nil {
+		cmd := NewCommand().Overwrite().LogLevel("error")
+		cmd.Input(inputPath)
+		cmd.Output(outputPath).
+			VideoFilter(NewFilter("scale", strconv.Itoa(newWidth), strconv.Itoa(newHeight))).
+			Strict()
+		if err = cmd.Run(ctx); err != nil {
 			logs.Log.Errorf("failed to resize %s %dx%d => %s err = %+v", inputPath, newWidth, newHeight, outputPath, err)
 			return "", err
 		}
@@ -294,7 +294,7 @@ func TestResizeMedia(t *testing.T) {
 		},
 	})
 
-	patches = patches.ApplyFuncSeq(fs.RunSysCommand, []gomonkey.OutputCell{
+	patches = patches.ApplyFuncSeq(runCommand, []gomonkey.OutputCell{
 		// failed
 		{Values: gomonkey.Params{errors.ErrUnknown}, Times: 1},
 		// landscape + portrait + portrait default ext
@@ -0,0 +1,362 @@
+package av
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-utils/src/logs"
+)
+
+// StreamType 流类型，用于流选择符，比如 -c:v、-map 0:a
+type StreamType string
+
+// 常用流类型
+const (
+	StreamVideo    StreamType = "v"
+	StreamAudio    StreamType = "a"
+	StreamSubtitle StreamType = "s"
+	StreamData     StreamType = "d"
+)
+
+// formatDuration 转换为 ffmpeg 的时间格式，精确到微秒
+func formatDuration(d time.Duration) string {
+	return strconv.FormatInt(d.Microseconds(), 10) + "us"
+}
+
+// Filter 单个滤镜，渲染为 name=arg1:arg2，Args 原样拼接，包含特殊字符时使用 EscapeFilterArg 转义
+type Filter struct {
+	Name string
+	Args []string
+}
+
+// NewFilter 构造滤镜，比如 NewFilter("scale", "640", "-2")
+func NewFilter(name string, args ...string) Filter {
+	return Filter{Name: name, Args: args}
+}
+
+func (f Filter) String() string {
+	if len(f.Args) == 0 {
+		return f.Name
+	}
+	return f.Name + "=" + strings.Join(f.Args, ":")
+}
+
+// EscapeFilterArg 按照 ffmpeg 滤镜图的两级转义规则转义滤镜参数的值，比如 subtitles 滤镜的文件路径：
+// 先转义参数值中的 \ ' :，再转义滤镜图中的 \ ' [ ] , ;
+func EscapeFilterArg(arg string) string {
+	return escapeChars(escapeChars(arg, `\':`), `\'[],;`)
+}
+
+// escapeChars 在 chars 中的字符前加上反斜杠
+func escapeChars(s, chars string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// FilterChain 滤镜链，依次经过 Filters 处理，Inputs/Outputs 为链两端的标签，比如 0:v、out
+type FilterChain struct {
+	Inputs  []string
+	Filters []Filter
+	Outputs []string
+}
+
+func (c FilterChain) String() string {
+	var b strings.Builder
+	for _, label := range c.Inputs {
+		b.WriteString("[" + label + "]")
+	}
+	for i, f := range c.Filters {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(f.String())
+	}
+	for _, label := range c.Outputs {
+		b.WriteString("[" + label + "]")
+	}
+	return b.String()
+}
+
+// FilterGraph 滤镜图，多个滤镜链之间以 ; 分隔，用于 -filter_complex
+type FilterGraph []FilterChain
+
+func (g FilterGraph) String() string {
+	chains := make([]string, 0, len(g))
+	for _, c := range g {
+		chains = append(chains, c.String())
+	}
+	return strings.Join(chains, ";")
+}
+
+// Input 命令的一个输入，选项按照添加顺序渲染在 -i 之前，只对当前输入生效
+type Input struct {
+	index int
+	path  string
+	args  []string
+	start time.Duration // -ss，用于估算输出时长
+	dur   time.Duration // -t，用于估算输出时长
+}
+
+// Index 输入的序号，从 0 开始
+func (i *Input) Index() int {
+	return i.index
+}
+
+// Stream 当前输入中指定类型的流选择符，比如 1:a，用于 Output.Map 及 FilterChain 的标签
+func (i *Input) Stream(typ StreamType) string {
+	return fmt.Sprintf("%d:%s", i.index, typ)
+}
+
+// Option 添加任意输入选项，比如 Option("-r", "25")
+func (i *Input) Option(name string, values ...string) *Input {
+	i.args = append(i.args, name)
+	i.args = append(i.args, values...)
+	return i
+}
+
+// Seek 从 start 开始读取输入
+func (i *Input) Seek(start time.Duration) *Input {
+	i.start = start
+	return i.Option("-ss", formatDuration(start))
+}
+
+// Duration 最多读取 dur 时长
+func (i *Input) Duration(dur time.Duration) *Input {
+	i.dur = dur
+	return i.Option("-t", formatDuration(dur))
+}
+
+// Format 强制指定输入格式，比如 concat
+func (i *Input) Format(format string) *Input {
+	return i.Option("-f", format)
+}
+
+// Decoder 指定 typ 类型流的解码器，比如 vp9 带 alpha 通道时需要 libvpx-vp9 解码
+func (i *Input) Decoder(typ StreamType, codec string) *Input {
+	return i.Option("-c:"+string(typ), codec)
+}
+
+// Output 命令的一个输出，选项按照添加顺序渲染在输出路径之前，只对当前输出生效
+type Output struct {
+	path string
+	args []string
+	dur  time.Duration // -t，用于估算输出时长
+}
+
+// Option 添加任意输出选项，比如 Option("-movflags", "+faststart")
+func (o *Output) Option(name string, values ...string) *Output {
+	o.args = append(o.args, name)
+	o.args = append(o.args, values...)
+	return o
+}
+
+// Map 选择输出包含的流，spec 为流选择符(参见 Input.Stream)或者带中括号的滤镜输出标签，比如 [out]
+func (o *Output) Map(specs ...string) *Output {
+	for _, spec := range specs {
+		o.Option("-map", spec)
+	}
+	return o
+}
+
+// Codec 指定 typ 类型流的编码器，typ 为空时对所有流生效
+func (o *Output) Codec(typ StreamType, codec string) *Output {
+	if typ == "" {
+		return o.Option("-c", codec)
+	}
+	return o.Option("-c:"+string(typ), codec)
+}
+
+// VideoCodec 指定视频编码器
+func (o *Output) VideoCodec(codec string) *Output {
+	return o.Codec(StreamVideo, codec)
+}
+
+// AudioCodec 指定音频编码器
+func (o *Output) AudioCodec(codec string) *Output {
+	return o.Codec(StreamAudio, codec)
+}
+
+// Copy 所有流直接拷贝，不重新编码
+func (o *Output) Copy() *Output {
+	return o.Codec("", "copy")
+}
+
+// Bitrate 指定 typ 类型流的码率，比如 Bitrate(StreamVideo, "2M")
+func (o *Output) Bitrate(typ StreamType, bitrate string) *Output {
+	return o.Option("-b:"+string(typ), bitrate)
+}
+
+// PixFmt 指定像素格式
+func (o *Output) PixFmt(pixFmt string) *Output {
+	return o.Option("-pix_fmt", pixFmt)
+}
+
+// Format 指定输出格式，比如 wav
+func (o *Output) Format(format string) *Output {
+	return o.Option("-f", format)
+}
+
+// Seek 丢弃 start 之前的输出
+func (o *Output) Seek(start time.Duration) *Output {
+	return o.Option("-ss", formatDuration(start))
+}
+
+// Duration 最多输出 dur 时长
+func (o *Output) Duration(dur time.Duration) *Output {
+	o.dur = dur
+	return o.Option("-t", formatDuration(dur))
+}
+
+// VideoFilter 视频滤镜链，即 -vf
+func (o *Output) VideoFilter(filters ...Filter) *Output {
+	return o.Option("-vf", FilterChain{Filters: filters}.String())
+}
+
+// AudioFilter 音频滤镜链，即 -af
+func (o *Output) AudioFilter(filters ...Filter) *Output {
+	return o.Option("-af", FilterChain{Filters: filters}.String())
+}
+
+// Disable 禁用 typ 类型的流，比如 -vn
+func (o *Output) Disable(typ StreamType) *Output {
+	return o.Option("-" + string(typ) + "n")
+}
+
+// Strict 允许使用实验性的编码器，即 -strict -2
+func (o *Output) Strict() *Output {
+	return o.Option("-strict", "-2")
+}
+
+// Command ffmpeg 命令构造器，按照 全局选项、输入、-filter_complex、输出 的顺序生成参数，不需要执行即可通过 Args 检查
+type Command struct {
+	bin     string
+	global  []string
+	inputs  []*Input
+	graph   FilterGraph
+	outputs []*Output
+	total   time.Duration // 预计的输出总时长，参见 TotalDuration
+	stderr  io.Writer     // 同时接收标准错误，参见 Stderr
+}
+
+// NewCommand 构造 ffmpeg 命令
+func NewCommand() *Command {
+	return &Command{}
+}
+
+// Bin 指定可执行程序，默认使用 ffmpegBin
+func (c *Command) Bin(bin string) *Command {
+	c.bin = bin
+	return c
+}
+
+// Stderr 执行时将标准错误同时写入 w，用于解析 ffmpeg 输出到日志中的统计信息，比如 loudnorm 的测量结果
+func (c *Command) Stderr(w io.Writer) *Command {
+	c.stderr = w
+	return c
+}
+
+// Option 添加任意全局选项，比如 Option("-hide_banner")
+func (c *Command) Option(name string, values ...string) *Command {
+	c.global = append(c.global, name)
+	c.global = append(c.global, values...)
+	return c
+}
+
+// Overwrite 覆盖已存在的输出文件，即 -y
+func (c *Command) Overwrite() *Command {
+	return c.Option("-y")
+}
+
+// LogLevel 日志级别，比如 error
+func (c *Command) LogLevel(level string) *Command {
+	return c.Option("-loglevel", level)
+}
+
+// Input 添加输入，返回的 Input 用于设置当前输入的选项
+func (c *Command) Input(path string) *Input {
+	input := &Input{index: len(c.inputs), path: path}
+	c.inputs = append(c.inputs, input)
+	return input
+}
+
+// Output 添加输出，返回的 Output 用于设置当前输出的选项
+func (c *Command) Output(path string) *Output {
+	output := &Output{path: path}
+	c.outputs = append(c.outputs, output)
+	return output
+}
+
+// FilterComplex 添加滤镜链到 -filter_complex
+func (c *Command) FilterComplex(chains ...FilterChain) *Command {
+	c.graph = append(c.graph, chains...)
+	return c
+}
+
+// Args 生成不包含可执行程序的参数列表
+func (c *Command) Args() []string {
+	args := append([]string{}, c.global...)
+	for _, input := range c.inputs {
+		args = append(args, input.args...)
+		args = append(args, "-i", input.path)
+	}
+	if len(c.graph) > 0 {
+		args = append(args, "-filter_complex", c.graph.String())
+	}
+	for _, output := range c.outputs {
+		args = append(args, output.args...)
+		args = append(args, output.path)
+	}
+	return args
+}
+
+// Argv 生成包含可执行程序的完整命令
+func (c *Command) Argv() []string {
+	bin := c.bin
+	if bin == "" {
+		bin = ffmpegBin
+	}
+	return append([]string{bin}, c.Args()...)
+}
+
+func (c *Command) String() string {
+	return strings.Join(c.Argv(), " ")
+}
+
+// Run 执行命令，至少需要一个输出，失败时返回 *FFmpegError；ctx 中通过 ContextWithProgress 设置了回调时同 RunWithProgress
+func (c *Command) Run(ctx context.Context) error {
+	return c.RunWithProgress(ctx, progressFromContext(ctx))
+}
+
+// runCommand 执行命令，stdout 不为 nil 时写入标准输出，失败时返回 *FFmpegError
+func runCommand(ctx context.Context, argv []string, stdout io.Writer) error {
+	return runCommandStderr(ctx, argv, stdout, nil)
+}
+
+// runCommandStderr 同 runCommand，stderr 不为 nil 时同时写入标准错误
+func runCommandStderr(ctx context.Context, argv []string, stdout, stderr io.Writer) error {
+	logs.Log.Debugf("command: %+v", argv)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	tail := &tailBuffer{max: maxStderrTail}
+	cmd.Stdout = stdout
+	cmd.Stderr = tail
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(tail, stderr)
+	}
+	if err := cmd.Run(); err != nil {
+		e := newFFmpegError(ctx, argv, tail.String(), err)
+		logs.Log.Errorf("command: %+v with error: %v", argv, e)
+		return e
+	}
+	return nil
+}
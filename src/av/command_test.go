@@ -0,0 +1,159 @@
+package av
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+)
+
+func TestFilterGraph_String(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph FilterGraph
+		want  string
+	}{
+		{"single", FilterGraph{{Filters: []Filter{NewFilter("reverse")}}}, "reverse"},
+		{
+			"chain",
+			FilterGraph{{
+				Inputs:  []string{"0:v"},
+				Filters: []Filter{NewFilter("scale", "640", "-2"), NewFilter("fps", "25")},
+				Outputs: []string{"v"},
+			}},
+			"[0:v]scale=640:-2,fps=25[v]",
+		},
+		{
+			"overlay",
+			FilterGraph{
+				{Inputs: []string{"1:v"}, Filters: []Filter{NewFilter("scale", "100", "-1")}, Outputs: []string{"logo"}},
+				{Inputs: []string{"0:v", "logo"}, Filters: []Filter{NewFilter("overlay", "W-w", "0")}, Outputs: []string{"out"}},
+			},
+			"[1:v]scale=100:-1[logo];[0:v][logo]overlay=W-w:0[out]",
+		},
+		{"escape_expr", FilterGraph{{Filters: []Filter{NewFilter("select", EscapeFilterArg("gt(scene,0.4)"))}}}, `select=gt(scene\,0.4)`},
+		{
+			"escape",
+			FilterGraph{{Filters: []Filter{NewFilter("subtitles", EscapeFilterArg(`C:\a,b[1]'s.srt`))}}},
+			`subtitles=C\\:\\\\a\,b\[1\]\\\'s.srt`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.graph.String(); got != tt.want {
+				t.Errorf("FilterGraph.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand_Args(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() *Command
+		want  string
+	}{
+		{
+			"copy",
+			func() *Command {
+				cmd := NewCommand().Overwrite().LogLevel("error")
+				cmd.Input("in.m3u8")
+				cmd.Output("out.mp4").Copy()
+				return cmd
+			},
+			"-y -loglevel error -i in.m3u8 -c copy out.mp4",
+		},
+		{
+			"input_and_output_options",
+			func() *Command {
+				cmd := NewCommand().Overwrite()
+				cmd.Input("in.webm").Seek(1500*time.Millisecond).Duration(2*time.Second).Decoder(StreamVideo, "libvpx-vp9")
+				cmd.Output("out.webm").VideoCodec("libvpx-vp9").PixFmt(pixFmtYUVA420p)
+				return cmd
+			},
+			"-y -ss 1500000us -t 2000000us -c:v libvpx-vp9 -i in.webm -c:v libvpx-vp9 -pix_fmt yuva420p out.webm",
+		},
+		{
+			"filter_complex_and_map",
+			func() *Command {
+				cmd := NewCommand().Overwrite()
+				video := cmd.Input("video.mp4")
+				logo := cmd.Input("logo.png")
+				cmd.FilterComplex(FilterChain{
+					Inputs:  []string{video.Stream(StreamVideo), logo.Stream(StreamVideo)},
+					Filters: []Filter{NewFilter("overlay", "10", "10")},
+					Outputs: []string{"v"},
+				})
+				cmd.Output("out.mp4").Map("[v]", video.Stream(StreamAudio)+"?").
+					VideoCodec("libx264").Bitrate(StreamVideo, "2M").AudioCodec("copy")
+				return cmd
+			},
+			"-y -i video.mp4 -i logo.png -filter_complex [0:v][1:v]overlay=10:10[v] " +
+				"-map [v] -map 0:a? -c:v libx264 -b:v 2M -c:a copy out.mp4",
+		},
+		{
+			"multiple_outputs",
+			func() *Command {
+				cmd := NewCommand()
+				cmd.Input("in.mp4")
+				cmd.Output("audio.wav").Disable(StreamVideo).Format("wav")
+				cmd.Output("video.mp4").Disable(StreamAudio).VideoFilter(NewFilter("scale", "-2", "720")).Strict()
+				return cmd
+			},
+			"-i in.mp4 -vn -f wav audio.wav -an -vf scale=-2:720 -strict -2 video.mp4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := tt.build()
+			if got := strings.Join(cmd.Args(), " "); got != tt.want {
+				t.Errorf("Command.Args() = %v, want %v", got, tt.want)
+			}
+			if got := cmd.Argv(); got[0] != ffmpegBin || len(got) != len(cmd.Args())+1 {
+				t.Errorf("Command.Argv() = %v", got)
+			}
+		})
+	}
+
+	if got := NewCommand().Bin("/usr/local/bin/ffmpeg").Argv(); !reflect.DeepEqual(got, []string{"/usr/local/bin/ffmpeg"}) {
+		t.Errorf("Command.Argv() = %v", got)
+	}
+	if err := NewCommand().Run(context.Background()); err == nil {
+		t.Errorf("Command.Run() without output should fail")
+	}
+}
+
+func TestCutMedia_args(t *testing.T) {
+	var got []string
+	patches := gomonkey.ApplyFuncReturn(Probe, &ProbeInfo{
+		InputPath: "/test/in.webm",
+		Streams: []Streams{
+			{CodecType: CodecTypeVideo, CodecName: string(codecVp9), Tags: Tags{AlphaMode: "1"}},
+		},
+	}, nil)
+	patches = patches.ApplyFunc(runCommand, func(_ context.Context, commands []string, _ io.Writer) error {
+		got = commands
+		return nil
+	})
+	defer patches.Reset()
+
+	output, err := CutMedia(context.Background(), "/test/in.webm", "/test/out.mp4", time.Second, 2*time.Second)
+	if err != nil {
+		t.Fatalf("CutMedia() error = %v", err)
+	}
+	if output != "/test/out.webm" {
+		t.Errorf("CutMedia() = %v", output)
+	}
+	want := []string{
+		ffmpegBin, "-y", "-loglevel", "error",
+		"-ss", "1000000us", "-t", "2000000us", "-c:v", "libvpx-vp9", "-i", "/test/in.webm",
+		"-c:v", "libvpx-vp9", "-pix_fmt", pixFmtYUVA420p, "/test/out.webm",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CutMedia() command = %v, want %v", got, want)
+	}
+}
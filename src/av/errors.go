@@ -0,0 +1,171 @@
+package av
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// maxStderrTail FFmpegError 保留的 stderr 末尾的最大字节数
+const maxStderrTail = 4 << 10
+
+// ErrorKind ffmpeg 失败原因的分类
+type ErrorKind int
+
+// 失败原因，按照 stderr 中的关键字分类
+const (
+	ErrorUnknown          ErrorKind = iota // 无法分类
+	ErrorInvalidInput                      // 输入不存在、已损坏或者格式无法识别
+	ErrorUnsupportedCodec                  // 没有可用的解码器，或者编码无法封装到输出格式中
+	ErrorMissingEncoder                    // 当前 ffmpeg 不包含指定的编码器
+	ErrorDiskFull                          // 磁盘空间不足
+	ErrorKilled                            // ctx 取消或超时，进程被杀死
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorInvalidInput:
+		return "invalid input"
+	case ErrorUnsupportedCodec:
+		return "unsupported codec"
+	case ErrorMissingEncoder:
+		return "missing encoder"
+	case ErrorDiskFull:
+		return "disk full"
+	case ErrorKilled:
+		return "killed"
+	}
+	return "unknown"
+}
+
+// stderrPatterns stderr 关键字(小写)到失败原因的映射，按顺序匹配
+var stderrPatterns = []struct {
+	kind     ErrorKind
+	keywords []string
+}{
+	{ErrorDiskFull, []string{"no space left on device", "disk quota exceeded"}},
+	{ErrorMissingEncoder, []string{"unknown encoder", "encoder not found", "could not find encoder", "unsupported encoder"}},
+	{ErrorUnsupportedCodec, []string{
+		"decoder (codec", "unknown decoder", "could not find codec parameters", "could not find tag for codec",
+		"codec not currently supported in container", "unsupported codec",
+	}},
+	{ErrorInvalidInput, []string{
+		"no such file or directory", "invalid data found when processing input", "moov atom not found",
+		"does not contain any stream", "error opening input",
+	}},
+}
+
+// classify 根据 stderr 判断失败原因
+func classify(stderr string) ErrorKind {
+	stderr = strings.ToLower(stderr)
+	for _, pattern := range stderrPatterns {
+		for _, keyword := range pattern.keywords {
+			if strings.Contains(stderr, keyword) {
+				return pattern.kind
+			}
+		}
+	}
+	return ErrorUnknown
+}
+
+// FFmpegError ffmpeg 执行失败的详细信息，可以通过 errors.As 获取；ctx 取消时可以通过 errors.Is 判断
+type FFmpegError struct {
+	Argv     []string  // 完整命令
+	ExitCode int       // 退出码，未启动或者被信号杀死时为 -1
+	Stderr   string    // stderr 的末尾部分，最多 maxStderrTail 字节
+	Kind     ErrorKind // 失败原因
+	Err      error     // 底层错误，被 ctx 杀死时为 ctx.Err()
+}
+
+// newFFmpegError 根据执行结果构造 FFmpegError
+func newFFmpegError(ctx context.Context, argv []string, stderr string, err error) *FFmpegError {
+	e := &FFmpegError{Argv: argv, ExitCode: -1, Stderr: stderr, Kind: classify(stderr), Err: err}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		e.ExitCode = exitErr.ExitCode()
+	}
+	if ctx.Err() != nil {
+		e.Kind, e.Err = ErrorKilled, ctx.Err()
+	}
+	return e
+}
+
+func (e *FFmpegError) Error() string {
+	var b strings.Builder
+	bin := "ffmpeg"
+	if len(e.Argv) > 0 {
+		bin = filepath.Base(e.Argv[0])
+	}
+	fmt.Fprintf(&b, "%s: %s: %v", bin, e.Kind, e.Err)
+	// 最后一行通常就是失败原因
+	if i := strings.LastIndexByte(e.Stderr, '\n'); i >= 0 {
+		fmt.Fprintf(&b, ": %s", e.Stderr[i+1:])
+	} else if e.Stderr != "" {
+		fmt.Fprintf(&b, ": %s", e.Stderr)
+	}
+	return b.String()
+}
+
+// Unwrap 返回底层错误
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKindOf 返回 err 中 FFmpegError 的失败原因，不是 FFmpegError 时返回 ErrorUnknown
+func ErrorKindOf(err error) ErrorKind {
+	var e *FFmpegError
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return ErrorUnknown
+}
+
+// IsRetryable 判断 ffmpeg 失败后是否值得重试：输入或编码问题重试也不会成功，应当拒绝该媒体文件；
+// 被 ctx 杀死时由调用者决定；ffmpeg 无法启动(比如不存在)时重试也没有意义；
+// 只有 FFmpegError 中磁盘空间不足及无法分类的错误可以重试，其他错误都不重试
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var execErr *exec.Error
+	if errors.As(err, &execErr) {
+		return false
+	}
+	var e *FFmpegError
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.Kind == ErrorUnknown || e.Kind == ErrorDiskFull
+}
+
+// tailBuffer 只保留最后 max 字节的 io.Writer，用于收集 stderr
+type tailBuffer struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > 2*b.max {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.max:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// String 返回最后 max 字节，截断时丢弃不完整的第一行
+func (b *tailBuffer) String() string {
+	data, truncated := b.buf, b.truncated
+	if len(data) > b.max {
+		data, truncated = data[len(data)-b.max:], true
+	}
+	s := string(data)
+	if i := strings.IndexByte(s, '\n'); truncated && i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.TrimSpace(s)
+}
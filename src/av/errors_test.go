@@ -0,0 +1,134 @@
+package av
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_classify(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   ErrorKind
+	}{
+		{"not_exist", "notexist: No such file or directory", ErrorInvalidInput},
+		{"corrupted", "in.mp4: Invalid data found when processing input", ErrorInvalidInput},
+		{"moov", "[mov,mp4,m4a,3gp,3g2,mj2 @ 0x55] moov atom not found\nin.mp4: Invalid data found when processing input", ErrorInvalidInput},
+		{"decoder", "Decoder (codec av1) not found for input stream #0:0", ErrorUnsupportedCodec},
+		{"container", "Could not write header for output file #0 (incorrect codec parameters ?): " +
+			"codec not currently supported in container", ErrorUnsupportedCodec},
+		{"encoder", "Unknown encoder 'libfdk_aac'", ErrorMissingEncoder},
+		{"disk_full", "av_interleaved_write_frame(): No space left on device", ErrorDiskFull},
+		{"unknown", "Conversion failed!", ErrorUnknown},
+		{"empty", "", ErrorUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.stderr); got != tt.want {
+				t.Errorf("classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_runCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    string
+		timeout   time.Duration
+		wantKind  ErrorKind
+		wantCode  int
+		wantErr   error
+		retryable bool
+	}{
+		{"invalid_input", "echo 'ffmpeg version 5.1' >&2; echo 'in.mp4: Invalid data found when processing input' >&2; exit 1",
+			0, ErrorInvalidInput, 1, nil, false},
+		{"disk_full", "echo 'No space left on device' >&2; exit 1", 0, ErrorDiskFull, 1, nil, true},
+		{"unknown", "exit 3", 0, ErrorUnknown, 3, nil, true},
+		{"killed", "exec sleep 5", 50 * time.Millisecond, ErrorKilled, -1, context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			err := runCommand(ctx, []string{"sh", "-c", tt.script}, nil)
+			var e *FFmpegError
+			if !errors.As(err, &e) {
+				t.Fatalf("runCommand() error = %v, want *FFmpegError", err)
+			}
+			if e.Kind != tt.wantKind || e.ExitCode != tt.wantCode || ErrorKindOf(err) != tt.wantKind {
+				t.Errorf("runCommand() kind = %v, exit code = %v, want %v, %v", e.Kind, e.ExitCode, tt.wantKind, tt.wantCode)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("runCommand() error = %v, want %v", err, tt.wantErr)
+			}
+			if IsRetryable(err) != tt.retryable {
+				t.Errorf("IsRetryable() = %v, want %v", IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+
+	err := runCommand(context.Background(), []string{"sh", "-c", "echo first >&2; echo 'last line' >&2; exit 1"}, nil)
+	if want := "sh: unknown: exit status 1: last line"; err == nil || err.Error() != want {
+		t.Errorf("FFmpegError.Error() = %v, want %v", err, want)
+	}
+	if runCommand(context.Background(), []string{"true"}, nil) != nil || IsRetryable(nil) {
+		t.Errorf("runCommand() should succeed")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	notFound := runCommand(context.Background(), []string{"ffmpeg-not-exist"}, nil)
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"unknown", &FFmpegError{Kind: ErrorUnknown, Err: errors.New("exit status 1")}, true},
+		{"wrapped_disk_full", fmt.Errorf("convert: %w", &FFmpegError{Kind: ErrorDiskFull}), true},
+		{"invalid_input", &FFmpegError{Kind: ErrorInvalidInput}, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("probe: %w", context.DeadlineExceeded), false},
+		{"not_found", notFound, false},
+		{"not_ffmpeg", errors.New("unknown duration"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+	if !errors.Is(notFound, exec.ErrNotFound) {
+		t.Errorf("runCommand() error = %v, want %v", notFound, exec.ErrNotFound)
+	}
+}
+
+func Test_tailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 16}
+	b.Write([]byte("short\n"))
+	if got := b.String(); got != "short" {
+		t.Errorf("tailBuffer.String() = %q", got)
+	}
+	for i := 0; i < 10; i++ {
+		b.Write([]byte(strings.Repeat("x", 10) + "\n"))
+	}
+	b.Write([]byte("end\n"))
+	// 截断后丢弃不完整的第一行
+	if want := strings.Repeat("x", 10) + "\nend"; b.String() != want {
+		t.Errorf("tailBuffer.String() = %q, want %q", b.String(), want)
+	}
+	if len(b.buf) > 2*b.max {
+		t.Errorf("tailBuffer keeps %d bytes", len(b.buf))
+	}
+}
@@ -0,0 +1,390 @@
+package av
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-utils/src/av/hls"
+	"go-utils/src/tools/algorithm"
+	"go-utils/src/tools/fs"
+)
+
+// HLS 打包相关的默认值及文件名
+const (
+	defaultSegmentDuration = 6 * time.Second
+	defaultHLSVideoCodec   = "libx264"
+	defaultHLSAudioCodec   = "aac"
+	defaultHLSPreset       = "veryfast"
+
+	hlsMasterPlaylist = "master.m3u8"
+	hlsMediaPlaylist  = "index.m3u8"
+	hlsIFramePlaylist = "iframes.m3u8"
+	hlsInitSegment    = "init.mp4"
+)
+
+// Rendition 码率阶梯中的一档
+type Rendition struct {
+	Name         string // 子目录名，比如 720p
+	Width        int    // 输出宽度，由 BuildLadder 按照源的显示比例计算
+	Height       int    // 输出高度；传给 BuildLadder 的阶梯中表示短边，横屏为高度，竖屏为宽度
+	VideoBitrate int64  // 视频码率，单位 bps
+	AudioBitrate int64  // 音频码率，单位 bps，0 表示不包含音频
+}
+
+// DefaultLadder 默认的码率阶梯，从高到低排列
+var DefaultLadder = []Rendition{
+	{Name: "1080p", Height: 1080, VideoBitrate: 5000000, AudioBitrate: 192000},
+	{Name: "720p", Height: 720, VideoBitrate: 2800000, AudioBitrate: 128000},
+	{Name: "480p", Height: 480, VideoBitrate: 1400000, AudioBitrate: 128000},
+	{Name: "360p", Height: 360, VideoBitrate: 800000, AudioBitrate: 96000},
+	{Name: "240p", Height: 240, VideoBitrate: 400000, AudioBitrate: 64000},
+}
+
+// BuildLadder 根据 Probe 的结果从 ladder 中选择不超过源分辨率的档位，按照源旋转后的显示比例计算宽高，
+// 视频码率不超过源的总码率，源没有音频时去掉音频码率；源分辨率低于所有档位时按照源分辨率输出最低一档
+func BuildLadder(info *ProbeInfo, ladder []Rendition) []Rendition {
+	width, height := info.GetDisplaySize()
+	if width <= 0 || height <= 0 || len(ladder) == 0 {
+		return nil
+	}
+	short := algorithm.MinInt(width, height)
+	sourceBitrate := algorithm.ParseInt(info.Format.BitRate, 0)
+	hasAudio := info.GetAudioStream() != nil
+
+	var result []Rendition
+	for _, r := range ladder {
+		if r.Height <= short {
+			result = append(result, r)
+		}
+	}
+	if len(result) == 0 {
+		r := ladder[len(ladder)-1]
+		r.Name, r.Height = fmt.Sprintf("%dp", short&^1), short
+		result = append(result, r)
+	}
+	for i := range result {
+		r := &result[i]
+		// 宽高都取偶数
+		if width >= height {
+			r.Width, r.Height = (r.Height*width/height+1)&^1, r.Height&^1
+		} else {
+			r.Width, r.Height = r.Height&^1, (r.Height*height/width+1)&^1
+		}
+		if sourceBitrate > 0 {
+			r.VideoBitrate = algorithm.MinInt64(r.VideoBitrate, sourceBitrate)
+		}
+		if !hasAudio {
+			r.AudioBitrate = 0
+		}
+	}
+	return result
+}
+
+// HLSOptions HLS 打包参数，零值使用默认值
+type HLSOptions struct {
+	Ladder          []Rendition   // 码率阶梯，经过 BuildLadder 处理，默认 DefaultLadder
+	SegmentDuration time.Duration // 分片时长，默认 6s
+	FMP4            bool          // 使用 fMP4 分片，默认为 mpegts
+	IFramePlaylists bool          // 是否生成 I 帧播放列表，用于快进快退时的预览
+	VideoCodec      string        // 视频编码器，默认 libx264
+	AudioCodec      string        // 音频编码器，默认 aac
+	Preset          string        // 编码器的 preset，默认 veryfast
+}
+
+// HLSVariant 一档输出的播放列表及码率信息
+type HLSVariant struct {
+	Rendition
+	Playlist         string // 媒体播放列表路径
+	IFramePlaylist   string // I 帧播放列表路径，未生成时为空
+	Bandwidth        int64  // 分片的峰值码率，单位 bps
+	AverageBandwidth int64  // 平均码率，单位 bps
+	IFrameBandwidth  int64  // I 帧播放列表的峰值码率
+	Codecs           string // 主播放列表中的 CODECS 属性，无法识别时为空
+}
+
+// HLSPackage HLS 打包的结果
+type HLSPackage struct {
+	MasterPlaylist string // 主播放列表路径
+	Variants       []HLSVariant
+}
+
+// readMediaPlaylist 读取 ffmpeg 生成的媒体播放列表
+func readMediaPlaylist(playlistPath string) (*hls.MediaPlaylist, error) {
+	p, err := hls.ReadFile(playlistPath)
+	if err != nil {
+		return nil, err
+	}
+	media, ok := p.(*hls.MediaPlaylist)
+	if !ok || len(media.Segments) == 0 {
+		return nil, fmt.Errorf("no segment in %s", playlistPath)
+	}
+	return media, nil
+}
+
+// initURI fMP4 分片的 EXT-X-MAP 地址，mpegts 分片返回空字符串
+func initURI(media *hls.MediaPlaylist) string {
+	if m := media.Segments[0].Map; m != nil {
+		return m.URI
+	}
+	return ""
+}
+
+// h264Profiles H.264 profile 名称到 profile_idc 及 constraint 标志的映射
+var h264Profiles = map[string][2]int{
+	"Constrained Baseline": {0x42, 0xe0},
+	"Baseline":             {0x42, 0x00},
+	"Main":                 {0x4d, 0x40},
+	"High":                 {0x64, 0x00},
+}
+
+// aacProfiles AAC profile 名称到 mp4a 对象类型的映射
+var aacProfiles = map[string]int{"LC": 2, "HE-AAC": 5, "HE-AACv2": 29}
+
+// codecsTag 根据 Probe 的结果生成 CODECS 属性，比如 avc1.64001f,mp4a.40.2，存在无法识别的编码时返回空字符串
+func codecsTag(info *ProbeInfo) string {
+	var codecs []string
+	for _, s := range info.Streams {
+		switch {
+		case s.CodecType == CodecTypeVideo && s.CodecName == string(codecH264):
+			profile, ok := h264Profiles[s.Profile]
+			if !ok || s.Level <= 0 {
+				return ""
+			}
+			codecs = append(codecs, fmt.Sprintf("avc1.%02x%02x%02x", profile[0], profile[1], s.Level))
+		case s.CodecType == CodecTypeAudio && s.CodecName == string(codecAac):
+			objectType, ok := aacProfiles[s.Profile]
+			if !ok {
+				return ""
+			}
+			codecs = append(codecs, fmt.Sprintf("mp4a.40.%d", objectType))
+		case s.CodecType == CodecTypeVideo || s.CodecType == CodecTypeAudio:
+			return ""
+		}
+	}
+	return strings.Join(codecs, ",")
+}
+
+// iframeLength 分片中第一个视频关键帧结束的位置，即第二个视频包的偏移，I 帧播放列表通过 BYTERANGE 只引用分片开头到该位置的内容；
+// fMP4 分片需要拼接 init 才能解析，偏移扣除 init 的大小；无法确定时返回整个分片的大小
+func iframeLength(ctx context.Context, segmentPath, initPath string) int64 {
+	size := fs.GetFileSizeNoErr(segmentPath)
+	input, offset := segmentPath, int64(0)
+	if initPath != "" {
+		input, offset = "concat:"+initPath+"|"+segmentPath, fs.GetFileSizeNoErr(initPath)
+	}
+	cmd := []string{
+		ffprobeBin,
+		"-loglevel", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pos",
+		"-read_intervals", "%+#2",
+		"-of", "csv=p=0",
+		input,
+	}
+	output, err := fs.RunSysCommandRet(ctx, cmd, nil)
+	if err != nil {
+		return size
+	}
+	positions := strings.Fields(string(output))
+	if len(positions) < 2 {
+		return size
+	}
+	end := algorithm.ParseInt(positions[1], 0) - offset
+	if end <= 0 || end > size {
+		return size
+	}
+	return end
+}
+
+// writeIFramePlaylist 为 variant 生成 I 帧播放列表，每个分片引用开头的关键帧，返回峰值码率
+func writeIFramePlaylist(ctx context.Context, dir string, media *hls.MediaPlaylist, version int) (int64, error) {
+	initPath := ""
+	if uri := initURI(media); uri != "" {
+		initPath = filepath.Join(dir, uri)
+	}
+	iframes := &hls.MediaPlaylist{
+		Version:      version,
+		PlaylistType: "VOD",
+		IFramesOnly:  true,
+		Endlist:      true,
+	}
+	var bandwidth int64
+	var target float64
+	for _, segment := range media.Segments {
+		length := iframeLength(ctx, filepath.Join(dir, segment.URI), initPath)
+		if segment.Duration > 0 {
+			bandwidth = algorithm.MaxInt64(bandwidth, int64(float64(length*8)/segment.Duration))
+		}
+		if segment.Duration > target {
+			target = segment.Duration
+		}
+		iframes.Segments = append(iframes.Segments, &hls.Segment{
+			URI:       segment.URI,
+			Duration:  segment.Duration,
+			ByteRange: &hls.ByteRange{Length: length},
+			Map:       segment.Map,
+		})
+	}
+	iframes.TargetDuration = int(target + 0.999)
+	return bandwidth, hls.WriteFile(filepath.Join(dir, hlsIFramePlaylist), iframes)
+}
+
+// hlsCommand 输出一档 HLS 的命令，每个分片以关键帧开始
+func hlsCommand(inputPath, dir string, r Rendition, o *HLSOptions, duration time.Duration) *Command {
+	segmentSeconds := o.SegmentDuration.Seconds()
+	segmentType, segmentExt := "mpegts", ".ts"
+	if o.FMP4 {
+		segmentType, segmentExt = "fmp4", ".m4s"
+	}
+	cmd := NewCommand().Overwrite().LogLevel("error").TotalDuration(duration)
+	cmd.Input(inputPath)
+	output := cmd.Output(filepath.Join(dir, hlsMediaPlaylist)).
+		Map("0:v:0").
+		VideoFilter(NewFilter("scale", strconv.Itoa(r.Width), strconv.Itoa(r.Height))).
+		VideoCodec(o.VideoCodec).
+		Option("-preset", o.Preset).
+		Bitrate(StreamVideo, strconv.FormatInt(r.VideoBitrate, 10)).
+		Option("-maxrate", strconv.FormatInt(r.VideoBitrate*107/100, 10)).
+		Option("-bufsize", strconv.FormatInt(r.VideoBitrate*3/2, 10)).
+		Option("-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%g)", segmentSeconds)).
+		Option("-sc_threshold", "0")
+	if r.AudioBitrate > 0 {
+		output.Map("0:a:0").AudioCodec(o.AudioCodec).Bitrate(StreamAudio, strconv.FormatInt(r.AudioBitrate, 10))
+	}
+	output.Format("hls").
+		Option("-hls_time", fmt.Sprintf("%g", segmentSeconds)).
+		Option("-hls_playlist_type", "vod").
+		Option("-hls_flags", "independent_segments").
+		Option("-hls_segment_type", segmentType).
+		Option("-hls_segment_filename", filepath.Join(dir, "segment_%05d"+segmentExt))
+	if o.FMP4 {
+		output.Option("-hls_fmp4_init_filename", hlsInitSegment)
+	}
+	return cmd
+}
+
+// measureVariant 根据生成的分片统计码率及编码
+func measureVariant(ctx context.Context, v *HLSVariant, dir string, media *hls.MediaPlaylist) {
+	var total int64
+	var seconds float64
+	for _, segment := range media.Segments {
+		size := fs.GetFileSizeNoErr(filepath.Join(dir, segment.URI))
+		total += size
+		seconds += segment.Duration
+		if segment.Duration > 0 {
+			v.Bandwidth = algorithm.MaxInt64(v.Bandwidth, int64(float64(size*8)/segment.Duration))
+		}
+	}
+	if seconds > 0 {
+		v.AverageBandwidth = int64(float64(total*8) / seconds)
+	}
+	// fMP4 的编码信息在 init 中
+	probePath := filepath.Join(dir, media.Segments[0].URI)
+	if uri := initURI(media); uri != "" {
+		probePath = filepath.Join(dir, uri)
+	}
+	if info, err := Probe(ctx, probePath); err == nil {
+		v.Codecs = codecsTag(info)
+	}
+}
+
+// masterPlaylist 生成引用所有档位的主播放列表，uri 相对于 outputDir
+func masterPlaylist(variants []HLSVariant, outputDir string, version int) *hls.MasterPlaylist {
+	rel := func(path string) string {
+		if r, err := filepath.Rel(outputDir, path); err == nil {
+			return filepath.ToSlash(r)
+		}
+		return path
+	}
+	master := &hls.MasterPlaylist{Version: version, IndependentSegments: true}
+	for _, v := range variants {
+		master.Variants = append(master.Variants, &hls.Variant{
+			URI:              rel(v.Playlist),
+			Bandwidth:        v.Bandwidth,
+			AverageBandwidth: v.AverageBandwidth,
+			Codecs:           v.Codecs,
+			Width:            v.Width,
+			Height:           v.Height,
+		})
+		if v.IFramePlaylist != "" {
+			master.IFrameVariants = append(master.IFrameVariants, &hls.Variant{
+				URI:       rel(v.IFramePlaylist),
+				Bandwidth: v.IFrameBandwidth,
+				Codecs:    v.Codecs,
+				Width:     v.Width,
+				Height:    v.Height,
+			})
+		}
+	}
+	return master
+}
+
+// PackageHLS 将 inputPath 按照码率阶梯转码打包为 HLS 保存到 outputDir：每档一个子目录，包含分片及媒体播放列表，
+// 可选 I 帧播放列表，outputDir 下生成引用所有档位的主播放列表。每档单独执行一次 ffmpeg，进度回调参见 ContextWithProgress
+func PackageHLS(ctx context.Context, inputPath, outputDir string, opts *HLSOptions) (*HLSPackage, error) {
+	o := HLSOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.SegmentDuration <= 0 {
+		o.SegmentDuration = defaultSegmentDuration
+	}
+	if o.VideoCodec == "" {
+		o.VideoCodec = defaultHLSVideoCodec
+	}
+	if o.AudioCodec == "" {
+		o.AudioCodec = defaultHLSAudioCodec
+	}
+	if o.Preset == "" {
+		o.Preset = defaultHLSPreset
+	}
+	if o.Ladder == nil {
+		o.Ladder = DefaultLadder
+	}
+
+	info, duration, err := probeDuration(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+	ladder := BuildLadder(info, o.Ladder)
+	if len(ladder) == 0 {
+		return nil, fmt.Errorf("no video stream in %s", inputPath)
+	}
+	version := 4 // EXT-X-BYTERANGE 及 EXT-X-I-FRAMES-ONLY 需要
+	if o.FMP4 {
+		version = 7
+	}
+
+	pkg := &HLSPackage{MasterPlaylist: filepath.Join(outputDir, hlsMasterPlaylist)}
+	for _, r := range ladder {
+		dir := filepath.Join(outputDir, r.Name)
+		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+		if err = hlsCommand(inputPath, dir, r, &o, duration).Run(ctx); err != nil {
+			return nil, err
+		}
+		v := HLSVariant{Rendition: r, Playlist: filepath.Join(dir, hlsMediaPlaylist)}
+		media, err := readMediaPlaylist(v.Playlist)
+		if err != nil {
+			return nil, err
+		}
+		measureVariant(ctx, &v, dir, media)
+		if o.IFramePlaylists {
+			if v.IFrameBandwidth, err = writeIFramePlaylist(ctx, dir, media, version); err != nil {
+				return nil, err
+			}
+			v.IFramePlaylist = filepath.Join(dir, hlsIFramePlaylist)
+		}
+		pkg.Variants = append(pkg.Variants, v)
+	}
+	if err = hls.WriteFile(pkg.MasterPlaylist, masterPlaylist(pkg.Variants, outputDir, version)); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
@@ -0,0 +1,439 @@
+package hls
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 支持的标签
+const (
+	tagHeader           = "EXTM3U"
+	tagVersion          = "EXT-X-VERSION"
+	tagIndependent      = "EXT-X-INDEPENDENT-SEGMENTS"
+	tagTargetDuration   = "EXT-X-TARGETDURATION"
+	tagMediaSequence    = "EXT-X-MEDIA-SEQUENCE"
+	tagDiscontinuitySeq = "EXT-X-DISCONTINUITY-SEQUENCE"
+	tagPlaylistType     = "EXT-X-PLAYLIST-TYPE"
+	tagIFramesOnly      = "EXT-X-I-FRAMES-ONLY"
+	tagEndlist          = "EXT-X-ENDLIST"
+	tagInf              = "EXTINF"
+	tagByteRange        = "EXT-X-BYTERANGE"
+	tagDiscontinuity    = "EXT-X-DISCONTINUITY"
+	tagKey              = "EXT-X-KEY"
+	tagMap              = "EXT-X-MAP"
+	tagMedia            = "EXT-X-MEDIA"
+	tagStreamInf        = "EXT-X-STREAM-INF"
+	tagIFrameStreamInf  = "EXT-X-I-FRAME-STREAM-INF"
+)
+
+const (
+	maxLineSize = 1 << 20
+	valueYes    = "YES"
+	valueNo     = "NO"
+	valueNone   = "NONE" // 未加密的 METHOD，没有字幕的 CLOSED-CAPTIONS
+)
+
+// Decode 解析播放列表，包含 EXT-X-STREAM-INF、EXT-X-MEDIA 等标签时返回 *MasterPlaylist，否则返回 *MediaPlaylist
+func Decode(r io.Reader) (Playlist, error) {
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if isMaster(lines) {
+		return decodeMaster(lines)
+	}
+	return decodeMedia(lines)
+}
+
+// DecodeMaster 解析主播放列表
+func DecodeMaster(r io.Reader) (*MasterPlaylist, error) {
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMaster(lines)
+}
+
+// DecodeMedia 解析媒体播放列表
+func DecodeMedia(r io.Reader) (*MediaPlaylist, error) {
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMedia(lines)
+}
+
+// readLines 读取所有非空行，去掉开头的 #EXTM3U
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(lines) == 0 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || lines[0] != "#"+tagHeader {
+		return nil, errors.New("missing #EXTM3U")
+	}
+	return lines[1:], nil
+}
+
+// splitTag 将 #EXT-X-KEY:METHOD=NONE 拆分为 EXT-X-KEY 及 METHOD=NONE
+func splitTag(line string) (name, value string) {
+	name, value, _ = strings.Cut(line[1:], ":")
+	return name, value
+}
+
+// isMaster 根据第一个能区分类型的标签判断是否为主播放列表
+func isMaster(lines []string) bool {
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch name, _ := splitTag(line); name {
+		case tagStreamInf, tagIFrameStreamInf, tagMedia:
+			return true
+		case tagInf, tagTargetDuration:
+			return false
+		}
+	}
+	return false
+}
+
+// parseAttributes 解析属性列表，比如 BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2"
+func parseAttributes(s string) ([]Attribute, error) {
+	var attrs []Attribute
+	rest := s
+	for rest != "" {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid attribute list %q", s)
+		}
+		if strings.HasPrefix(value, `"`) {
+			end := strings.IndexByte(value[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted string in %q", s)
+			}
+			value, rest = value[:end+2], value[end+2:]
+			if rest != "" && rest[0] != ',' {
+				return nil, fmt.Errorf("invalid attribute list %q", s)
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+		}
+		attrs = append(attrs, Attribute{Key: strings.TrimSpace(key), Value: value})
+	}
+	return attrs, nil
+}
+
+// unquote 去掉带引号字符串的引号
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// decodeKey 解析 EXT-X-KEY 的属性
+func decodeKey(value string) (*Key, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	k := &Key{}
+	for _, a := range attrs {
+		k.order = append(k.order, a.Key)
+		switch a.Key {
+		case "METHOD":
+			k.Method = a.Value
+		case "URI":
+			k.URI = unquote(a.Value)
+		case "IV":
+			k.IV = a.Value
+		case "KEYFORMAT":
+			k.KeyFormat = unquote(a.Value)
+		case "KEYFORMATVERSIONS":
+			k.KeyFormatVersions = unquote(a.Value)
+		default:
+			k.Extra = append(k.Extra, a)
+		}
+	}
+	if k.Method == "" {
+		return nil, errors.New("missing METHOD")
+	}
+	return k, nil
+}
+
+// decodeMap 解析 EXT-X-MAP 的属性
+func decodeMap(value string) (*Map, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	m := &Map{}
+	for _, a := range attrs {
+		m.order = append(m.order, a.Key)
+		switch a.Key {
+		case "URI":
+			m.URI = unquote(a.Value)
+		case "BYTERANGE":
+			if m.ByteRange, err = parseByteRange(unquote(a.Value)); err != nil {
+				return nil, err
+			}
+		default:
+			m.Extra = append(m.Extra, a)
+		}
+	}
+	if m.URI == "" {
+		return nil, errors.New("missing URI")
+	}
+	return m, nil
+}
+
+// decodeRendition 解析 EXT-X-MEDIA 的属性
+func decodeRendition(value string) (*Rendition, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	r := &Rendition{}
+	for _, a := range attrs {
+		r.order = append(r.order, a.Key)
+		switch a.Key {
+		case "TYPE":
+			r.Type = a.Value
+		case "GROUP-ID":
+			r.GroupID = unquote(a.Value)
+		case "NAME":
+			r.Name = unquote(a.Value)
+		case "LANGUAGE":
+			r.Language = unquote(a.Value)
+		case "ASSOC-LANGUAGE":
+			r.AssocLanguage = unquote(a.Value)
+		case "DEFAULT":
+			r.Default = a.Value == valueYes
+		case "AUTOSELECT":
+			r.Autoselect = a.Value == valueYes
+		case "FORCED":
+			r.Forced = a.Value == valueYes
+		case "INSTREAM-ID":
+			r.InstreamID = unquote(a.Value)
+		case "CHARACTERISTICS":
+			r.Characteristics = unquote(a.Value)
+		case "CHANNELS":
+			r.Channels = unquote(a.Value)
+		case "URI":
+			r.URI = unquote(a.Value)
+		default:
+			r.Extra = append(r.Extra, a)
+		}
+	}
+	return r, nil
+}
+
+// decodeVariant 解析 EXT-X-STREAM-INF 及 EXT-X-I-FRAME-STREAM-INF 的属性
+func decodeVariant(value string) (*Variant, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	v := &Variant{}
+	for _, a := range attrs {
+		v.order = append(v.order, a.Key)
+		switch a.Key {
+		case "BANDWIDTH":
+			v.Bandwidth, err = strconv.ParseInt(a.Value, 10, 64)
+		case "AVERAGE-BANDWIDTH":
+			v.AverageBandwidth, err = strconv.ParseInt(a.Value, 10, 64)
+		case "CODECS":
+			v.Codecs = unquote(a.Value)
+		case "RESOLUTION":
+			width, height, _ := strings.Cut(a.Value, "x")
+			if v.Width, err = strconv.Atoi(width); err == nil {
+				v.Height, err = strconv.Atoi(height)
+			}
+		case "FRAME-RATE":
+			v.rawFrameRate = a.Value
+			v.FrameRate, err = strconv.ParseFloat(a.Value, 64)
+		case "HDCP-LEVEL":
+			v.HDCPLevel = a.Value
+		case "AUDIO":
+			v.Audio = unquote(a.Value)
+		case "VIDEO":
+			v.Video = unquote(a.Value)
+		case "SUBTITLES":
+			v.Subtitles = unquote(a.Value)
+		case "CLOSED-CAPTIONS":
+			v.ClosedCaptions = unquote(a.Value)
+		case "URI":
+			v.URI = unquote(a.Value)
+		default:
+			v.Extra = append(v.Extra, a)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", a.Key, a.Value)
+		}
+	}
+	return v, nil
+}
+
+// decodeMedia 解析媒体播放列表，EXT-X-KEY 及 EXT-X-MAP 对之后的分片一直生效
+func decodeMedia(lines []string) (*MediaPlaylist, error) {
+	p := &MediaPlaylist{}
+	var key *Key
+	var m *Map
+	segment := &Segment{}
+	// 出现分片相关的标签之后，无法识别的标签属于下一个分片
+	inSegments := false
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			segment.URI, segment.Key, segment.Map = line, key, m
+			p.Segments = append(p.Segments, segment)
+			segment, inSegments = &Segment{}, true
+			continue
+		}
+		var err error
+		name, value := splitTag(line)
+		header := true
+		switch name {
+		case tagVersion:
+			p.Version, err = strconv.Atoi(value)
+		case tagTargetDuration:
+			p.TargetDuration, err = strconv.Atoi(value)
+		case tagMediaSequence:
+			p.MediaSequence, err = strconv.ParseInt(value, 10, 64)
+		case tagDiscontinuitySeq:
+			p.DiscontinuitySequence, err = strconv.ParseInt(value, 10, 64)
+		case tagPlaylistType:
+			p.PlaylistType = value
+		case tagIFramesOnly:
+			p.IFramesOnly = true
+		case tagIndependent:
+			p.IndependentSegments = true
+		case tagEndlist:
+			p.Endlist = true
+			continue
+		default:
+			header = false
+		}
+		if header {
+			if err != nil {
+				return nil, fmt.Errorf("%s => %w", line, err)
+			}
+			p.headerOrder = append(p.headerOrder, name)
+			continue
+		}
+
+		switch name {
+		case tagInf:
+			duration, title, _ := strings.Cut(value, ",")
+			segment.rawDuration, segment.Title = duration, title
+			segment.Duration, err = strconv.ParseFloat(duration, 64)
+		case tagByteRange:
+			segment.ByteRange, err = parseByteRange(value)
+		case tagDiscontinuity:
+			segment.Discontinuity = true
+		case tagKey:
+			key, err = decodeKey(value)
+		case tagMap:
+			m, err = decodeMap(value)
+		default:
+			if inSegments {
+				segment.Tags = append(segment.Tags, line)
+				segment.order = append(segment.order, "")
+			} else {
+				p.Tags = append(p.Tags, line)
+				p.headerOrder = append(p.headerOrder, "")
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s => %w", line, err)
+		}
+		segment.order = append(segment.order, name)
+		inSegments = true
+	}
+	// 最后一个分片之后无法识别的标签
+	p.trailer = segment.Tags
+	return p, nil
+}
+
+// decodeMaster 解析主播放列表，无法识别的标签属于下一个 EXT-X-MEDIA 或码流
+func decodeMaster(lines []string) (*MasterPlaylist, error) {
+	p := &MasterPlaylist{}
+	var tags []string
+	var variant *Variant
+	inVariants := false
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			if variant == nil {
+				return nil, fmt.Errorf("unexpected URI %s", line)
+			}
+			variant.URI = line
+			p.Variants = append(p.Variants, variant)
+			p.items = append(p.items, masterItem{variant: variant})
+			variant = nil
+			continue
+		}
+		var err error
+		name, value := splitTag(line)
+		switch name {
+		case tagVersion:
+			if p.Version, err = strconv.Atoi(value); err == nil {
+				p.headerOrder = append(p.headerOrder, name)
+			}
+		case tagIndependent:
+			p.IndependentSegments = true
+			p.headerOrder = append(p.headerOrder, name)
+		case tagMedia:
+			var r *Rendition
+			if r, err = decodeRendition(value); err == nil {
+				r.Tags, tags = tags, nil
+				p.Media = append(p.Media, r)
+				p.items = append(p.items, masterItem{media: r})
+			}
+			inVariants = true
+		case tagStreamInf:
+			if variant, err = decodeVariant(value); err == nil {
+				variant.Tags, tags = tags, nil
+			}
+			inVariants = true
+		case tagIFrameStreamInf:
+			var v *Variant
+			if v, err = decodeVariant(value); err == nil {
+				v.Tags, tags = tags, nil
+				p.IFrameVariants = append(p.IFrameVariants, v)
+				p.items = append(p.items, masterItem{variant: v, iframe: true})
+			}
+			inVariants = true
+		default:
+			if inVariants {
+				tags = append(tags, line)
+			} else {
+				p.Tags = append(p.Tags, line)
+				p.headerOrder = append(p.headerOrder, "")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s => %w", line, err)
+		}
+	}
+	if variant != nil {
+		return nil, errors.New("missing URI of the last EXT-X-STREAM-INF")
+	}
+	p.trailer = tags
+	return p, nil
+}
@@ -0,0 +1,112 @@
+package hls
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseAttributes(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []Attribute
+		wantErr bool
+	}{
+		{
+			"quoted_comma",
+			`BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=640x360`,
+			[]Attribute{{"BANDWIDTH", "1280000"}, {"CODECS", `"avc1.4d401f,mp4a.40.2"`}, {"RESOLUTION", "640x360"}},
+			false,
+		},
+		{"quoted_last", `METHOD=AES-128,URI="key.bin"`, []Attribute{{"METHOD", "AES-128"}, {"URI", `"key.bin"`}}, false},
+		{"empty", "", nil, false},
+		{"unterminated", `URI="key.bin`, nil, true},
+		{"missing_value", "METHOD", nil, true},
+		{"garbage_after_quote", `URI="a"b,METHOD=NONE`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAttributes(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAttributes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAttributes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMedia(t *testing.T) {
+	content := "#EXTM3U\r\n#EXT-X-VERSION:4\r\n#EXT-X-TARGETDURATION:6\r\n#EXT-X-MEDIA-SEQUENCE:10\r\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\",IV=0x1\r\n" +
+		"#EXTINF:6.006,first\r\n#EXT-X-BYTERANGE:1000@0\r\nall.ts\r\n" +
+		"#EXTINF:4,\r\n#EXT-X-BYTERANGE:500\r\nall.ts\r\n" +
+		"\r\n#EXT-X-DISCONTINUITY\r\n#EXT-X-KEY:METHOD=NONE\r\n#EXTINF:2.5,\r\nad.ts\r\n#EXT-X-ENDLIST\r\n"
+	p, err := DecodeMedia(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("DecodeMedia() error = %v", err)
+	}
+	if p.Version != 4 || p.TargetDuration != 6 || p.MediaSequence != 10 || !p.Endlist || len(p.Segments) != 3 {
+		t.Fatalf("DecodeMedia() = %+v", p)
+	}
+	first, second, third := p.Segments[0], p.Segments[1], p.Segments[2]
+	if first.Duration != 6.006 || first.Title != "first" || *first.ByteRange != (ByteRange{1000, 0}) {
+		t.Errorf("DecodeMedia() first segment = %+v", first)
+	}
+	if *second.ByteRange != (ByteRange{500, -1}) || second.Key != first.Key || second.Key.URI != "key.bin" {
+		t.Errorf("DecodeMedia() second segment = %+v", second)
+	}
+	if !third.Discontinuity || third.Key.Method != valueNone || third.URI != "ad.ts" {
+		t.Errorf("DecodeMedia() third segment = %+v", third)
+	}
+}
+
+func TestDecodeMaster(t *testing.T) {
+	content := "#EXTM3U\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\",DEFAULT=YES,URI=\"en/index.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1280000,RESOLUTION=1280x720,FRAME-RATE=29.970,AUDIO=\"aac\",CLOSED-CAPTIONS=NONE\n" +
+		"720p/index.m3u8\n" +
+		"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=86000,URI=\"720p/iframes.m3u8\"\n"
+	p, err := Decode(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	master, ok := p.(*MasterPlaylist)
+	if !ok {
+		t.Fatalf("Decode() = %T, want *MasterPlaylist", p)
+	}
+	if len(master.Media) != 1 || !master.Media[0].Default || master.Media[0].URI != "en/index.m3u8" {
+		t.Errorf("Decode() media = %+v", master.Media)
+	}
+	v := master.Variants[0]
+	if v.Bandwidth != 1280000 || v.Width != 1280 || v.Height != 720 || v.FrameRate != 29.97 ||
+		v.Audio != "aac" || v.ClosedCaptions != valueNone || v.URI != "720p/index.m3u8" {
+		t.Errorf("Decode() variant = %+v", v)
+	}
+	if len(master.IFrameVariants) != 1 || master.IFrameVariants[0].URI != "720p/iframes.m3u8" {
+		t.Errorf("Decode() iframe variants = %+v", master.IFrameVariants)
+	}
+}
+
+func TestDecode_error(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"empty", ""},
+		{"missing_header", "#EXTINF:6,\na.ts\n"},
+		{"invalid_duration", "#EXTM3U\n#EXTINF:abc,\na.ts\n"},
+		{"key_without_method", "#EXTM3U\n#EXT-X-KEY:URI=\"key.bin\"\n#EXTINF:6,\na.ts\n"},
+		{"variant_without_uri", "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\n"},
+		{"invalid_resolution", "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1,RESOLUTION=720p\na.m3u8\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(strings.NewReader(tt.content)); err == nil {
+				t.Errorf("Decode() should fail")
+			}
+		})
+	}
+}
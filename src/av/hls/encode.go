@@ -0,0 +1,370 @@
+package hls
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 浮点数的默认精度，比如 EXTINF 的时长
+const defaultPrecision = 3
+
+// tag 待输出的一行标签
+type tag struct {
+	name string
+	line string
+	set  bool
+}
+
+// attr 待输出的一个属性，value 为原始值
+type attr struct {
+	key   string
+	value string
+	set   bool
+}
+
+func quotedAttr(key, value string) attr {
+	return attr{key, `"` + value + `"`, value != ""}
+}
+
+func enumAttr(key, value string) attr {
+	return attr{key, value, value != ""}
+}
+
+func intAttr(key string, value int64) attr {
+	return attr{key, strconv.FormatInt(value, 10), value != 0}
+}
+
+func boolAttr(key string, value bool) attr {
+	if value {
+		return attr{key, valueYes, true}
+	}
+	return attr{key, valueNo, false}
+}
+
+// contains order 中是否包含 name
+func contains(order []string, name string) bool {
+	for _, o := range order {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+// arrange 按照解析时的顺序 order 排列 names，不在 order 中的名称插入到第一个在 names 中排在其后的名称之前，
+// 新建的播放列表 order 为空，即按照 names 的顺序；order 中的空字符串表示无法识别的标签
+func arrange(names, order []string) []string {
+	rank := make(map[string]int, len(names))
+	for i, name := range names {
+		rank[name] = i
+	}
+	result := append([]string(nil), order...)
+	for i, name := range names {
+		if contains(order, name) {
+			continue
+		}
+		pos := len(result)
+		for j, o := range result {
+			if r, ok := rank[o]; ok && o != "" && r > i {
+				pos = j
+				break
+			}
+		}
+		result = append(result[:pos], append([]string{name}, result[pos:]...)...)
+	}
+	return result
+}
+
+// formatDecimal 格式化浮点数，与解析时的值相同时输出原始值，保持格式不变
+func formatDecimal(value float64, raw string) string {
+	if raw != "" {
+		if v, err := strconv.ParseFloat(raw, 64); err == nil && v == value {
+			return raw
+		}
+	}
+	return strconv.FormatFloat(value, 'f', defaultPrecision, 64)
+}
+
+// writeTags 按照 order 输出 tags 中需要输出的标签，以及无法识别的标签 unknown
+func writeTags(b *strings.Builder, tags []tag, unknown []string, order []string) {
+	names := make([]string, len(tags))
+	byName := make(map[string]tag, len(tags))
+	for i, t := range tags {
+		names[i] = t.name
+		byName[t.name] = t
+	}
+	for _, name := range arrange(names, order) {
+		if name == "" {
+			if len(unknown) > 0 {
+				b.WriteString(unknown[0] + "\n")
+				unknown = unknown[1:]
+			}
+			continue
+		}
+		if t := byName[name]; t.set {
+			b.WriteString(t.line + "\n")
+		}
+	}
+	for _, line := range unknown {
+		b.WriteString(line + "\n")
+	}
+}
+
+// encodeAttributes 生成属性列表，解析时存在的属性即使是零值也保留，无法识别的属性 extra 原样输出
+func encodeAttributes(attrs []attr, extra []Attribute, order []string) string {
+	for _, a := range extra {
+		attrs = append(attrs, attr{a.Key, a.Value, true})
+	}
+	names := make([]string, len(attrs))
+	byKey := make(map[string]attr, len(attrs))
+	for i, a := range attrs {
+		names[i] = a.key
+		byKey[a.key] = a
+	}
+	var parts []string
+	for _, key := range arrange(names, order) {
+		a, ok := byKey[key]
+		if !ok {
+			continue
+		}
+		if a.set || (contains(order, key) && a.value != "" && a.value != `""`) {
+			parts = append(parts, a.key+"="+a.value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (k *Key) String() string {
+	attrs := []attr{
+		{"METHOD", k.Method, true},
+		quotedAttr("URI", k.URI),
+		enumAttr("IV", k.IV),
+		quotedAttr("KEYFORMAT", k.KeyFormat),
+		quotedAttr("KEYFORMATVERSIONS", k.KeyFormatVersions),
+	}
+	return "#" + tagKey + ":" + encodeAttributes(attrs, k.Extra, k.order)
+}
+
+func (m *Map) String() string {
+	attrs := []attr{quotedAttr("URI", m.URI)}
+	if m.ByteRange != nil {
+		attrs = append(attrs, quotedAttr("BYTERANGE", m.ByteRange.String()))
+	}
+	return "#" + tagMap + ":" + encodeAttributes(attrs, m.Extra, m.order)
+}
+
+func (r *Rendition) String() string {
+	attrs := []attr{
+		enumAttr("TYPE", r.Type),
+		quotedAttr("GROUP-ID", r.GroupID),
+		quotedAttr("NAME", r.Name),
+		quotedAttr("LANGUAGE", r.Language),
+		quotedAttr("ASSOC-LANGUAGE", r.AssocLanguage),
+		boolAttr("DEFAULT", r.Default),
+		boolAttr("AUTOSELECT", r.Autoselect),
+		boolAttr("FORCED", r.Forced),
+		quotedAttr("INSTREAM-ID", r.InstreamID),
+		quotedAttr("CHARACTERISTICS", r.Characteristics),
+		quotedAttr("CHANNELS", r.Channels),
+		quotedAttr("URI", r.URI),
+	}
+	return "#" + tagMedia + ":" + encodeAttributes(attrs, r.Extra, r.order)
+}
+
+// attributes 生成码流的属性列表，iframe 为 true 时包含 URI
+func (v *Variant) attributes(iframe bool) string {
+	resolution := ""
+	if v.Width > 0 && v.Height > 0 {
+		resolution = fmt.Sprintf("%dx%d", v.Width, v.Height)
+	}
+	closedCaptions := quotedAttr("CLOSED-CAPTIONS", v.ClosedCaptions)
+	if v.ClosedCaptions == valueNone {
+		closedCaptions = enumAttr("CLOSED-CAPTIONS", valueNone)
+	}
+	attrs := []attr{
+		{"BANDWIDTH", strconv.FormatInt(v.Bandwidth, 10), true},
+		intAttr("AVERAGE-BANDWIDTH", v.AverageBandwidth),
+		quotedAttr("CODECS", v.Codecs),
+		enumAttr("RESOLUTION", resolution),
+		{"FRAME-RATE", formatDecimal(v.FrameRate, v.rawFrameRate), v.FrameRate > 0},
+		enumAttr("HDCP-LEVEL", v.HDCPLevel),
+		quotedAttr("AUDIO", v.Audio),
+		quotedAttr("VIDEO", v.Video),
+		quotedAttr("SUBTITLES", v.Subtitles),
+		closedCaptions,
+	}
+	if iframe {
+		attrs = append(attrs, quotedAttr("URI", v.URI))
+	}
+	return encodeAttributes(attrs, v.Extra, v.order)
+}
+
+// 主播放列表中条目的种类
+const (
+	itemMedia = iota
+	itemVariant
+	itemIFrameVariant
+	itemKinds
+)
+
+func (i masterItem) kind() int {
+	switch {
+	case i.media != nil:
+		return itemMedia
+	case i.iframe:
+		return itemIFrameVariant
+	}
+	return itemVariant
+}
+
+func (i masterItem) write(b *strings.Builder) {
+	switch i.kind() {
+	case itemMedia:
+		writeLines(b, i.media.Tags)
+		b.WriteString(i.media.String() + "\n")
+	case itemVariant:
+		writeLines(b, i.variant.Tags)
+		b.WriteString("#" + tagStreamInf + ":" + i.variant.attributes(false) + "\n" + i.variant.URI + "\n")
+	default:
+		writeLines(b, i.variant.Tags)
+		b.WriteString("#" + tagIFrameStreamInf + ":" + i.variant.attributes(true) + "\n")
+	}
+}
+
+// orderedItems 按照解析时的顺序排列 Media、Variants 及 IFrameVariants，已删除的忽略，
+// 新增的紧接在同类的最后一个之后，没有同类时按照 Media、Variants、IFrameVariants 的顺序放在最后
+func (p *MasterPlaylist) orderedItems() []masterItem {
+	var groups [itemKinds][]masterItem
+	for _, r := range p.Media {
+		groups[itemMedia] = append(groups[itemMedia], masterItem{media: r})
+	}
+	for _, v := range p.Variants {
+		groups[itemVariant] = append(groups[itemVariant], masterItem{variant: v})
+	}
+	for _, v := range p.IFrameVariants {
+		groups[itemIFrameVariant] = append(groups[itemIFrameVariant], masterItem{variant: v, iframe: true})
+	}
+	current := map[masterItem]bool{}
+	for _, group := range groups {
+		for _, item := range group {
+			current[item] = true
+		}
+	}
+	parsed := map[masterItem]bool{}
+	last := [itemKinds]int{-1, -1, -1} // 每一类最后一个仍然存在的条目在 items 中的位置
+	for i, item := range p.items {
+		if current[item] {
+			parsed[item] = true
+			last[item.kind()] = i
+		}
+	}
+	var result []masterItem
+	appendNew := func(kind int) {
+		for _, item := range groups[kind] {
+			if !parsed[item] {
+				result = append(result, item)
+			}
+		}
+	}
+	for i, item := range p.items {
+		if !current[item] {
+			continue
+		}
+		result = append(result, item)
+		if i == last[item.kind()] {
+			appendNew(item.kind())
+		}
+	}
+	for kind := range groups {
+		if last[kind] < 0 {
+			appendNew(kind)
+		}
+	}
+	return result
+}
+
+// String 生成主播放列表，EXT-X-MEDIA 及码流按照解析时的顺序输出
+func (p *MasterPlaylist) String() string {
+	var b strings.Builder
+	b.WriteString("#" + tagHeader + "\n")
+	writeTags(&b, []tag{
+		{tagVersion, "#" + tagVersion + ":" + strconv.Itoa(p.Version), p.Version > 0},
+		{tagIndependent, "#" + tagIndependent, p.IndependentSegments},
+	}, p.Tags, p.headerOrder)
+	for _, item := range p.orderedItems() {
+		item.write(&b)
+	}
+	writeLines(&b, p.trailer)
+	return b.String()
+}
+
+// String 生成媒体播放列表，EXT-X-KEY 及 EXT-X-MAP 在与上一个分片不同或者解析时该分片之前存在时输出，
+// 保留不连续之后重复声明的相同标签
+func (p *MediaPlaylist) String() string {
+	var b strings.Builder
+	b.WriteString("#" + tagHeader + "\n")
+	writeTags(&b, []tag{
+		{tagVersion, "#" + tagVersion + ":" + strconv.Itoa(p.Version), p.Version > 0},
+		{tagTargetDuration, "#" + tagTargetDuration + ":" + strconv.Itoa(p.TargetDuration), true},
+		{
+			tagMediaSequence,
+			"#" + tagMediaSequence + ":" + strconv.FormatInt(p.MediaSequence, 10),
+			p.MediaSequence != 0 || contains(p.headerOrder, tagMediaSequence),
+		},
+		{
+			tagDiscontinuitySeq,
+			"#" + tagDiscontinuitySeq + ":" + strconv.FormatInt(p.DiscontinuitySequence, 10),
+			p.DiscontinuitySequence != 0 || contains(p.headerOrder, tagDiscontinuitySeq),
+		},
+		{tagPlaylistType, "#" + tagPlaylistType + ":" + p.PlaylistType, p.PlaylistType != ""},
+		{tagIFramesOnly, "#" + tagIFramesOnly, p.IFramesOnly},
+		{tagIndependent, "#" + tagIndependent, p.IndependentSegments},
+	}, p.Tags, p.headerOrder)
+
+	// 比较上一个分片生效的标签内容，分片之间共享的 Key 被修改后也能正确判断
+	var prevKey, prevMap string
+	for _, s := range p.Segments {
+		key := ""
+		if s.Key != nil {
+			key = s.Key.String()
+		} else if prevKey != "" {
+			// 之前的分片已加密，需要显式取消
+			key = "#" + tagKey + ":METHOD=" + valueNone
+		}
+		writeKey := key != "" && (key != prevKey || contains(s.order, tagKey))
+		prevKey = key
+		m := ""
+		if s.Map != nil {
+			m = s.Map.String()
+		}
+		writeMap := m != "" && (m != prevMap || contains(s.order, tagMap))
+		prevMap = m
+
+		title := "#" + tagInf + ":" + formatDecimal(s.Duration, s.rawDuration) + "," + s.Title
+		byteRange := ""
+		if s.ByteRange != nil {
+			byteRange = "#" + tagByteRange + ":" + s.ByteRange.String()
+		}
+		writeTags(&b, []tag{
+			{tagDiscontinuity, "#" + tagDiscontinuity, s.Discontinuity},
+			{tagKey, key, writeKey},
+			{tagMap, m, writeMap},
+			{tagInf, title, true},
+			{tagByteRange, byteRange, byteRange != ""},
+		}, s.Tags, s.order)
+		b.WriteString(s.URI + "\n")
+	}
+	writeLines(&b, p.trailer)
+	if p.Endlist {
+		b.WriteString("#" + tagEndlist + "\n")
+	}
+	return b.String()
+}
+
+// writeLines 原样输出无法识别的标签
+func writeLines(b *strings.Builder, lines []string) {
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+}
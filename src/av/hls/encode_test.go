@@ -0,0 +1,142 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecode_roundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			"ffmpeg_ts",
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n" +
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"enc.key\",IV=0x00000000000000000000000000000000\n" +
+				"#EXTINF:6.000000,\nsegment_00000.ts\n#EXTINF:3.360000,\nsegment_00001.ts\n#EXT-X-ENDLIST\n",
+		},
+		{
+			"fmp4_byterange",
+			"#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-VERSION:7\n#EXT-X-ALLOW-CACHE:YES\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+				"#EXT-X-MAP:URI=\"main.mp4\",BYTERANGE=\"720@0\"\n" +
+				"#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:00.000Z\n#EXTINF:4.0,title\n#EXT-X-BYTERANGE:1000@720\nmain.mp4\n" +
+				"#EXTINF:4.0,\n#EXT-X-BYTERANGE:900\nmain.mp4\n" +
+				"#EXT-X-KEY:METHOD=SAMPLE-AES,KEYFORMAT=\"com.apple.streamingkeydelivery\",URI=\"skd://key\",X-CUSTOM=1\n" +
+				"#EXT-X-DISCONTINUITY\n#EXTINF:2,\nhttps://cdn.example.com/ad.mp4\n#EXT-X-KEY:METHOD=NONE\n#EXTINF:2,\nlast.mp4\n",
+		},
+		{
+			"master",
+			"#EXTM3U\n#EXT-X-INDEPENDENT-SEGMENTS\n#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",VALUE=\"demo\"\n#EXT-X-VERSION:6\n" +
+				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",LANGUAGE=\"en\",NAME=\"English\",AUTOSELECT=YES,DEFAULT=NO,URI=\"en.m3u8\"\n" +
+				"#EXT-X-STREAM-INF:AVERAGE-BANDWIDTH=0,BANDWIDTH=2000000,CODECS=\"avc1.64001f,mp4a.40.2\",RESOLUTION=1280x720," +
+				"FRAME-RATE=29.970,AUDIO=\"aac\",CLOSED-CAPTIONS=NONE\n720p.m3u8\n" +
+				"# comment\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,SCORE=1.5\n360p.m3u8\n" +
+				"#EXT-X-I-FRAME-STREAM-INF:URI=\"720p_iframes.m3u8\",BANDWIDTH=100000\n#EXT-X-CONTENT-STEERING:SERVER-URI=\"s.json\"\n",
+		},
+		{
+			"master_interleaved",
+			"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000,AUDIO=\"aac\"\n360p.m3u8\n" +
+				"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=50000,URI=\"360p_iframes.m3u8\"\n" +
+				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\",URI=\"en.m3u8\"\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2000000,AUDIO=\"aac\"\n720p.m3u8\n",
+		},
+		{
+			"repeated_key_map",
+			"#EXTM3U\n#EXT-X-TARGETDURATION:4\n" +
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"k.bin\"\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:4,\na.m4s\n" +
+				"#EXT-X-DISCONTINUITY\n#EXT-X-KEY:METHOD=AES-128,URI=\"k.bin\"\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:4,\nb.m4s\n" +
+				"#EXTINF:4,\nc.m4s\n#EXT-X-ENDLIST\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Decode(strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got := p.String(); got != tt.content {
+				t.Errorf("String() = %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestMediaPlaylist_String(t *testing.T) {
+	key := &Key{Method: "AES-128", URI: "key.bin"}
+	p := &MediaPlaylist{
+		Version:        7,
+		TargetDuration: 6,
+		PlaylistType:   "VOD",
+		Endlist:        true,
+		Segments: []*Segment{
+			{URI: "a.m4s", Duration: 6, Key: key, Map: &Map{URI: "init.mp4"}},
+			{URI: "b.m4s", Duration: 5.5, Key: key, Map: &Map{URI: "init.mp4"}, ByteRange: &ByteRange{100, 0}},
+			{URI: "c.m4s", Duration: 1.25, Discontinuity: true, Map: &Map{URI: "init2.mp4"}},
+		},
+	}
+	want := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:6\n#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:6.000,\na.m4s\n" +
+		"#EXTINF:5.500,\n#EXT-X-BYTERANGE:100@0\nb.m4s\n" +
+		"#EXT-X-DISCONTINUITY\n#EXT-X-KEY:METHOD=NONE\n#EXT-X-MAP:URI=\"init2.mp4\"\n#EXTINF:1.250,\nc.m4s\n#EXT-X-ENDLIST\n"
+	if got := p.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestMasterPlaylist_String(t *testing.T) {
+	p := &MasterPlaylist{
+		Version:             4,
+		IndependentSegments: true,
+		Variants: []*Variant{
+			{URI: "720p/index.m3u8", Bandwidth: 2000000, AverageBandwidth: 1500000, Codecs: "avc1.64001f,mp4a.40.2", Width: 1280, Height: 720},
+		},
+		IFrameVariants: []*Variant{{URI: "720p/iframes.m3u8", Bandwidth: 90000, Width: 1280, Height: 720}},
+	}
+	want := "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000,AVERAGE-BANDWIDTH=1500000,CODECS=\"avc1.64001f,mp4a.40.2\",RESOLUTION=1280x720\n" +
+		"720p/index.m3u8\n" +
+		"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=90000,RESOLUTION=1280x720,URI=\"720p/iframes.m3u8\"\n"
+	if got := p.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestMasterPlaylist_StringEdited(t *testing.T) {
+	content := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\n360p.m3u8\n" +
+		"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=50000,URI=\"360p_iframes.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000\n720p.m3u8\n"
+	p, err := DecodeMaster(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("DecodeMaster() error = %v", err)
+	}
+	// 删除的条目不再输出，新增的紧接在同类的最后一个之后，没有同类的放在最后
+	p.Variants = append(p.Variants[1:], &Variant{URI: "1080p.m3u8", Bandwidth: 4000000})
+	p.Media = []*Rendition{{Type: "AUDIO", GroupID: "aac", Name: "English"}}
+	want := "#EXTM3U\n#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=50000,URI=\"360p_iframes.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000\n720p.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=4000000\n1080p.m3u8\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\"\n"
+	if got := p.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func Test_arrange(t *testing.T) {
+	names := []string{"A", "B", "C", "D"}
+	tests := []struct {
+		name  string
+		order []string
+		want  string
+	}{
+		{"new", nil, "A,B,C,D"},
+		{"parsed", []string{"C", "", "A"}, "B,C,,A,D"},
+		{"insert_before", []string{"D", "B"}, "A,C,D,B"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(arrange(names, tt.order), ","); got != tt.want {
+				t.Errorf("arrange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
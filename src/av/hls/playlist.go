@@ -0,0 +1,249 @@
+// Package hls 解析及生成 HLS 播放列表(m3u8)，支持主播放列表及媒体播放列表，参见 RFC 8216。
+// 无法识别的标签及属性原样保留，标签及属性的顺序、数值的格式在解析后再输出时保持不变
+package hls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Playlist 播放列表，*MasterPlaylist 或 *MediaPlaylist
+type Playlist interface {
+	String() string
+}
+
+// ReadFile 读取并解析播放列表文件
+func ReadFile(path string) (Playlist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s => %w", path, err)
+	}
+	return p, nil
+}
+
+// WriteFile 将播放列表写入文件，比如修改分片地址后交给 av.MergeTS 合并
+func WriteFile(path string, p Playlist) error {
+	return ioutil.WriteFile(path, []byte(p.String()), 0644)
+}
+
+// Attribute 属性列表中的一个属性，Value 为原始值，带引号的字符串包含引号
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// ByteRange 分片在资源中的字节范围，对应 EXT-X-BYTERANGE 及 EXT-X-MAP 的 BYTERANGE 属性
+type ByteRange struct {
+	Length int64
+	Offset int64 // 小于 0 表示未指定，紧接着上一个分片
+}
+
+func (r ByteRange) String() string {
+	if r.Offset < 0 {
+		return strconv.FormatInt(r.Length, 10)
+	}
+	return fmt.Sprintf("%d@%d", r.Length, r.Offset)
+}
+
+// parseByteRange 解析 <length>[@<offset>]
+func parseByteRange(s string) (*ByteRange, error) {
+	length, offset, hasOffset := strings.Cut(s, "@")
+	r := &ByteRange{Offset: -1}
+	var err error
+	if r.Length, err = strconv.ParseInt(length, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid byte range %q", s)
+	}
+	if hasOffset {
+		if r.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid byte range %q", s)
+		}
+	}
+	return r, nil
+}
+
+// Key 分片的加密方式，对应 EXT-X-KEY
+type Key struct {
+	Method            string // NONE、AES-128、SAMPLE-AES
+	URI               string
+	IV                string // 十六进制，比如 0x00000000000000000000000000000001
+	KeyFormat         string
+	KeyFormatVersions string
+	Extra             []Attribute // 无法识别的属性
+	order             []string    // 解析时属性的顺序
+}
+
+// Map 分片的初始化信息，比如 fMP4 的 init，对应 EXT-X-MAP
+type Map struct {
+	URI       string
+	ByteRange *ByteRange
+	Extra     []Attribute
+	order     []string
+}
+
+// Segment 媒体播放列表中的一个分片
+type Segment struct {
+	URI           string
+	Duration      float64 // 秒
+	Title         string
+	ByteRange     *ByteRange
+	Discontinuity bool     // 之前是否有 EXT-X-DISCONTINUITY
+	Key           *Key     // 生效的加密方式，之前的分片设置后一直生效，未加密时为 nil
+	Map           *Map     // 生效的初始化信息，同 Key
+	Tags          []string // 分片之前无法识别的标签，原样保留，比如 EXT-X-PROGRAM-DATE-TIME
+
+	rawDuration string   // 解析时 EXTINF 的原始时长，Duration 未修改时原样输出
+	order       []string // 解析时分片标签的顺序，无法识别的标签为空字符串
+}
+
+// MediaPlaylist 媒体播放列表
+type MediaPlaylist struct {
+	Version               int
+	TargetDuration        int // 秒
+	MediaSequence         int64
+	DiscontinuitySequence int64
+	PlaylistType          string // VOD、EVENT
+	IFramesOnly           bool
+	IndependentSegments   bool
+	Endlist               bool
+	Tags                  []string // 分片之前无法识别的标签，原样保留
+	Segments              []*Segment
+
+	headerOrder []string // 解析时头部标签的顺序，无法识别的标签为空字符串
+	trailer     []string // 最后一个分片之后无法识别的标签
+}
+
+// Duration 所有分片的总时长
+func (p *MediaPlaylist) Duration() time.Duration {
+	var seconds float64
+	for _, s := range p.Segments {
+		seconds += s.Duration
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// RewriteURIs 通过 fn 修改分片、EXT-X-KEY 及 EXT-X-MAP 的地址，比如将相对地址转换为绝对地址
+func (p *MediaPlaylist) RewriteURIs(fn func(uri string) string) {
+	keys := map[*Key]bool{}
+	maps := map[*Map]bool{}
+	for _, s := range p.Segments {
+		s.URI = fn(s.URI)
+		// 多个分片共享同一个 Key 及 Map
+		if s.Key != nil && !keys[s.Key] && s.Key.URI != "" {
+			keys[s.Key] = true
+			s.Key.URI = fn(s.Key.URI)
+		}
+		if s.Map != nil && !maps[s.Map] {
+			maps[s.Map] = true
+			s.Map.URI = fn(s.Map.URI)
+		}
+	}
+}
+
+// Trim 只保留与 [start, end) 有交集的分片，end <= 0 表示到结尾，MediaSequence 及 DiscontinuitySequence 相应增加
+func (p *MediaPlaylist) Trim(start, end time.Duration) {
+	var kept []*Segment
+	var offset time.Duration
+	for _, s := range p.Segments {
+		segStart := offset
+		offset += time.Duration(s.Duration * float64(time.Second))
+		if offset <= start || (end > 0 && segStart >= end) {
+			// 只有开头删除的分片影响序号
+			if len(kept) == 0 {
+				p.MediaSequence++
+				if s.Discontinuity {
+					p.DiscontinuitySequence++
+				}
+			}
+			continue
+		}
+		kept = append(kept, s)
+	}
+	p.Segments = kept
+}
+
+// Append 将 other 的分片追加到末尾，追加的第一个分片标记为 EXT-X-DISCONTINUITY，TargetDuration 取较大值
+func (p *MediaPlaylist) Append(other *MediaPlaylist) {
+	for i, s := range other.Segments {
+		segment := *s
+		if i == 0 && len(p.Segments) > 0 {
+			segment.Discontinuity = true
+		}
+		p.Segments = append(p.Segments, &segment)
+	}
+	if other.TargetDuration > p.TargetDuration {
+		p.TargetDuration = other.TargetDuration
+	}
+	if other.Version > p.Version {
+		p.Version = other.Version
+	}
+}
+
+// Rendition 备选的音频、视频、字幕等，对应主播放列表中的 EXT-X-MEDIA
+type Rendition struct {
+	Type            string // AUDIO、VIDEO、SUBTITLES、CLOSED-CAPTIONS
+	GroupID         string
+	Name            string
+	Language        string
+	AssocLanguage   string
+	Default         bool
+	Autoselect      bool
+	Forced          bool
+	InstreamID      string
+	Characteristics string
+	Channels        string
+	URI             string
+	Extra           []Attribute
+	Tags            []string // 之前无法识别的标签
+	order           []string
+}
+
+// Variant 主播放列表中的一路码流，对应 EXT-X-STREAM-INF 或 EXT-X-I-FRAME-STREAM-INF
+type Variant struct {
+	URI              string
+	Bandwidth        int64
+	AverageBandwidth int64
+	Codecs           string
+	Width            int
+	Height           int
+	FrameRate        float64
+	HDCPLevel        string
+	Audio            string
+	Video            string
+	Subtitles        string
+	ClosedCaptions   string // 组名，或者 NONE
+	Extra            []Attribute
+	Tags             []string // 之前无法识别的标签
+
+	order        []string
+	rawFrameRate string
+}
+
+// MasterPlaylist 主播放列表
+type MasterPlaylist struct {
+	Version             int
+	IndependentSegments bool
+	Tags                []string // 无法识别的标签，比如 EXT-X-SESSION-DATA
+	Media               []*Rendition
+	Variants            []*Variant // EXT-X-STREAM-INF
+	IFrameVariants      []*Variant // EXT-X-I-FRAME-STREAM-INF
+
+	headerOrder []string
+	items       []masterItem // 解析时 EXT-X-MEDIA 及码流的顺序
+	trailer     []string     // 最后一个码流之后无法识别的标签
+}
+
+// masterItem 主播放列表中的一个 EXT-X-MEDIA、EXT-X-STREAM-INF 或 EXT-X-I-FRAME-STREAM-INF
+type masterItem struct {
+	media   *Rendition
+	variant *Variant
+	iframe  bool
+}
@@ -0,0 +1,95 @@
+package hls
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMedia = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+	"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n" +
+	"#EXTINF:6.000,\n0.ts\n#EXTINF:6.000,\n1.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:6.000,\n2.ts\n#EXTINF:4.000,\n3.ts\n#EXT-X-ENDLIST\n"
+
+func decodeTestMedia(t *testing.T) *MediaPlaylist {
+	p, err := DecodeMedia(strings.NewReader(testMedia))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestMediaPlaylist_Trim(t *testing.T) {
+	p := decodeTestMedia(t)
+	p.Trim(13*time.Second, 17*time.Second)
+	// 删除开头的分片后 EXT-X-KEY 移到第一个保留的分片之前
+	want := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:2\n" +
+		"#EXT-X-DISCONTINUITY\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n#EXTINF:6.000,\n2.ts\n#EXT-X-ENDLIST\n"
+	if got := p.String(); got != want {
+		t.Errorf("Trim() = %q, want %q", got, want)
+	}
+	if got := p.Duration(); got != 6*time.Second {
+		t.Errorf("Duration() = %v, want %v", got, 6*time.Second)
+	}
+
+	// 删除的分片包含 EXT-X-DISCONTINUITY
+	p = decodeTestMedia(t)
+	p.Trim(19*time.Second, 0)
+	want = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:3\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n#EXTINF:4.000,\n3.ts\n#EXT-X-ENDLIST\n"
+	if got := p.String(); got != want {
+		t.Errorf("Trim() = %q, want %q", got, want)
+	}
+}
+
+func TestMediaPlaylist_RewriteURIs(t *testing.T) {
+	p := decodeTestMedia(t)
+	var rewritten []string
+	p.RewriteURIs(func(uri string) string {
+		rewritten = append(rewritten, uri)
+		return "https://cdn.example.com/v/" + uri
+	})
+	if got := strings.Join(rewritten, ","); got != "0.ts,key.bin,1.ts,2.ts,3.ts" {
+		t.Errorf("RewriteURIs() rewritten = %v", got)
+	}
+	if p.Segments[3].Key.URI != "https://cdn.example.com/v/key.bin" {
+		t.Errorf("RewriteURIs() key = %+v", p.Segments[3].Key)
+	}
+}
+
+func TestMediaPlaylist_Append(t *testing.T) {
+	p := decodeTestMedia(t)
+	other := &MediaPlaylist{TargetDuration: 10, Segments: []*Segment{{URI: "ad.ts", Duration: 10}}}
+	p.Append(other)
+	if len(p.Segments) != 5 || p.TargetDuration != 10 || !p.Segments[4].Discontinuity || other.Segments[0].Discontinuity {
+		t.Fatalf("Append() = %+v", p)
+	}
+	if got := p.Duration(); got != 32*time.Second {
+		t.Errorf("Duration() = %v, want %v", got, 32*time.Second)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "m3u8-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "index.m3u8")
+	if err = WriteFile(path, decodeTestMedia(t)); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if got := p.String(); got != testMedia {
+		t.Errorf("ReadFile() = %q, want %q", got, testMedia)
+	}
+	if _, err = ReadFile(filepath.Join(dir, "missing.m3u8")); err == nil {
+		t.Errorf("ReadFile() of missing file should fail")
+	}
+}
@@ -0,0 +1,192 @@
+package av
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+)
+
+func TestBuildLadder(t *testing.T) {
+	audio := Streams{CodecType: CodecTypeAudio}
+	tests := []struct {
+		name string
+		info *ProbeInfo
+		want []Rendition
+	}{
+		{
+			"landscape_cap_bitrate",
+			&ProbeInfo{Streams: []Streams{{CodecType: CodecTypeVideo, Width: 1280, Height: 720}, audio}, Format: Format{BitRate: "2000000"}},
+			[]Rendition{
+				{"720p", 1280, 720, 2000000, 128000},
+				{"480p", 854, 480, 1400000, 128000},
+				{"360p", 640, 360, 800000, 96000},
+				{"240p", 426, 240, 400000, 64000},
+			},
+		},
+		{
+			"portrait_no_audio",
+			&ProbeInfo{Streams: []Streams{{CodecType: CodecTypeVideo, Width: 640, Height: 360, Tags: Tags{Rotate: "90"}}}},
+			[]Rendition{
+				{"360p", 360, 640, 800000, 0},
+				{"240p", 240, 426, 400000, 0},
+			},
+		},
+		{
+			"lower_than_ladder",
+			&ProbeInfo{Streams: []Streams{{CodecType: CodecTypeVideo, Width: 200, Height: 113}, audio}},
+			[]Rendition{{"112p", 200, 112, 400000, 64000}},
+		},
+		{"no_video", &ProbeInfo{Streams: []Streams{audio}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BuildLadder(tt.info, DefaultLadder); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BuildLadder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_codecsTag(t *testing.T) {
+	h264 := Streams{CodecType: CodecTypeVideo, CodecName: "h264", Profile: "High", Level: 31}
+	tests := []struct {
+		name    string
+		streams []Streams
+		want    string
+	}{
+		{"h264_aac", []Streams{h264, {CodecType: CodecTypeAudio, CodecName: "aac", Profile: "LC"}}, "avc1.64001f,mp4a.40.2"},
+		{"baseline", []Streams{{CodecType: CodecTypeVideo, CodecName: "h264", Profile: "Constrained Baseline", Level: 30}}, "avc1.42e01e"},
+		{"he_aac", []Streams{{CodecType: CodecTypeAudio, CodecName: "aac", Profile: "HE-AAC"}}, "mp4a.40.5"},
+		{"unknown_audio", []Streams{h264, {CodecType: CodecTypeAudio, CodecName: "opus"}}, ""},
+		{"unknown_video", []Streams{{CodecType: CodecTypeVideo, CodecName: "vp9"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := codecsTag(&ProbeInfo{Streams: tt.streams}); got != tt.want {
+				t.Errorf("codecsTag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeHLS 模拟 ffmpeg 输出 HLS：每档两个分片，第一个 6 秒 300000 字节，第二个 4 秒 100000 字节
+func fakeHLS(_ context.Context, argv []string, _ io.Writer) error {
+	playlist := argv[len(argv)-1]
+	dir := filepath.Dir(playlist)
+	ext, header := ".ts", ""
+	for i, arg := range argv {
+		if arg == "-hls_segment_type" && argv[i+1] == "fmp4" {
+			ext, header = ".m4s", "#EXT-X-MAP:URI=\"init.mp4\"\n"
+			ioutil.WriteFile(filepath.Join(dir, "init.mp4"), make([]byte, 800), 0644)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(dir, "segment_00000"+ext), make([]byte, 300000), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "segment_00001"+ext), make([]byte, 100000), 0644)
+	content := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:6\n#EXT-X-PLAYLIST-TYPE:VOD\n" + header +
+		"#EXTINF:6.000000,\nsegment_00000" + ext + "\n#EXTINF:4.000000,\nsegment_00001" + ext + "\n#EXT-X-ENDLIST\n"
+	return ioutil.WriteFile(playlist, []byte(content), 0644)
+}
+
+func TestPackageHLS(t *testing.T) {
+	source := &ProbeInfo{
+		Streams: []Streams{{CodecType: CodecTypeVideo, Width: 854, Height: 480}, {CodecType: CodecTypeAudio}},
+		Format:  Format{Duration: "10"},
+	}
+	output := &ProbeInfo{Streams: []Streams{
+		{CodecType: CodecTypeVideo, CodecName: "h264", Profile: "High", Level: 30},
+		{CodecType: CodecTypeAudio, CodecName: "aac", Profile: "LC"},
+	}}
+	var probed []string
+	patches := gomonkey.ApplyFunc(Probe, func(_ context.Context, inputPath string) (*ProbeInfo, error) {
+		if inputPath == "/test/in.mp4" {
+			return source, nil
+		}
+		probed = append(probed, filepath.Base(inputPath))
+		return output, nil
+	})
+	var commands [][]string
+	patches = patches.ApplyFunc(runCommand, func(ctx context.Context, argv []string, w io.Writer) error {
+		commands = append(commands, argv)
+		return fakeHLS(ctx, argv, w)
+	})
+	patches = patches.ApplyFuncReturn(iframeLength, int64(30000))
+	defer patches.Reset()
+
+	tests := []struct {
+		name       string
+		opts       *HLSOptions
+		wantProbe  string
+		wantMaster string
+	}{
+		{
+			"ts",
+			&HLSOptions{Ladder: DefaultLadder[2:4]},
+			"segment_00000.ts",
+			"#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=400000,AVERAGE-BANDWIDTH=320000,CODECS=\"avc1.64001e,mp4a.40.2\",RESOLUTION=854x480\n" +
+				"480p/index.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=400000,AVERAGE-BANDWIDTH=320000,CODECS=\"avc1.64001e,mp4a.40.2\",RESOLUTION=640x360\n" +
+				"360p/index.m3u8\n",
+		},
+		{
+			"fmp4_iframes",
+			&HLSOptions{Ladder: DefaultLadder[2:3], FMP4: true, IFramePlaylists: true},
+			"init.mp4",
+			"#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=400000,AVERAGE-BANDWIDTH=320000,CODECS=\"avc1.64001e,mp4a.40.2\",RESOLUTION=854x480\n" +
+				"480p/index.m3u8\n" +
+				"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=60000,CODECS=\"avc1.64001e,mp4a.40.2\",RESOLUTION=854x480,URI=\"480p/iframes.m3u8\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "hls-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			commands, probed = nil, nil
+
+			pkg, err := PackageHLS(context.Background(), "/test/in.mp4", dir, tt.opts)
+			if err != nil {
+				t.Fatalf("PackageHLS() error = %v", err)
+			}
+			if len(pkg.Variants) != len(tt.opts.Ladder) || len(commands) != len(tt.opts.Ladder) {
+				t.Fatalf("PackageHLS() = %+v, commands %v", pkg, commands)
+			}
+			if probed[0] != tt.wantProbe {
+				t.Errorf("PackageHLS() probed %v, want %v", probed, tt.wantProbe)
+			}
+			if data, _ := ioutil.ReadFile(pkg.MasterPlaylist); string(data) != tt.wantMaster {
+				t.Errorf("PackageHLS() master = %q, want %q", data, tt.wantMaster)
+			}
+			cmd := strings.Join(commands[0], " ")
+			for _, want := range []string{
+				"-map 0:v:0 -vf scale=854:480 -c:v libx264 -preset veryfast -b:v 1400000 -maxrate 1498000 -bufsize 2100000",
+				"-force_key_frames expr:gte(t,n_forced*6) -sc_threshold 0 -map 0:a:0 -c:a aac -b:a 128000 -f hls -hls_time 6",
+			} {
+				if !strings.Contains(cmd, want) {
+					t.Errorf("PackageHLS() command = %v, want %v", cmd, want)
+				}
+			}
+			if !tt.opts.IFramePlaylists {
+				return
+			}
+			data, _ := ioutil.ReadFile(pkg.Variants[0].IFramePlaylist)
+			wantIFrames := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:6\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-I-FRAMES-ONLY\n" +
+				"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+				"#EXTINF:6.000,\n#EXT-X-BYTERANGE:30000@0\nsegment_00000.m4s\n" +
+				"#EXTINF:4.000,\n#EXT-X-BYTERANGE:30000@0\nsegment_00001.m4s\n#EXT-X-ENDLIST\n"
+			if string(data) != wantIFrames {
+				t.Errorf("PackageHLS() iframes = %q, want %q", data, wantIFrames)
+			}
+		})
+	}
+}
@@ -0,0 +1,607 @@
+package av
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go-utils/src/av/hls"
+	"go-utils/src/logs"
+	"go-utils/src/tools/fs"
+	"go-utils/src/tools/httputil"
+	"go-utils/src/tools/pool"
+	"go-utils/src/tools/progress"
+)
+
+// HLS 下载相关的默认值
+const (
+	defaultHLSConcurrencyNum = 4
+	hlsFetchAttempts         = 3 // 读取响应内容失败时的尝试次数，请求失败由 httputil.Client 重试
+	hlsWorkDirSuffix         = ".hls"
+	hlsConcatList            = "concat.txt"
+	hlsSourceFile            = "source.txt" // 记录分片所属的媒体播放列表地址，续传时校验
+	hlsMethodAES128          = "AES-128"
+	hlsMethodNone            = "NONE"
+)
+
+// HLSDownloadOptions 下载 HLS 的参数，零值使用默认值
+type HLSDownloadOptions struct {
+	Client         *httputil.Client // 下载使用的 Client，默认 httputil.NewClient()
+	ConcurrencyNum int              // 并发下载的分片数，默认 4
+	// Resume 断点续传，失败时保留 WorkDir 中已下载的分片，再次下载时只拉取缺失的分片；
+	// 分片按照序号保存，直播窗口移动后仍然对应同一个分片，选中的码流变化时丢弃已下载的分片
+	Resume bool
+	// WorkDir 分片保存在其中的 <输出文件名>.hls 子目录，默认为 outputPath 所在的目录；
+	// 完成后只删除下载器写入的文件，子目录为空时一并删除，不会删除 WorkDir 中的其他文件
+	WorkDir string
+	// SelectVariant playlistURL 为主播放列表时选择下载的码流，默认选择 BANDWIDTH 最高的
+	SelectVariant func(variants []*hls.Variant) *hls.Variant
+	// Progress 下载进度回调，总大小未知，续传时已下载的分片计入 Done
+	Progress *progress.Observer
+}
+
+// highestBandwidth 选择 BANDWIDTH 最高的码流
+func highestBandwidth(variants []*hls.Variant) *hls.Variant {
+	var best *hls.Variant
+	for _, v := range variants {
+		if best == nil || v.Bandwidth > best.Bandwidth {
+			best = v
+		}
+	}
+	return best
+}
+
+// resolveURI 将播放列表中的相对地址转换为绝对地址
+func resolveURI(base *url.URL, uri string) (string, error) {
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid uri %s => %w", uri, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// hlsIV 解析 EXT-X-KEY 的 IV，未指定时使用分片的序号，参见 RFC 8216 5.2
+func hlsIV(iv string, sequence int64) ([]byte, error) {
+	b := make([]byte, aes.BlockSize)
+	if iv == "" {
+		binary.BigEndian.PutUint64(b[8:], uint64(sequence))
+		return b, nil
+	}
+	s := strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil || len(decoded) > aes.BlockSize {
+		return nil, fmt.Errorf("invalid IV %s", iv)
+	}
+	copy(b[aes.BlockSize-len(decoded):], decoded)
+	return b, nil
+}
+
+// decryptAES128 AES-128-CBC 解密并去掉 PKCS7 填充
+func decryptAES128(data, key, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted size %d", len(data))
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	padding := int(out[len(out)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("invalid PKCS7 padding")
+	}
+	return out[:len(out)-padding], nil
+}
+
+// hlsKey 一个密钥的下载结果，同一密钥只下载一次
+type hlsKey struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// hlsDownloader 下载播放列表、密钥及分片
+type hlsDownloader struct {
+	ctx     context.Context
+	client  *httputil.Client
+	tracker *progress.Tracker // 只统计保存成功的分片，不包括播放列表、密钥及失败的请求
+	mu      sync.Mutex
+	keys    map[string]*hlsKey // key 为密钥的地址
+}
+
+// get 下载 uri 的内容，返回内容及重定向后的地址；byteRange 不为 nil 时只下载该范围，
+// 206 响应的 Content-Range 必须与请求的范围一致，服务端不支持 Range 返回 200 时从完整内容中截取
+func (d *hlsDownloader) get(uri string, byteRange *hls.ByteRange) ([]byte, string, error) {
+	var header http.Header
+	if byteRange != nil {
+		header = make(http.Header)
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", byteRange.Offset, byteRange.Offset+byteRange.Length-1))
+	}
+	resp, err := d.client.Do(d.ctx, http.MethodGet, uri, header, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if byteRange != nil {
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			contentRange := resp.Header.Get("Content-Range")
+			start, end, ok := parseContentRange(contentRange)
+			if !ok || start != byteRange.Offset || end != byteRange.Offset+byteRange.Length-1 {
+				return nil, "", fmt.Errorf("%s Content-Range %q mismatch range %v", uri, contentRange, byteRange)
+			}
+		case http.StatusOK:
+			// 跳过范围之前的内容，只读取需要的部分
+			if _, err = io.CopyN(ioutil.Discard, resp.Body, byteRange.Offset); err != nil {
+				return nil, "", fmt.Errorf("range %v out of %s: %w", byteRange, uri, err)
+			}
+			body = io.LimitReader(resp.Body, byteRange.Length)
+		default:
+			return nil, "", fmt.Errorf("range %v of %s, unexpected code=%v", byteRange, uri, resp.StatusCode)
+		}
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("read %s => %w", uri, err)
+	}
+	if byteRange != nil && int64(len(data)) != byteRange.Length {
+		return nil, "", fmt.Errorf("range %v of %s, want %d bytes but got %d", byteRange, uri, byteRange.Length, len(data))
+	}
+	return data, resp.URL, nil
+}
+
+// parseContentRange 解析 Content-Range 中的范围，比如 "bytes 720-1719/5000" 返回 720 及 1719
+func parseContentRange(contentRange string) (start, end int64, ok bool) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, 0, false
+	}
+	spec, _, _ := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "/")
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false
+	}
+	var err error
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, false
+	}
+	if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// fetch 同 get，读取响应内容失败时重试
+func (d *hlsDownloader) fetch(uri string, byteRange *hls.ByteRange) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		data, _, err := d.get(uri, byteRange)
+		if err == nil {
+			return data, nil
+		}
+		// 状态码错误已经由 Client 按照重试策略处理
+		if d.ctx.Err() != nil || httputil.StatusCode(err) != 0 || attempt >= hlsFetchAttempts {
+			return nil, err
+		}
+		logs.Log.Wainf("fetch %s fail, retry count %d: %v", uri, attempt, err)
+	}
+}
+
+// mediaPlaylist 下载媒体播放列表，playlistURL 为主播放列表时通过 selectVariant 选择码流，
+// 返回播放列表、媒体播放列表的地址及重定向后用于解析相对地址的 base
+func (d *hlsDownloader) mediaPlaylist(
+	playlistURL string,
+	selectVariant func([]*hls.Variant) *hls.Variant,
+) (*hls.MediaPlaylist, string, *url.URL, error) {
+	data, finalURL, err := d.get(playlistURL, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	p, err := hls.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%s => %w", playlistURL, err)
+	}
+	base, err := url.Parse(finalURL)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	switch p := p.(type) {
+	case *hls.MediaPlaylist:
+		return p, playlistURL, base, nil
+	case *hls.MasterPlaylist:
+		variant := selectVariant(p.Variants)
+		if variant == nil {
+			return nil, "", nil, fmt.Errorf("no variant selected in %s", playlistURL)
+		}
+		variantURL, err := resolveURI(base, variant.URI)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return d.mediaPlaylist(variantURL, func([]*hls.Variant) *hls.Variant { return nil })
+	}
+	return nil, "", nil, fmt.Errorf("unknown playlist %s", playlistURL)
+}
+
+// key 下载密钥，mu 只保护 keys，下载时不持有，不同的密钥可以同时下载，同一密钥的其他请求等待下载完成
+func (d *hlsDownloader) key(keyURL string) ([]byte, error) {
+	d.mu.Lock()
+	k, ok := d.keys[keyURL]
+	if !ok {
+		k = &hlsKey{}
+		d.keys[keyURL] = k
+	}
+	d.mu.Unlock()
+	k.once.Do(func() {
+		k.data, k.err = d.fetch(keyURL, nil)
+	})
+	return k.data, k.err
+}
+
+// decrypt 按照 key 解密分片，sequence 为分片的序号
+func (d *hlsDownloader) decrypt(data []byte, key *hls.Key, keyURL string, sequence int64) ([]byte, error) {
+	if key == nil || key.Method == hlsMethodNone {
+		return data, nil
+	}
+	k, err := d.key(keyURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch key %s => %w", keyURL, err)
+	}
+	if len(k) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid key size %d of %s", len(k), keyURL)
+	}
+	iv, err := hlsIV(key.IV, sequence)
+	if err != nil {
+		return nil, err
+	}
+	return decryptAES128(data, k, iv)
+}
+
+// hlsSegmentTask 下载一个分片并解密保存到 path，先写入临时文件，完成后重命名，续传时根据 path 是否存在跳过
+type hlsSegmentTask struct {
+	pool.TaskBase
+	d         *hlsDownloader
+	uri       string
+	byteRange *hls.ByteRange
+	key       *hls.Key
+	keyURL    string
+	sequence  int64
+	path      string
+}
+
+func (t *hlsSegmentTask) process() error {
+	data, err := t.d.fetch(t.uri, t.byteRange)
+	if err != nil {
+		return err
+	}
+	if data, err = t.d.decrypt(data, t.key, t.keyURL, t.sequence); err != nil {
+		return fmt.Errorf("decrypt %s => %w", t.uri, err)
+	}
+	tmpPath := t.path + ".part"
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, t.path); err != nil {
+		return err
+	}
+	// 与续传时 Skip 的文件大小一致，按照保存的大小统计
+	t.d.tracker.Add(int64(len(data)))
+	return nil
+}
+
+// hlsSegmentHandle pool.Executor 的任务处理函数
+func hlsSegmentHandle(data interface{}) {
+	var err error
+	defer func() {
+		data.(pool.ProcessTasker).SetResult(err)
+	}()
+
+	task, ok := data.(*hlsSegmentTask)
+	if !ok {
+		err = fmt.Errorf("data is must hlsSegmentTask, data=%v", data)
+		logs.Log.Error(err)
+		return
+	}
+
+	select {
+	case <-task.d.ctx.Done():
+		err = task.d.ctx.Err()
+	default:
+		err = task.process()
+	}
+}
+
+// hlsPart 一段时间戳连续的分片，以 EXT-X-DISCONTINUITY 或 EXT-X-MAP 的变化分隔，本地拼接为一个文件
+type hlsPart struct {
+	path  string
+	files []string // 按顺序拼接的文件，fMP4 的第一个为 init
+}
+
+// planHLS 生成所有分片的下载任务及拼接方式，resume 时跳过已下载的分片
+func (d *hlsDownloader) planHLS(
+	media *hls.MediaPlaylist,
+	base *url.URL,
+	workDir string,
+	resume bool,
+) ([]*hlsPart, []*hlsSegmentTask, error) {
+	var parts []*hlsPart
+	var tasks []*hlsSegmentTask
+	var part *hlsPart
+	var prevMap *hls.Map
+	offsets := map[string]int64{} // 每个资源中上一个分片结束的位置
+	mapFiles := map[*hls.Map]string{}
+	addTask := func(t *hlsSegmentTask) error {
+		if t.key != nil && t.key.Method != hlsMethodNone {
+			if t.key.Method != hlsMethodAES128 {
+				return fmt.Errorf("unsupported encryption method %s", t.key.Method)
+			}
+			var err error
+			if t.keyURL, err = resolveURI(base, t.key.URI); err != nil {
+				return err
+			}
+		}
+		if resume && fs.IsFile(t.path) {
+			d.tracker.Skip(fs.GetFileSizeNoErr(t.path))
+			return nil
+		}
+		tasks = append(tasks, t)
+		return nil
+	}
+	byteRange := func(uri string, r *hls.ByteRange) *hls.ByteRange {
+		if r == nil {
+			return nil
+		}
+		resolved := *r
+		if resolved.Offset < 0 {
+			resolved.Offset = offsets[uri]
+		}
+		offsets[uri] = resolved.Offset + resolved.Length
+		return &resolved
+	}
+
+	for i, s := range media.Segments {
+		uri, err := resolveURI(base, s.URI)
+		if err != nil {
+			return nil, nil, err
+		}
+		sequence := media.MediaSequence + int64(i)
+		ext := path.Ext(strings.SplitN(s.URI, "?", 2)[0])
+		if ext == "" {
+			ext = formatTs.getExt()
+		}
+		if part == nil || s.Discontinuity || s.Map != prevMap {
+			part = &hlsPart{path: filepath.Join(workDir, fmt.Sprintf("part_%03d%s", len(parts), ext))}
+			parts = append(parts, part)
+			if s.Map != nil {
+				part.path = fs.GetNameWithNewExt(part.path, Mp4Ext)
+				mapPath, ok := mapFiles[s.Map]
+				if !ok {
+					mapPath = filepath.Join(workDir, fmt.Sprintf("init_%05d%s", sequence, Mp4Ext))
+					mapFiles[s.Map] = mapPath
+					mapURI, err := resolveURI(base, s.Map.URI)
+					if err != nil {
+						return nil, nil, err
+					}
+					err = addTask(&hlsSegmentTask{
+						d:         d,
+						uri:       mapURI,
+						byteRange: byteRange(mapURI, s.Map.ByteRange),
+						key:       s.Key,
+						sequence:  sequence,
+						path:      mapPath,
+					})
+					if err != nil {
+						return nil, nil, err
+					}
+				}
+				part.files = append(part.files, mapPath)
+			}
+		}
+		prevMap = s.Map
+
+		// 按照序号命名，续传时播放列表的窗口移动也能对应到同一个分片
+		segmentPath := filepath.Join(workDir, fmt.Sprintf("segment_%05d%s", sequence, ext))
+		part.files = append(part.files, segmentPath)
+		err = addTask(&hlsSegmentTask{
+			d:         d,
+			uri:       uri,
+			byteRange: byteRange(uri, s.ByteRange),
+			key:       s.Key,
+			sequence:  sequence,
+			path:      segmentPath,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return parts, tasks, nil
+}
+
+// hlsWorkFiles 下载器在工作目录中写入的文件，清理时只删除这些文件
+var hlsWorkFiles = []string{"init_*", "segment_*", "part_*", hlsConcatList, hlsSourceFile}
+
+// removeHLSFiles 删除 workDir 中下载器写入的文件，removeDir 时删除变为空的 workDir
+func removeHLSFiles(workDir string, removeDir bool) {
+	for _, pattern := range hlsWorkFiles {
+		matches, _ := filepath.Glob(filepath.Join(workDir, pattern))
+		for _, match := range matches {
+			os.Remove(match)
+		}
+	}
+	if removeDir {
+		// 目录中还有其他文件时删除失败，保留目录
+		os.Remove(workDir)
+	}
+}
+
+// prepareHLSWorkDir 创建工作目录并记录媒体播放列表的地址 mediaURL，不续传或者记录的地址不一致
+// (重新选择了码流、播放列表地址变化等)时先删除已下载的分片，避免拼接到其他码流的分片
+func prepareHLSWorkDir(workDir, mediaURL string, resume bool) error {
+	sourcePath := filepath.Join(workDir, hlsSourceFile)
+	if resume {
+		source, err := ioutil.ReadFile(sourcePath)
+		if err == nil && string(source) == mediaURL {
+			return nil
+		}
+		if err == nil {
+			logs.Log.Wainf("%s changed from %s to %s, discard downloaded segments", sourcePath, source, mediaURL)
+		}
+	}
+	removeHLSFiles(workDir, false)
+	if err := os.MkdirAll(workDir, os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sourcePath, []byte(mediaURL), 0644)
+}
+
+// concatFiles 按顺序拼接 files 到 outputPath
+func concatFiles(outputPath string, files []string) error {
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, file := range files {
+		in, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("concat %s => %s fail: %w", file, outputPath, err)
+		}
+	}
+	return nil
+}
+
+// mergeHLSParts 将拼接好的各段转封装为 outputPath，只有一段时使用 MergeTS，
+// 多段时通过 concat demuxer 合并，由 ffmpeg 处理时间戳的不连续
+func mergeHLSParts(ctx context.Context, parts []*hlsPart, workDir, outputPath string) error {
+	if len(parts) == 1 {
+		return MergeTS(ctx, parts[0].path, outputPath)
+	}
+	var list strings.Builder
+	for _, part := range parts {
+		absPath, err := filepath.Abs(part.path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(absPath, "'", `'\''`))
+	}
+	listPath := filepath.Join(workDir, hlsConcatList)
+	if err := ioutil.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+	cmd := NewCommand().Overwrite().LogLevel("error")
+	cmd.Input(listPath).Format("concat").Option("-safe", "0")
+	cmd.Output(outputPath).Copy()
+	return cmd.Run(ctx)
+}
+
+// DownloadHLS 下载 playlistURL 的所有分片并转封装为 outputPath，不需要 ffmpeg 访问网络：
+// 分片通过 httputil 并发下载并重试，AES-128 加密的分片下载后解密，每段连续的分片在本地拼接后交给 MergeTS 合并，
+// 存在 EXT-X-DISCONTINUITY 时分段合并。只下载选中码流自身的分片，不包含 EXT-X-MEDIA 中的备选音轨及字幕；
+// 直播的播放列表只下载当前列出的分片。不支持 SAMPLE-AES 加密
+func DownloadHLS(ctx context.Context, playlistURL, outputPath string, opts *HLSDownloadOptions) error {
+	o := HLSDownloadOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Client == nil {
+		o.Client = httputil.NewClient()
+	}
+	if o.ConcurrencyNum <= 0 {
+		o.ConcurrencyNum = defaultHLSConcurrencyNum
+	}
+	baseDir := o.WorkDir
+	if baseDir == "" {
+		baseDir = filepath.Dir(outputPath)
+	}
+	// 使用独立的子目录，避免删除调用者目录中的文件
+	o.WorkDir = filepath.Join(baseDir, filepath.Base(outputPath)+hlsWorkDirSuffix)
+	if o.SelectVariant == nil {
+		o.SelectVariant = highestBandwidth
+	}
+
+	d := &hlsDownloader{
+		ctx:     ctx,
+		client:  o.Client,
+		tracker: progress.NewTracker(-1, o.Progress),
+		keys:    map[string]*hlsKey{},
+	}
+	media, mediaURL, base, err := d.mediaPlaylist(playlistURL, o.SelectVariant)
+	if err != nil {
+		return err
+	}
+	if len(media.Segments) == 0 {
+		return fmt.Errorf("no segment in %s", playlistURL)
+	}
+	if err = prepareHLSWorkDir(o.WorkDir, mediaURL, o.Resume); err != nil {
+		return err
+	}
+	if err = downloadHLSParts(ctx, d, media, base, outputPath, &o); err != nil {
+		if !o.Resume {
+			removeHLSFiles(o.WorkDir, true)
+		}
+		return err
+	}
+	d.tracker.Finish()
+	removeHLSFiles(o.WorkDir, true)
+	return nil
+}
+
+// downloadHLSParts 下载分片、拼接并合并为 outputPath
+func downloadHLSParts(
+	ctx context.Context,
+	d *hlsDownloader,
+	media *hls.MediaPlaylist,
+	base *url.URL,
+	outputPath string,
+	o *HLSDownloadOptions,
+) error {
+	parts, tasks, err := d.planHLS(media, base, o.WorkDir, o.Resume)
+	if err != nil {
+		return err
+	}
+	if len(tasks) > 0 {
+		if o.Resume && len(tasks) < len(media.Segments) {
+			logs.Log.Infof("resume %v => %v, %v/%v segments left", base, outputPath, len(tasks), len(media.Segments))
+		}
+		// 提前返回时通知仍在执行的分片退出
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		d.ctx = ctx
+		taskChan := make(chan interface{}, len(tasks))
+		for _, t := range tasks {
+			taskChan <- t
+		}
+		close(taskChan)
+		if err = pool.Executor(ctx, taskChan, hlsSegmentHandle, o.ConcurrencyNum); err != nil {
+			return err
+		}
+	}
+	for _, part := range parts {
+		if err = concatFiles(part.path, part.files); err != nil {
+			return err
+		}
+	}
+	return mergeHLSParts(ctx, parts, o.WorkDir, outputPath)
+}
@@ -0,0 +1,417 @@
+package av
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-utils/src/av/hls"
+	"go-utils/src/tools/httputil/httptestutil"
+	"go-utils/src/tools/progress"
+
+	"github.com/agiledragon/gomonkey/v2"
+)
+
+// encryptAES128 AES-128-CBC 加密并添加 PKCS7 填充
+func encryptAES128(t *testing.T, data, key, iv []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(data, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, data)
+	return out
+}
+
+// hlsServer 按照路径返回内容，fail 中的路径返回 404，记录每个路径的请求次数
+type hlsServer struct {
+	files    map[string][]byte
+	fail     map[string]bool
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (s *hlsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	fail := s.fail[r.URL.Path]
+	s.mu.Unlock()
+	data, ok := s.files[r.URL.Path]
+	if !ok || fail {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+}
+
+// patchMerge 模拟 ffmpeg 合并：读取 concat 列表或单个输入文件的内容
+func patchMerge(parts *[]string, commands *[][]string) *gomonkey.Patches {
+	return gomonkey.ApplyFunc(runCommand, func(_ context.Context, argv []string, _ io.Writer) error {
+		*commands = append(*commands, argv)
+		input := ""
+		for i, arg := range argv {
+			if arg == "-i" {
+				input = argv[i+1]
+			}
+		}
+		files := []string{input}
+		if strings.HasSuffix(input, "concat.txt") {
+			list, _ := ioutil.ReadFile(input)
+			files = nil
+			for _, line := range strings.Split(strings.TrimSpace(string(list)), "\n") {
+				files = append(files, strings.Trim(strings.TrimPrefix(line, "file "), "'"))
+			}
+		}
+		for _, file := range files {
+			data, _ := ioutil.ReadFile(file)
+			*parts = append(*parts, string(data))
+		}
+		return nil
+	})
+}
+
+func TestDownloadHLS(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := func(sequence byte) []byte {
+		b := make([]byte, aes.BlockSize)
+		b[aes.BlockSize-1] = sequence
+		return b
+	}
+	server := &hlsServer{
+		files: map[string][]byte{
+			"/master.m3u8": []byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=100\nlow/index.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=900\nhigh/index.m3u8\n"),
+			"/high/index.m3u8": []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:5\n" +
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/k1\"\n#EXTINF:6,\nseg0.ts\n#EXTINF:6,\nseg1.ts\n" +
+				"#EXT-X-DISCONTINUITY\n#EXT-X-KEY:METHOD=NONE\n#EXTINF:2,\n#EXT-X-BYTERANGE:4@2\nall.ts\n" +
+				"#EXTINF:2,\n#EXT-X-BYTERANGE:2\nall.ts\n#EXT-X-ENDLIST\n"),
+			"/keys/k1":        key,
+			"/high/seg0.ts":   encryptAES128(t, []byte("segment-0"), key, iv(5)),
+			"/high/seg1.ts":   encryptAES128(t, []byte("segment-1"), key, iv(6)),
+			"/high/all.ts":    []byte("xxAD01yyzz"),
+			"/low/index.m3u8": []byte("#EXTM3U\n#EXTINF:6,\nlow.ts\n#EXT-X-ENDLIST\n"),
+		},
+		requests: map[string]int{},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "hls-download-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var parts []string
+	var commands [][]string
+	patches := patchMerge(&parts, &commands)
+	defer patches.Reset()
+
+	outputPath := filepath.Join(dir, "out.mp4")
+	var last progress.Progress
+	opts := &HLSDownloadOptions{Progress: &progress.Observer{Interval: -1, Func: func(p progress.Progress) { last = p }}}
+	if err = DownloadHLS(context.Background(), ts.URL+"/master.m3u8", outputPath, opts); err != nil {
+		t.Fatalf("DownloadHLS() error = %v", err)
+	}
+	if want := []string{"segment-0segment-1", "AD01yy"}; !reflect.DeepEqual(parts, want) {
+		t.Errorf("DownloadHLS() parts = %q, want %q", parts, want)
+	}
+	// 只统计保存的分片，不包括播放列表及密钥
+	if last.Done != int64(len("segment-0segment-1AD01yy")) {
+		t.Errorf("DownloadHLS() progress done = %d, want %d", last.Done, len("segment-0segment-1AD01yy"))
+	}
+	workDir := outputPath + hlsWorkDirSuffix
+	wantCmd := ffmpegBin + " -y -loglevel error -f concat -safe 0 -i " + filepath.Join(workDir, "concat.txt") + " -c copy " + outputPath
+	if len(commands) != 1 || strings.Join(commands[0], " ") != wantCmd {
+		t.Errorf("DownloadHLS() commands = %v, want %v", commands, wantCmd)
+	}
+	if server.requests["/keys/k1"] != 1 || server.requests["/low/index.m3u8"] != 0 {
+		t.Errorf("DownloadHLS() requests = %v", server.requests)
+	}
+	if _, err = os.Stat(workDir); !os.IsNotExist(err) {
+		t.Errorf("DownloadHLS() should remove %s", workDir)
+	}
+}
+
+func TestDownloadHLS_resume(t *testing.T) {
+	server := &hlsServer{
+		files: map[string][]byte{
+			"/index.m3u8": []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n" +
+				"#EXTINF:6,\n0.ts\n#EXTINF:6,\n1.ts\n#EXTINF:6,\n2.ts\n#EXT-X-ENDLIST\n"),
+			"/0.ts": []byte("a"),
+			"/1.ts": []byte("b"),
+			"/2.ts": []byte("c"),
+		},
+		fail:     map[string]bool{"/2.ts": true},
+		requests: map[string]int{},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "hls-download-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var parts []string
+	var commands [][]string
+	patches := patchMerge(&parts, &commands)
+	defer patches.Reset()
+
+	outputPath := filepath.Join(dir, "out.mp4")
+	var last progress.Progress
+	opts := &HLSDownloadOptions{
+		ConcurrencyNum: 1,
+		Resume:         true,
+		Progress:       &progress.Observer{Interval: -1, Func: func(p progress.Progress) { last = p }},
+	}
+	if err = DownloadHLS(context.Background(), ts.URL+"/index.m3u8", outputPath, opts); err == nil {
+		t.Fatalf("DownloadHLS() with missing segment should fail")
+	}
+	if _, err = os.Stat(filepath.Join(outputPath+hlsWorkDirSuffix, "segment_00000.ts")); err != nil {
+		t.Errorf("DownloadHLS() should keep downloaded segments: %v", err)
+	}
+
+	server.mu.Lock()
+	server.fail = nil
+	server.mu.Unlock()
+	if err = DownloadHLS(context.Background(), ts.URL+"/index.m3u8", outputPath, opts); err != nil {
+		t.Fatalf("DownloadHLS() error = %v", err)
+	}
+	if server.requests["/0.ts"] != 1 || server.requests["/1.ts"] != 1 {
+		t.Errorf("DownloadHLS() should skip downloaded segments, requests = %v", server.requests)
+	}
+	// 已下载的分片计入 Done，失败的请求不计入
+	if last.Done != 3 {
+		t.Errorf("DownloadHLS() progress done = %d, want 3", last.Done)
+	}
+	if want := []string{"abc"}; !reflect.DeepEqual(parts, want) {
+		t.Errorf("DownloadHLS() parts = %q, want %q", parts, want)
+	}
+	wantCmd := ffmpegBin + " -y -loglevel error -i " + filepath.Join(outputPath+hlsWorkDirSuffix, "part_000.ts") +
+		" -c copy " + outputPath
+	if len(commands) != 1 || strings.Join(commands[0], " ") != wantCmd {
+		t.Errorf("DownloadHLS() commands = %v, want %v", commands, wantCmd)
+	}
+}
+
+func TestDownloadHLS_resumeChanged(t *testing.T) {
+	server := &hlsServer{
+		files: map[string][]byte{
+			"/index.m3u8": []byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000\nlow/index.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2000\nhigh/index.m3u8\n"),
+			"/low/index.m3u8": []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n" +
+				"#EXTINF:6,\n0.ts\n#EXTINF:6,\n1.ts\n#EXTINF:6,\n2.ts\n#EXT-X-ENDLIST\n"),
+			"/high/index.m3u8": []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n" +
+				"#EXTINF:6,\n0.ts\n#EXTINF:6,\n1.ts\n#EXT-X-ENDLIST\n"),
+			"/low/0.ts":  []byte("a"),
+			"/low/1.ts":  []byte("b"),
+			"/low/2.ts":  []byte("c"),
+			"/low/3.ts":  []byte("d"),
+			"/high/0.ts": []byte("X"),
+			"/high/1.ts": []byte("Y"),
+		},
+		fail:     map[string]bool{"/low/2.ts": true},
+		requests: map[string]int{},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "hls-download-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var parts []string
+	var commands [][]string
+	patches := patchMerge(&parts, &commands)
+	defer patches.Reset()
+
+	outputPath := filepath.Join(dir, "out.mp4")
+	low := &HLSDownloadOptions{
+		ConcurrencyNum: 1,
+		Resume:         true,
+		SelectVariant:  func(variants []*hls.Variant) *hls.Variant { return variants[0] },
+	}
+	if err = DownloadHLS(context.Background(), ts.URL+"/index.m3u8", outputPath, low); err == nil {
+		t.Fatalf("DownloadHLS() with missing segment should fail")
+	}
+
+	// 直播窗口移动后按照序号复用已下载的分片
+	server.mu.Lock()
+	server.files["/low/index.m3u8"] = []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:1\n" +
+		"#EXTINF:6,\n1.ts\n#EXTINF:6,\n2.ts\n#EXTINF:6,\n3.ts\n#EXT-X-ENDLIST\n")
+	server.fail = nil
+	server.mu.Unlock()
+	if err = DownloadHLS(context.Background(), ts.URL+"/index.m3u8", outputPath, low); err != nil {
+		t.Fatalf("DownloadHLS() error = %v", err)
+	}
+	if server.requests["/low/1.ts"] != 1 || server.requests["/low/3.ts"] != 1 {
+		t.Errorf("DownloadHLS() should skip downloaded segments, requests = %v", server.requests)
+	}
+	if want := []string{"bcd"}; !reflect.DeepEqual(parts, want) {
+		t.Errorf("DownloadHLS() parts = %q, want %q", parts, want)
+	}
+
+	// 选中的码流变化时丢弃其他码流的分片
+	server.mu.Lock()
+	server.fail = map[string]bool{"/low/3.ts": true}
+	server.mu.Unlock()
+	if err = DownloadHLS(context.Background(), ts.URL+"/index.m3u8", outputPath, low); err == nil {
+		t.Fatalf("DownloadHLS() with missing segment should fail")
+	}
+	parts = nil
+	if err = DownloadHLS(context.Background(), ts.URL+"/index.m3u8", outputPath, &HLSDownloadOptions{Resume: true}); err != nil {
+		t.Fatalf("DownloadHLS() error = %v", err)
+	}
+	if want := []string{"XY"}; !reflect.DeepEqual(parts, want) {
+		t.Errorf("DownloadHLS() parts = %q, want %q", parts, want)
+	}
+}
+
+func TestDownloadHLS_workDir(t *testing.T) {
+	server := &hlsServer{
+		files: map[string][]byte{
+			"/index.m3u8": []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6,\n0.ts\n#EXT-X-ENDLIST\n"),
+			"/0.ts":       []byte("a"),
+		},
+		requests: map[string]int{},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "hls-download-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	other := filepath.Join(dir, "other.mp4")
+	if err = ioutil.WriteFile(other, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var parts []string
+	var commands [][]string
+	patches := patchMerge(&parts, &commands)
+	defer patches.Reset()
+
+	// WorkDir 为调用者已有的目录时只使用其中的子目录，不删除其他文件
+	outputPath := filepath.Join(dir, "out.mp4")
+	for _, resume := range []bool{false, true} {
+		opts := &HLSDownloadOptions{WorkDir: dir, Resume: resume}
+		if err = DownloadHLS(context.Background(), ts.URL+"/index.m3u8", outputPath, opts); err != nil {
+			t.Fatalf("DownloadHLS() error = %v", err)
+		}
+		if data, err := ioutil.ReadFile(other); err != nil || string(data) != "keep" {
+			t.Errorf("DownloadHLS() should keep %s, err = %v", other, err)
+		}
+		if _, err = os.Stat(outputPath + hlsWorkDirSuffix); !os.IsNotExist(err) {
+			t.Errorf("DownloadHLS() should remove %s", outputPath+hlsWorkDirSuffix)
+		}
+	}
+}
+
+func TestDownloadHLS_byteRange(t *testing.T) {
+	server := httptestutil.NewServer()
+	defer server.Close()
+	server.Handle("/index.m3u8", httptestutil.Response{Body: []byte("#EXTM3U\n#EXT-X-TARGETDURATION:2\n" +
+		"#EXTINF:2,\n#EXT-X-BYTERANGE:4@2\nall.ts\n#EXTINF:2,\n#EXT-X-BYTERANGE:2\nall.ts\n#EXT-X-ENDLIST\n")})
+
+	dir, err := ioutil.TempDir("", "hls-download-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var parts []string
+	var commands [][]string
+	patches := patchMerge(&parts, &commands)
+	defer patches.Reset()
+
+	outputPath := filepath.Join(dir, "out.mp4")
+	opts := &HLSDownloadOptions{ConcurrencyNum: 1}
+	// 返回的范围与请求的不一致
+	server.Handle("/all.ts", httptestutil.Response{
+		Status: http.StatusPartialContent,
+		Header: http.Header{"Content-Range": {"bytes 0-3/10"}},
+		Body:   []byte("xxAD"),
+	})
+	if err = DownloadHLS(context.Background(), server.URLFor("/index.m3u8"), outputPath, opts); err == nil {
+		t.Errorf("DownloadHLS() with mismatched Content-Range should fail")
+	}
+	if len(commands) != 0 {
+		t.Errorf("DownloadHLS() commands = %v, want none", commands)
+	}
+
+	// 不支持 Range 时从完整内容中截取
+	server.Handle("/all.ts", httptestutil.Response{Body: []byte("xxAD01yyzz")})
+	if err = DownloadHLS(context.Background(), server.URLFor("/index.m3u8"), outputPath, opts); err != nil {
+		t.Fatalf("DownloadHLS() error = %v", err)
+	}
+	if want := []string{"AD01yy"}; !reflect.DeepEqual(parts, want) {
+		t.Errorf("DownloadHLS() parts = %q, want %q", parts, want)
+	}
+}
+
+func Test_parseContentRange(t *testing.T) {
+	tests := []struct {
+		contentRange string
+		start, end   int64
+		ok           bool
+	}{
+		{"bytes 720-1719/5000", 720, 1719, true},
+		{"bytes 0-0/*", 0, 0, true},
+		{"bytes */5000", 0, 0, false},
+		{"bytes 10-5/20", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, ok := parseContentRange(tt.contentRange)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("parseContentRange(%q) = %v %v %v, want %v %v %v", tt.contentRange, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func Test_hlsIV(t *testing.T) {
+	tests := []struct {
+		name     string
+		iv       string
+		sequence int64
+		want     []byte
+		wantErr  bool
+	}{
+		{"sequence", "", 258, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2}, false},
+		{"explicit", "0x000102030405060708090A0B0C0D0E0F", 9, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, false},
+		{"short", "0x1", 9, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, false},
+		{"invalid", "0xZZ", 0, nil, true},
+		{"too_long", "0x" + strings.Repeat("00", 17), 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hlsIV(tt.iv, tt.sequence)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("hlsIV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hlsIV() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
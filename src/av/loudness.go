@@ -0,0 +1,249 @@
+package av
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 响度相关的默认值，即 EBU R128 的推荐值
+const (
+	defaultLoudnessIntegrated = -23.0
+	defaultLoudnessRange      = 7.0
+	defaultLoudnessTruePeak   = -1.0
+	defaultLoudnessSampleRate = "48000"
+	loudnormLinear            = "linear"
+)
+
+// Loudness EBU R128 响度统计
+type Loudness struct {
+	Integrated float64 // 综合响度，单位 LUFS，没有可听见的声音时为 -Inf
+	Range      float64 // 响度范围，单位 LU
+	TruePeak   float64 // 真峰值，单位 dBTP
+	Threshold  float64 // 计算综合响度时的相对门限，单位 LUFS
+}
+
+// LoudnessTarget 响度标准化的目标，未设置的字段使用 EBU R128 的推荐值
+type LoudnessTarget struct {
+	Integrated float64  // 目标综合响度，-70 ~ -5 LUFS，0 表示默认 -23
+	Range      float64  // 目标响度范围，1 ~ 50 LU，0 表示默认 7
+	TruePeak   *float64 // 最大真峰值，-9 ~ 0 dBTP，0 是有效值，因此 nil 表示默认 -1
+}
+
+// loudnessParams 补全默认值并校验后的 loudnorm 目标参数
+type loudnessParams struct {
+	integrated float64
+	lra        float64
+	truePeak   float64
+}
+
+// defaultLoudnessParams EBU R128 推荐的目标，测量时只使用输入的统计，与目标无关
+var defaultLoudnessParams = loudnessParams{
+	integrated: defaultLoudnessIntegrated,
+	lra:        defaultLoudnessRange,
+	truePeak:   defaultLoudnessTruePeak,
+}
+
+// params 补全默认值并校验范围，t 为 nil 时使用推荐值；范围与 loudnorm 一致，提前校验避免执行 ffmpeg 后才失败
+func (t *LoudnessTarget) params() (loudnessParams, error) {
+	p := defaultLoudnessParams
+	if t != nil {
+		if t.Integrated != 0 {
+			p.integrated = t.Integrated
+		}
+		if t.Range != 0 {
+			p.lra = t.Range
+		}
+		if t.TruePeak != nil {
+			p.truePeak = *t.TruePeak
+		}
+	}
+	if p.integrated < -70 || p.integrated > -5 {
+		return p, fmt.Errorf("invalid target integrated loudness %v LUFS, want -70 ~ -5", p.integrated)
+	}
+	if p.lra < 1 || p.lra > 50 {
+		return p, fmt.Errorf("invalid target loudness range %v LU, want 1 ~ 50", p.lra)
+	}
+	if p.truePeak < -9 || p.truePeak > 0 {
+		return p, fmt.Errorf("invalid target true peak %v dBTP, want -9 ~ 0", p.truePeak)
+	}
+	return p, nil
+}
+
+// LoudnessResult 两遍响度标准化的结果
+type LoudnessResult struct {
+	Input  Loudness // 第一遍测量的输入响度
+	Output Loudness // 第二遍 loudnorm 统计的输出响度
+	Linear bool     // 是否为线性标准化，输入的响度范围或真峰值超出目标时 loudnorm 退回动态标准化
+}
+
+// loudnormStats loudnorm 以 print_format=json 输出的统计，数值均为字符串
+type loudnormStats struct {
+	InputI            string `json:"input_i"`
+	InputTP           string `json:"input_tp"`
+	InputLRA          string `json:"input_lra"`
+	InputThresh       string `json:"input_thresh"`
+	OutputI           string `json:"output_i"`
+	OutputTP          string `json:"output_tp"`
+	OutputLRA         string `json:"output_lra"`
+	OutputThresh      string `json:"output_thresh"`
+	NormalizationType string `json:"normalization_type"`
+	TargetOffset      string `json:"target_offset"`
+}
+
+// parseLoudness 解析 loudnorm 统计中的一组响度，-inf 解析为 -Inf
+func parseLoudness(i, lra, tp, thresh string) (Loudness, error) {
+	var l Loudness
+	for _, v := range []struct {
+		s string
+		f *float64
+	}{{i, &l.Integrated}, {lra, &l.Range}, {tp, &l.TruePeak}, {thresh, &l.Threshold}} {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.s), 64)
+		if err != nil {
+			return l, fmt.Errorf("invalid loudnorm value %q: %w", v.s, err)
+		}
+		*v.f = f
+	}
+	return l, nil
+}
+
+// parseLoudnorm 从 ffmpeg 的标准错误中解析最后一个 loudnorm 统计
+func parseLoudnorm(stderr string) (*loudnormStats, error) {
+	start := strings.LastIndex(stderr, "{")
+	if start < 0 {
+		return nil, errors.New("no loudnorm stats in ffmpeg output")
+	}
+	end := strings.Index(stderr[start:], "}")
+	if end < 0 {
+		return nil, errors.New("incomplete loudnorm stats in ffmpeg output")
+	}
+	stats := &loudnormStats{}
+	if err := json.Unmarshal([]byte(stderr[start:start+end+1]), stats); err != nil {
+		return nil, fmt.Errorf("invalid loudnorm stats: %w", err)
+	}
+	return stats, nil
+}
+
+// input 输入的响度
+func (s *loudnormStats) input() (Loudness, error) {
+	return parseLoudness(s.InputI, s.InputLRA, s.InputTP, s.InputThresh)
+}
+
+// output 输出的响度
+func (s *loudnormStats) output() (Loudness, error) {
+	return parseLoudness(s.OutputI, s.OutputLRA, s.OutputTP, s.OutputThresh)
+}
+
+// formatLoudness 格式化 loudnorm 的参数
+func formatLoudness(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// loudnormFilter 构造 loudnorm 滤镜，measured 不为 nil 时为第二遍，使用第一遍的测量结果进行线性标准化
+func loudnormFilter(p loudnessParams, measured *loudnormStats) Filter {
+	args := []string{
+		"I=" + formatLoudness(p.integrated),
+		"LRA=" + formatLoudness(p.lra),
+		"TP=" + formatLoudness(p.truePeak),
+	}
+	if measured != nil {
+		args = append(args,
+			"measured_I="+strings.TrimSpace(measured.InputI),
+			"measured_LRA="+strings.TrimSpace(measured.InputLRA),
+			"measured_TP="+strings.TrimSpace(measured.InputTP),
+			"measured_thresh="+strings.TrimSpace(measured.InputThresh),
+			"offset="+strings.TrimSpace(measured.TargetOffset),
+			"linear=true",
+		)
+	}
+	return NewFilter("loudnorm", append(args, "print_format=json")...)
+}
+
+// loudnormCommand loudnorm 的统计在 info 日志级别输出到标准错误
+func loudnormCommand(stderr *bytes.Buffer) *Command {
+	return NewCommand().Option("-hide_banner").Option("-nostats").LogLevel("info").Stderr(stderr)
+}
+
+// measureLoudnorm 第一遍，解码全部音频统计响度，不输出文件
+func measureLoudnorm(ctx context.Context, inputPath string, p loudnessParams) (*loudnormStats, error) {
+	var stderr bytes.Buffer
+	cmd := loudnormCommand(&stderr)
+	cmd.Input(inputPath)
+	cmd.Output("-").Disable(StreamVideo).AudioFilter(loudnormFilter(p, nil)).Format("null")
+	// 测量不报告进度，进度只在 NormalizeLoudness 输出时报告
+	if err := cmd.RunWithProgress(ctx, nil); err != nil {
+		return nil, err
+	}
+	return parseLoudnorm(stderr.String())
+}
+
+// MeasureLoudness 通过 loudnorm 测量输入第一个音频流的 EBU R128 综合响度、响度范围及真峰值
+func MeasureLoudness(ctx context.Context, inputPath string) (*Loudness, error) {
+	stats, err := measureLoudnorm(ctx, inputPath, defaultLoudnessParams)
+	if err != nil {
+		return nil, err
+	}
+	loudness, err := stats.input()
+	if err != nil {
+		return nil, err
+	}
+	return &loudness, nil
+}
+
+// NormalizeLoudness 两遍 loudnorm 将音频标准化到 target，target 为 nil 时使用 EBU R128 的推荐值：
+// 第一遍测量输入的响度，第二遍使用测量结果进行线性标准化；视频流直接拷贝，音频按照 outputPath 的格式使用默认编码器，
+// 采样率保持与输入一致（loudnorm 默认输出 192kHz）；ctx 中设置的进度回调只报告第二遍；target 超出范围时直接返回错误
+func NormalizeLoudness(ctx context.Context, inputPath, outputPath string, target *LoudnessTarget) (*LoudnessResult, error) {
+	p, err := target.params()
+	if err != nil {
+		return nil, err
+	}
+	info, err := Probe(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+	audio := info.GetAudioStream()
+	if audio == nil {
+		return nil, fmt.Errorf("no audio stream in %s", inputPath)
+	}
+	measured, err := measureLoudnorm(ctx, inputPath, p)
+	if err != nil {
+		return nil, err
+	}
+	input, err := measured.input()
+	if err != nil {
+		return nil, err
+	}
+	if math.IsInf(input.Integrated, 0) || math.IsInf(input.Threshold, 0) {
+		return nil, fmt.Errorf("no audible audio in %s", inputPath)
+	}
+
+	sampleRate := audio.SampleRate
+	if sampleRate == "" || sampleRate == "0" {
+		sampleRate = defaultLoudnessSampleRate
+	}
+	var stderr bytes.Buffer
+	cmd := loudnormCommand(&stderr).Overwrite()
+	cmd.Input(inputPath)
+	output := cmd.Output(outputPath).AudioFilter(loudnormFilter(p, measured)).Option("-ar", sampleRate)
+	if info.GetVideoStream() != nil {
+		output.Codec(StreamVideo, "copy")
+	}
+	if err = cmd.Run(ctx); err != nil {
+		return nil, err
+	}
+	stats, err := parseLoudnorm(stderr.String())
+	if err != nil {
+		return nil, err
+	}
+	result := &LoudnessResult{Input: input, Linear: strings.TrimSpace(stats.NormalizationType) == loudnormLinear}
+	if result.Output, err = stats.output(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
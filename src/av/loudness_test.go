@@ -0,0 +1,185 @@
+package av
+
+import (
+	"context"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+)
+
+// loudnormOutput 模拟 loudnorm 输出到标准错误的统计
+func loudnormOutput(input, output [4]string, normalizationType string) string {
+	return "[Parsed_loudnorm_0 @ 0x7f8b4c004a40] \n{\n" +
+		"\t\"input_i\" : \"" + input[0] + "\",\n\t\"input_tp\" : \"" + input[2] + "\",\n" +
+		"\t\"input_lra\" : \"" + input[1] + "\",\n\t\"input_thresh\" : \"" + input[3] + "\",\n" +
+		"\t\"output_i\" : \"" + output[0] + "\",\n\t\"output_tp\" : \"" + output[2] + "\",\n" +
+		"\t\"output_lra\" : \"" + output[1] + "\",\n\t\"output_thresh\" : \"" + output[3] + "\",\n" +
+		"\t\"normalization_type\" : \"" + normalizationType + "\",\n\t\"target_offset\" : \"0.25\"\n}\n" +
+		"[out#0/null @ 0x7f8b4c003f00] video:0kB audio:1024kB\n"
+}
+
+func Test_parseLoudnorm(t *testing.T) {
+	stderr := "Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'in.mp4':\n  Stream #0:0: Audio: aac {lc}\n" +
+		loudnormOutput([4]string{"-27.61", "4.60", "-4.47", "-37.99"}, [4]string{"-23.25", "3.90", "-1.00", "-33.58"}, "dynamic")
+	stats, err := parseLoudnorm(stderr)
+	if err != nil {
+		t.Fatalf("parseLoudnorm() error = %v", err)
+	}
+	input, err := stats.input()
+	if err != nil {
+		t.Fatalf("input() error = %v", err)
+	}
+	if want := (Loudness{Integrated: -27.61, Range: 4.6, TruePeak: -4.47, Threshold: -37.99}); input != want {
+		t.Errorf("input() = %+v, want %+v", input, want)
+	}
+	if stats.NormalizationType != "dynamic" || stats.TargetOffset != "0.25" {
+		t.Errorf("parseLoudnorm() = %+v", stats)
+	}
+
+	// 静音输入的响度为 -inf
+	stats, err = parseLoudnorm(loudnormOutput([4]string{"-inf", "0.00", "-inf", "-70.00"}, [4]string{"-inf", "0.00", "-inf", "-70.00"}, "dynamic"))
+	if err != nil {
+		t.Fatalf("parseLoudnorm() error = %v", err)
+	}
+	if input, err = stats.input(); err != nil || !math.IsInf(input.Integrated, -1) {
+		t.Errorf("input() = %+v, %v", input, err)
+	}
+
+	for _, s := range []string{"", "[Parsed_loudnorm_0 @ 0x1] \n{\n\t\"input_i\" : \"-27.61\",\n", "{\"input_i\" : -27.61}"} {
+		if _, err = parseLoudnorm(s); err == nil {
+			t.Errorf("parseLoudnorm(%q) should fail", s)
+		}
+	}
+}
+
+func TestLoudnessTarget_params(t *testing.T) {
+	zero, low := 0.0, -10.0
+	tests := []struct {
+		name    string
+		target  *LoudnessTarget
+		want    loudnessParams
+		wantErr bool
+	}{
+		{"nil", nil, defaultLoudnessParams, false},
+		{"zero_value", &LoudnessTarget{}, defaultLoudnessParams, false},
+		{"true_peak_zero", &LoudnessTarget{Integrated: -16, TruePeak: &zero}, loudnessParams{-16, 7, 0}, false},
+		{"integrated_too_high", &LoudnessTarget{Integrated: -1}, loudnessParams{}, true},
+		{"range_too_large", &LoudnessTarget{Range: 60}, loudnessParams{}, true},
+		{"true_peak_too_low", &LoudnessTarget{TruePeak: &low}, loudnessParams{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.target.params()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("params() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("params() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loudnormFilter(t *testing.T) {
+	measured := &loudnormStats{InputI: "-27.61", InputLRA: "4.60", InputTP: "-4.47", InputThresh: "-37.99", TargetOffset: "0.25"}
+	tests := []struct {
+		name     string
+		params   loudnessParams
+		measured *loudnormStats
+		want     string
+	}{
+		{"default", defaultLoudnessParams, nil, "loudnorm=I=-23:LRA=7:TP=-1:print_format=json"},
+		{"target", loudnessParams{-16, 7, -1.5}, nil, "loudnorm=I=-16:LRA=7:TP=-1.5:print_format=json"},
+		{
+			"second_pass", loudnessParams{-16, 7, 0}, measured,
+			"loudnorm=I=-16:LRA=7:TP=0:measured_I=-27.61:measured_LRA=4.60:measured_TP=-4.47:measured_thresh=-37.99:" +
+				"offset=0.25:linear=true:print_format=json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loudnormFilter(tt.params, tt.measured).String(); got != tt.want {
+				t.Errorf("loudnormFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeLoudness(t *testing.T) {
+	source := &ProbeInfo{Streams: []Streams{{CodecType: CodecTypeVideo}, {CodecType: CodecTypeAudio, SampleRate: "44100"}}}
+	patches := gomonkey.ApplyFunc(Probe, func(_ context.Context, _ string) (*ProbeInfo, error) {
+		return source, nil
+	})
+	defer patches.Reset()
+	var commands []string
+	silent := false
+	patches.ApplyFunc(runCommandStderr, func(_ context.Context, argv []string, _, stderr io.Writer) error {
+		command := strings.Join(argv, " ")
+		commands = append(commands, command)
+		if silent {
+			io.WriteString(stderr, loudnormOutput([4]string{"-inf", "0.00", "-inf", "-70.00"}, [4]string{"-inf", "0.00", "-inf", "-70.00"}, "dynamic"))
+			return nil
+		}
+		if strings.Contains(command, "measured_I=") {
+			io.WriteString(stderr, loudnormOutput([4]string{"-27.61", "4.60", "-4.47", "-37.99"},
+				[4]string{"-16.02", "4.10", "-1.50", "-26.31"}, loudnormLinear))
+			return nil
+		}
+		io.WriteString(stderr, loudnormOutput([4]string{"-27.61", "4.60", "-4.47", "-37.99"},
+			[4]string{"-16.40", "3.90", "-1.00", "-26.70"}, "dynamic"))
+		return nil
+	})
+
+	loudness, err := MeasureLoudness(context.Background(), "/test/in.mp4")
+	if err != nil {
+		t.Fatalf("MeasureLoudness() error = %v", err)
+	}
+	input := Loudness{Integrated: -27.61, Range: 4.6, TruePeak: -4.47, Threshold: -37.99}
+	if *loudness != input {
+		t.Errorf("MeasureLoudness() = %+v, want %+v", *loudness, input)
+	}
+
+	commands = nil
+	truePeak := -1.5
+	target := &LoudnessTarget{Integrated: -16, TruePeak: &truePeak}
+	got, err := NormalizeLoudness(context.Background(), "/test/in.mp4", "/test/out.mp4", target)
+	if err != nil {
+		t.Fatalf("NormalizeLoudness() error = %v", err)
+	}
+	want := &LoudnessResult{
+		Input:  input,
+		Output: Loudness{Integrated: -16.02, Range: 4.1, TruePeak: -1.5, Threshold: -26.31},
+		Linear: true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeLoudness() = %+v, want %+v", got, want)
+	}
+	wantCommands := []string{
+		ffmpegBin + " -hide_banner -nostats -loglevel info -i /test/in.mp4 -vn -af loudnorm=I=-16:LRA=7:TP=-1.5:print_format=json -f null -",
+		ffmpegBin + " -hide_banner -nostats -loglevel info -y -i /test/in.mp4 -af loudnorm=I=-16:LRA=7:TP=-1.5:measured_I=-27.61:" +
+			"measured_LRA=4.60:measured_TP=-4.47:measured_thresh=-37.99:offset=0.25:linear=true:print_format=json " +
+			"-ar 44100 -c:v copy /test/out.mp4",
+	}
+	if !reflect.DeepEqual(commands, wantCommands) {
+		t.Errorf("NormalizeLoudness() commands = %v, want %v", commands, wantCommands)
+	}
+
+	// 没有可听见的声音时不进行第二遍
+	commands, silent = nil, true
+	if _, err = NormalizeLoudness(context.Background(), "/test/in.mp4", "/test/out.mp4", nil); err == nil || len(commands) != 1 {
+		t.Errorf("NormalizeLoudness() of silent input should fail, commands = %v", commands)
+	}
+	// 目标超出范围时不执行 ffmpeg
+	commands = nil
+	if _, err = NormalizeLoudness(context.Background(), "/test/in.mp4", "/test/out.mp4", &LoudnessTarget{Integrated: 3}); err == nil || len(commands) != 0 {
+		t.Errorf("NormalizeLoudness() with invalid target should fail, commands = %v", commands)
+	}
+	source = &ProbeInfo{Streams: []Streams{{CodecType: CodecTypeVideo}}}
+	if _, err = NormalizeLoudness(context.Background(), "/test/in.mp4", "/test/out.mp4", nil); err == nil {
+		t.Errorf("NormalizeLoudness() without audio stream should fail")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"path"
+	"strconv"
 
 	"go-utils/src/tools/algorithm"
 	"go-utils/src/tools/fs"
@@ -33,6 +34,7 @@ type Tags struct {
 	AlphaMode      string `json:"ALPHA_MODE"`
 	AlphaModeLower string `json:"alpha_mode"`
 	Duration       string `json:"DURATION"`
+	Rotate         string `json:"rotate,omitempty"`
 }
 
 // SideDataList side data 具体参数含义详见 ffprobe 描述 https://ffmpeg.org/ffprobe.html
@@ -165,6 +167,43 @@ func (p *ProbeInfo) HasAlpha() bool {
 	return false
 }
 
+// GetRotation 获取视频顺时针的旋转角度，与 rotate 标签一致，取值为 0、90、180、270，优先使用 side data 中的 displaymatrix；
+// displaymatrix 的 rotation 为逆时针角度，比如 -90 与 rotate=90 表示同一个视频，因此取反
+func (p *ProbeInfo) GetRotation() int {
+	s := p.GetVideoStream()
+	if s == nil {
+		return 0
+	}
+	rotation := 0
+	for _, sideData := range s.SideDataList {
+		if sideData.Rotation != 0 {
+			rotation = -int(sideData.Rotation)
+			break
+		}
+	}
+	if rotation == 0 && s.Tags.Rotate != "" {
+		rotation, _ = strconv.Atoi(s.Tags.Rotate)
+	}
+	rotation %= 360
+	if rotation < 0 {
+		rotation += 360
+	}
+	return rotation
+}
+
+// GetDisplaySize 获取视频播放时的宽高，旋转 90 或 270 度时宽高互换，没有视频流时返回 0
+func (p *ProbeInfo) GetDisplaySize() (width, height int) {
+	s := p.GetVideoStream()
+	if s == nil {
+		return 0, 0
+	}
+	width, height = s.Width, s.Height
+	if rotation := p.GetRotation(); rotation == 90 || rotation == 270 {
+		width, height = height, width
+	}
+	return width, height
+}
+
 // GetFormatDuration 获取文件时长
 func (p *ProbeInfo) GetFormatDuration() float64 {
 	dur := algorithm.ParseFloat(p.Format.Duration, 0)
@@ -188,3 +188,31 @@ func Test_getExt(t *testing.T) {
 		})
 	}
 }
+
+func TestProbeInfo_GetDisplaySize(t *testing.T) {
+	tests := []struct {
+		name         string
+		stream       Streams
+		wantRotation int
+		wantWidth    int
+		wantHeight   int
+	}{
+		{"normal", Streams{Width: 1920, Height: 1080}, 0, 1920, 1080},
+		{"display_matrix", Streams{Width: 1920, Height: 1080, SideDataList: []SideDataList{{Rotation: -90}}}, 90, 1080, 1920},
+		{"display_matrix_ccw", Streams{Width: 1920, Height: 1080, SideDataList: []SideDataList{{Rotation: 90}}}, 270, 1080, 1920},
+		{"rotate_tag", Streams{Width: 1920, Height: 1080, Tags: Tags{Rotate: "90"}}, 90, 1080, 1920},
+		{"upside_down", Streams{Width: 1920, Height: 1080, Tags: Tags{Rotate: "180"}}, 180, 1920, 1080},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.stream.CodecType = CodecTypeVideo
+			p := &ProbeInfo{Streams: []Streams{tt.stream}}
+			if got := p.GetRotation(); got != tt.wantRotation {
+				t.Errorf("ProbeInfo.GetRotation() = %v, want %v", got, tt.wantRotation)
+			}
+			if width, height := p.GetDisplaySize(); width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("ProbeInfo.GetDisplaySize() = %vx%v, want %vx%v", width, height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package av
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-utils/src/logs"
+)
+
+// Progress ffmpeg -progress 输出的处理进度，无法获取的字段为零值
+type Progress struct {
+	Frame     int64         // 已输出的帧数，纯音频时为 0
+	FPS       float64       // 处理速度，单位帧/秒
+	Bitrate   string        // 输出码率，比如 1024.0kbits/s
+	TotalSize int64         // 已输出的字节数
+	OutTime   time.Duration // 已输出的时长
+	Speed     float64       // 相对于实时播放的倍速
+	Duration  time.Duration // 预计的输出总时长，未知时为 0
+	Done      bool          // ffmpeg 已处理结束
+}
+
+// Percent 完成百分比，总时长未知时返回 -1
+func (p Progress) Percent() float64 {
+	if p.Done {
+		return 100
+	}
+	if p.Duration <= 0 {
+		return -1
+	}
+	percent := float64(p.OutTime) * 100 / float64(p.Duration)
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// ProgressFunc 进度回调函数，ffmpeg 默认每 0.5 秒输出一次进度
+type ProgressFunc func(p Progress)
+
+// progressKey ctx 中保存 ProgressFunc 的 key
+type progressKey struct{}
+
+// ContextWithProgress 返回携带进度回调的 ctx，通过 Command.Run 执行的 av 操作(比如 CutMedia、MergeTS)都会回调处理进度
+func ContextWithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// progressFromContext 获取 ContextWithProgress 设置的回调
+func progressFromContext(ctx context.Context) ProgressFunc {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return fn
+}
+
+// parseClock 解析 ffmpeg 的 HH:MM:SS.micro 时间格式
+func parseClock(value string) (time.Duration, bool) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	hours, err1 := strconv.ParseInt(parts[0], 10, 64)
+	minutes, err2 := strconv.ParseInt(parts[1], 10, 64)
+	seconds, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second)), true
+}
+
+// parseProgress 解析 -progress 输出的 key=value 行，每遇到 progress=continue/end 回调一次
+func parseProgress(r io.Reader, duration time.Duration, fn ProgressFunc) error {
+	p := Progress{Duration: duration}
+	outTimeUs := false // 同时存在时优先使用微秒精度的 out_time_us
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || value == "N/A" {
+			continue
+		}
+		switch key {
+		case "frame":
+			p.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			p.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			p.Bitrate = value
+		case "total_size":
+			p.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time_us", "out_time_ms": // out_time_ms 实际也是微秒
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+				p.OutTime = time.Duration(us) * time.Microsecond
+				outTimeUs = true
+			}
+		case "out_time":
+			if d, ok := parseClock(value); ok && !outTimeUs {
+				p.OutTime = d
+			}
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			p.Done = value == "end"
+			if fn != nil {
+				fn(p)
+			}
+			outTimeUs = false
+		}
+	}
+	return scanner.Err()
+}
+
+// TotalDuration 指定预计的输出总时长，用于计算进度百分比，不指定时通过 Probe 第一个输入估算
+func (c *Command) TotalDuration(d time.Duration) *Command {
+	c.total = d
+	return c
+}
+
+// expectedDuration 预计的输出总时长：第一个输入的时长扣除 -ss 后受输入及输出的 -t 限制，无法估计时返回 0
+func (c *Command) expectedDuration(ctx context.Context) time.Duration {
+	if c.total > 0 || len(c.inputs) == 0 {
+		return c.total
+	}
+	input := c.inputs[0]
+	info, err := Probe(ctx, input.path)
+	if err != nil {
+		logs.Log.Debugf("failed to probe %s for progress: %+v", input.path, err)
+		return 0
+	}
+	total := time.Duration(info.GetFormatDuration()*float64(time.Second)) - input.start
+	limits := []time.Duration{input.dur}
+	for _, output := range c.outputs {
+		limits = append(limits, output.dur)
+	}
+	for _, limit := range limits {
+		if limit > 0 && limit < total {
+			total = limit
+		}
+	}
+	if total < 0 {
+		return 0
+	}
+	return total
+}
+
+// RunWithProgress 执行命令并通过 fn 回调处理进度，百分比参见 TotalDuration，fn 为 nil 时同 Run
+func (c *Command) RunWithProgress(ctx context.Context, fn ProgressFunc) error {
+	if len(c.outputs) == 0 {
+		return errors.New("ffmpeg command without output: " + c.String())
+	}
+	argv := c.Argv()
+	if fn == nil {
+		return c.run(ctx, argv, nil)
+	}
+	duration := c.expectedDuration(ctx)
+	argv = append([]string{argv[0], "-progress", "pipe:1", "-nostats"}, argv[1:]...)
+
+	reader, writer := io.Pipe()
+	parsed := make(chan error, 1)
+	go func() {
+		err := parseProgress(reader, duration, fn)
+		// 解析失败时继续读完输出，避免 ffmpeg 阻塞在写入上
+		io.Copy(ioutil.Discard, reader)
+		parsed <- err
+	}()
+	err := c.run(ctx, argv, writer)
+	writer.Close()
+	if parseErr := <-parsed; err == nil {
+		err = parseErr
+	}
+	return err
+}
+
+// run 执行 argv，设置了 Stderr 时同时写入标准错误
+func (c *Command) run(ctx context.Context, argv []string, stdout io.Writer) error {
+	if c.stderr != nil {
+		return runCommandStderr(ctx, argv, stdout, c.stderr)
+	}
+	return runCommand(ctx, argv, stdout)
+}
@@ -0,0 +1,152 @@
+package av
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/google/uuid"
+)
+
+const progressOutput = `frame=25
+fps=0.00
+stream_0_0_q=28.0
+bitrate=N/A
+total_size=48
+out_time_us=1000000
+out_time_ms=1000000
+out_time=00:00:01.000000
+dup_frames=0
+drop_frames=0
+speed=2.01x
+progress=continue
+frame=100
+fps=50.00
+bitrate= 256.0kbits/s
+total_size=131072
+out_time_us=N/A
+out_time=00:00:04.000000
+speed=   2x
+progress=end
+`
+
+func Test_parseProgress(t *testing.T) {
+	var got []Progress
+	err := parseProgress(strings.NewReader(progressOutput), 4*time.Second, func(p Progress) { got = append(got, p) })
+	if err != nil {
+		t.Fatalf("parseProgress() error = %v", err)
+	}
+	want := []Progress{
+		{Frame: 25, TotalSize: 48, OutTime: time.Second, Speed: 2.01, Duration: 4 * time.Second},
+		{
+			Frame: 100, FPS: 50, Bitrate: "256.0kbits/s", TotalSize: 131072, OutTime: 4 * time.Second,
+			Speed: 2, Duration: 4 * time.Second, Done: true,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseProgress() = %+v, want %+v", got, want)
+	}
+	if got[0].Percent() != 25 || got[1].Percent() != 100 {
+		t.Errorf("Percent() = %v, %v", got[0].Percent(), got[1].Percent())
+	}
+}
+
+func TestProgress_Percent(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Progress
+		want float64
+	}{
+		{"unknown", Progress{OutTime: time.Second}, -1},
+		{"half", Progress{OutTime: time.Second, Duration: 2 * time.Second}, 50},
+		{"overflow", Progress{OutTime: 3 * time.Second, Duration: 2 * time.Second}, 100},
+		{"done", Progress{Done: true}, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Percent(); got != tt.want {
+				t.Errorf("Progress.Percent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand_expectedDuration(t *testing.T) {
+	patches := gomonkey.ApplyFuncReturn(Probe, &ProbeInfo{Format: Format{Duration: "10.5"}}, nil)
+	defer patches.Reset()
+
+	tests := []struct {
+		name  string
+		build func() *Command
+		want  time.Duration
+	}{
+		{"probe", func() *Command {
+			cmd := NewCommand()
+			cmd.Input("in.mp4")
+			return cmd
+		}, 10500 * time.Millisecond},
+		{"seek", func() *Command {
+			cmd := NewCommand()
+			cmd.Input("in.mp4").Seek(500 * time.Millisecond)
+			return cmd
+		}, 10 * time.Second},
+		{"input_duration", func() *Command {
+			cmd := NewCommand()
+			cmd.Input("in.mp4").Seek(time.Second).Duration(3 * time.Second)
+			return cmd
+		}, 3 * time.Second},
+		{"output_duration", func() *Command {
+			cmd := NewCommand()
+			cmd.Input("in.mp4")
+			cmd.Output("out.mp4").Duration(2 * time.Second)
+			return cmd
+		}, 2 * time.Second},
+		{"total", func() *Command {
+			cmd := NewCommand().TotalDuration(time.Minute)
+			cmd.Input("in.mp4")
+			return cmd
+		}, time.Minute},
+		{"no_input", NewCommand, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.build().expectedDuration(context.Background()); got != tt.want {
+				t.Errorf("Command.expectedDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand_RunWithProgress(t *testing.T) {
+	// 用脚本模拟 ffmpeg 输出进度
+	script := path.Join(os.TempDir(), uuid.New().String()+".sh")
+	content := "#!/bin/sh\ncat <<'EOF'\n" + progressOutput + "EOF\n[ \"$2\" = pipe:1 ] || exit 1\n"
+	if err := ioutil.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(script)
+
+	var got []float64
+	ctx := ContextWithProgress(context.Background(), func(p Progress) { got = append(got, p.Percent()) })
+	cmd := NewCommand().Bin(script).TotalDuration(2 * time.Second)
+	cmd.Input("in.mp4")
+	cmd.Output("out.mp4").Copy()
+	if err := cmd.Run(ctx); err != nil {
+		t.Fatalf("Command.Run() error = %v", err)
+	}
+	if want := []float64{50, 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Command.Run() progress = %v, want %v", got, want)
+	}
+
+	cmd = NewCommand().Bin("false")
+	cmd.Output("out.mp4")
+	if err := cmd.RunWithProgress(context.Background(), nil); err == nil {
+		t.Errorf("Command.RunWithProgress() should fail")
+	}
+}
@@ -0,0 +1,270 @@
+package av
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-utils/src/tools/fs"
+)
+
+// 截图相关的默认值
+const (
+	defaultSceneThreshold = 0.4
+	defaultSpriteInterval = 10 * time.Second
+	defaultSpriteColumns  = 10
+	defaultTileWidth      = 160
+)
+
+// Thumbnail 截取的一帧图片
+type Thumbnail struct {
+	Path string        // 图片路径
+	Time time.Duration // 在视频中的时刻
+}
+
+// probeDuration 通过 Probe 获取时长
+func probeDuration(ctx context.Context, inputPath string) (*ProbeInfo, time.Duration, error) {
+	info, err := Probe(ctx, inputPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	duration := time.Duration(info.GetFormatDuration() * float64(time.Second))
+	if duration <= 0 {
+		return nil, 0, fmt.Errorf("unknown duration of %s", inputPath)
+	}
+	return info, duration, nil
+}
+
+// scaleFilter 等比缩放到 width 宽度，高度取偶数
+func scaleFilter(width int) Filter {
+	return NewFilter("scale", strconv.Itoa(width), "-2")
+}
+
+// ExtractFrame 截取 at 时刻的一帧保存为图片，图片格式由 outputPath 的后缀决定，width > 0 时等比缩放到该宽度，
+// ffmpeg 默认按照视频的旋转角度自动旋转；at 超出视频时长时返回错误
+func ExtractFrame(ctx context.Context, inputPath string, at time.Duration, outputPath string, width int) error {
+	cmd := NewCommand().Overwrite().LogLevel("error")
+	cmd.Input(inputPath).Seek(at)
+	output := cmd.Output(outputPath).Option("-frames:v", "1").Disable(StreamAudio)
+	if width > 0 {
+		output.VideoFilter(scaleFilter(width))
+	}
+	// 删除旧文件，ffmpeg 截取不到画面时不会生成输出
+	os.Remove(outputPath)
+	if err := cmd.Run(ctx); err != nil {
+		return err
+	}
+	if !fs.IsFile(outputPath) {
+		return fmt.Errorf("no frame at %v of %s", at, inputPath)
+	}
+	return nil
+}
+
+// ExtractThumbnails 截取 n 张均匀分布的缩略图保存到 outputDir，第 i 张取自第 i 段的中间时刻，避开片头片尾的黑屏
+func ExtractThumbnails(ctx context.Context, inputPath, outputDir string, n, width int) ([]Thumbnail, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid thumbnail count %d", n)
+	}
+	_, duration, err := probeDuration(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+	name := fs.GetFileName(inputPath)
+	thumbnails := make([]Thumbnail, 0, n)
+	for i := 0; i < n; i++ {
+		at := duration * time.Duration(2*i+1) / time.Duration(2*n)
+		outputPath := filepath.Join(outputDir, fmt.Sprintf("%s_%03d.jpg", name, i+1))
+		if err = ExtractFrame(ctx, inputPath, at, outputPath, width); err != nil {
+			return thumbnails, err
+		}
+		thumbnails = append(thumbnails, Thumbnail{Path: outputPath, Time: at})
+	}
+	return thumbnails, nil
+}
+
+// parseFrameTimes 解析 metadata=print 滤镜输出的每一帧的时刻，比如 frame:0    pts:125     pts_time:5.005
+func parseFrameTimes(r io.Reader) []time.Duration {
+	var times []time.Duration
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "frame:") {
+			continue
+		}
+		for _, field := range strings.Fields(line) {
+			if value := strings.TrimPrefix(field, "pts_time:"); value != field {
+				seconds, _ := strconv.ParseFloat(value, 64)
+				times = append(times, time.Duration(seconds*float64(time.Second)))
+			}
+		}
+	}
+	return times
+}
+
+// ExtractSceneFrames 截取画面切换处的帧保存到 outputDir，threshold 为场景变化的阈值(0~1)，<= 0 时使用 0.4，
+// 越小截取的帧越多；limit > 0 时最多截取 limit 张，width 同 ExtractFrame
+func ExtractSceneFrames(
+	ctx context.Context,
+	inputPath, outputDir string,
+	threshold float64,
+	limit, width int,
+) ([]Thumbnail, error) {
+	if threshold <= 0 {
+		threshold = defaultSceneThreshold
+	}
+	pattern := filepath.Join(outputDir, fs.GetFileName(inputPath)+"_scene_%03d.jpg")
+	filters := []Filter{
+		NewFilter("select", EscapeFilterArg(fmt.Sprintf("gt(scene,%g)", threshold))),
+		// 输出选中帧的时刻到 stdout
+		NewFilter("metadata", "print", "file=-"),
+	}
+	if width > 0 {
+		filters = append(filters, scaleFilter(width))
+	}
+	cmd := NewCommand().Overwrite().LogLevel("error")
+	cmd.Input(inputPath)
+	output := cmd.Output(pattern).VideoFilter(filters...).Option("-vsync", "vfr").Disable(StreamAudio)
+	if limit > 0 {
+		output.Option("-frames:v", strconv.Itoa(limit))
+	}
+	var stdout bytes.Buffer
+	if err := runCommand(ctx, cmd.Argv(), &stdout); err != nil {
+		return nil, err
+	}
+	var thumbnails []Thumbnail
+	for i, at := range parseFrameTimes(&stdout) {
+		if limit > 0 && i >= limit {
+			break
+		}
+		// image2 的序号从 1 开始
+		outputPath := fmt.Sprintf(pattern, i+1)
+		if !fs.IsFile(outputPath) {
+			break
+		}
+		thumbnails = append(thumbnails, Thumbnail{Path: outputPath, Time: at})
+	}
+	return thumbnails, nil
+}
+
+// SpriteOptions 雪碧图参数，零值使用默认值
+type SpriteOptions struct {
+	Interval  time.Duration // 每隔多久截取一帧，默认 10s
+	Columns   int           // 每行的图片数，默认 10
+	TileWidth int           // 每张图片的宽度，高度按照视频旋转后的显示比例计算，默认 160
+	ImageURL  string        // WebVTT 中引用雪碧图的地址，默认为雪碧图的文件名
+	VTTPath   string        // WebVTT 文件路径，默认与雪碧图同名，后缀为 .vtt
+}
+
+// SpriteTile 雪碧图中的一张图片，对应视频中 [Start, End) 的画面
+type SpriteTile struct {
+	Start, End    time.Duration
+	X, Y          int
+	Width, Height int
+}
+
+// Sprite 生成的雪碧图及 WebVTT 索引
+type Sprite struct {
+	Path    string // 雪碧图路径
+	VTTPath string // WebVTT 文件路径
+	Columns int
+	Rows    int
+	Tiles   []SpriteTile
+}
+
+// formatVTTTime WebVTT 的时间格式 HH:MM:SS.mmm
+func formatVTTTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// WebVTT 生成描述每张图片坐标的 WebVTT 内容，播放器通过 imageURL#xywh=x,y,w,h 截取对应的图片
+func (s *Sprite) WebVTT(imageURL string) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, tile := range s.Tiles {
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatVTTTime(tile.Start), formatVTTTime(tile.End), imageURL, tile.X, tile.Y, tile.Width, tile.Height)
+	}
+	return b.String()
+}
+
+// GenerateSprite 每隔 Interval 截取一帧，拼接成一张雪碧图保存到 outputPath，同时生成 WebVTT 索引，用于进度条预览
+func GenerateSprite(ctx context.Context, inputPath, outputPath string, opts *SpriteOptions) (*Sprite, error) {
+	o := SpriteOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Interval <= 0 {
+		o.Interval = defaultSpriteInterval
+	}
+	if o.Columns <= 0 {
+		o.Columns = defaultSpriteColumns
+	}
+	if o.TileWidth <= 0 {
+		o.TileWidth = defaultTileWidth
+	}
+	if o.ImageURL == "" {
+		o.ImageURL = filepath.Base(outputPath)
+	}
+	if o.VTTPath == "" {
+		o.VTTPath = fs.GetNameWithNewExt(outputPath, ".vtt")
+	}
+
+	info, duration, err := probeDuration(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+	width, height := info.GetDisplaySize()
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("no video stream in %s", inputPath)
+	}
+	tileWidth := o.TileWidth &^ 1
+	tileHeight := (tileWidth*height/width + 1) &^ 1
+
+	count := int((duration + o.Interval - 1) / o.Interval)
+	sprite := &Sprite{Path: outputPath, VTTPath: o.VTTPath, Columns: o.Columns}
+	if count < sprite.Columns {
+		sprite.Columns = count
+	}
+	sprite.Rows = (count + sprite.Columns - 1) / sprite.Columns
+	for i := 0; i < count; i++ {
+		tile := SpriteTile{
+			Start:  time.Duration(i) * o.Interval,
+			End:    time.Duration(i+1) * o.Interval,
+			X:      i % sprite.Columns * tileWidth,
+			Y:      i / sprite.Columns * tileHeight,
+			Width:  tileWidth,
+			Height: tileHeight,
+		}
+		if tile.End > duration {
+			tile.End = duration
+		}
+		sprite.Tiles = append(sprite.Tiles, tile)
+	}
+
+	cmd := NewCommand().Overwrite().LogLevel("error").TotalDuration(duration)
+	cmd.Input(inputPath)
+	cmd.Output(outputPath).
+		VideoFilter(
+			NewFilter("fps", fmt.Sprintf("1/%g", o.Interval.Seconds())),
+			NewFilter("scale", strconv.Itoa(tileWidth), strconv.Itoa(tileHeight)),
+			NewFilter("tile", fmt.Sprintf("%dx%d", sprite.Columns, sprite.Rows)),
+		).
+		Option("-frames:v", "1").
+		Disable(StreamAudio)
+	if err = cmd.Run(ctx); err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(sprite.VTTPath, []byte(sprite.WebVTT(o.ImageURL)), 0644); err != nil {
+		return nil, fmt.Errorf("write %s => %w", sprite.VTTPath, err)
+	}
+	return sprite, nil
+}
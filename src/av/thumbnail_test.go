@@ -0,0 +1,170 @@
+package av
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+)
+
+// patchFFmpeg 模拟 ffmpeg 执行：记录命令，createOutput 时创建输出文件，并向 stdout 写入 stdout
+func patchFFmpeg(patches *gomonkey.Patches, createOutput bool, stdout string, commands *[][]string) *gomonkey.Patches {
+	return patches.ApplyFunc(runCommand, func(_ context.Context, argv []string, w io.Writer) error {
+		*commands = append(*commands, argv)
+		if w != nil {
+			io.WriteString(w, stdout)
+		}
+		if createOutput {
+			return ioutil.WriteFile(argv[len(argv)-1], []byte("jpg"), 0644)
+		}
+		return nil
+	})
+}
+
+func TestExtractThumbnails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thumbnail-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var commands [][]string
+	patches := gomonkey.ApplyFuncReturn(Probe, &ProbeInfo{Format: Format{Duration: "10"}}, nil)
+	patches = patchFFmpeg(patches, true, "", &commands)
+	defer patches.Reset()
+
+	got, err := ExtractThumbnails(context.Background(), "/test/in.mp4", dir, 4, 320)
+	if err != nil {
+		t.Fatalf("ExtractThumbnails() error = %v", err)
+	}
+	want := []Thumbnail{
+		{filepath.Join(dir, "in_001.jpg"), 1250 * time.Millisecond},
+		{filepath.Join(dir, "in_002.jpg"), 3750 * time.Millisecond},
+		{filepath.Join(dir, "in_003.jpg"), 6250 * time.Millisecond},
+		{filepath.Join(dir, "in_004.jpg"), 8750 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractThumbnails() = %v, want %v", got, want)
+	}
+	wantCmd := ffmpegBin + " -y -loglevel error -ss 1250000us -i /test/in.mp4 -frames:v 1 -an -vf scale=320:-2 " +
+		filepath.Join(dir, "in_001.jpg")
+	if got := strings.Join(commands[0], " "); got != wantCmd {
+		t.Errorf("ExtractThumbnails() command = %v, want %v", got, wantCmd)
+	}
+
+	if _, err = ExtractThumbnails(context.Background(), "/test/in.mp4", dir, 0, 0); err == nil {
+		t.Errorf("ExtractThumbnails() with n = 0 should fail")
+	}
+}
+
+func TestExtractFrame_noFrame(t *testing.T) {
+	var commands [][]string
+	patches := patchFFmpeg(gomonkey.NewPatches(), false, "", &commands)
+	defer patches.Reset()
+
+	outputPath := filepath.Join(os.TempDir(), "2d0c51a4-7b5e-4a57-9e55-no-frame.jpg")
+	if err := ExtractFrame(context.Background(), "/test/in.mp4", time.Hour, outputPath, 0); err == nil {
+		t.Errorf("ExtractFrame() beyond duration should fail")
+	}
+}
+
+func TestExtractSceneFrames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scene-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"in_scene_001.jpg", "in_scene_002.jpg"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("jpg"), 0644)
+	}
+
+	stdout := "frame:0    pts:64      pts_time:2.56\nlavfi.scene_score=0.52\n" +
+		"frame:1    pts:250     pts_time:10\nlavfi.scene_score=0.91\n"
+	var commands [][]string
+	patches := patchFFmpeg(gomonkey.NewPatches(), false, stdout, &commands)
+	defer patches.Reset()
+
+	got, err := ExtractSceneFrames(context.Background(), "/test/in.mp4", dir, 0, 5, 0)
+	if err != nil {
+		t.Fatalf("ExtractSceneFrames() error = %v", err)
+	}
+	want := []Thumbnail{
+		{filepath.Join(dir, "in_scene_001.jpg"), 2560 * time.Millisecond},
+		{filepath.Join(dir, "in_scene_002.jpg"), 10 * time.Second},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractSceneFrames() = %v, want %v", got, want)
+	}
+	wantCmd := ffmpegBin + ` -y -loglevel error -i /test/in.mp4 -vf select=gt(scene\,0.4),metadata=print:file=- ` +
+		"-vsync vfr -an -frames:v 5 " + filepath.Join(dir, "in_scene_%03d.jpg")
+	if got := strings.Join(commands[0], " "); got != wantCmd {
+		t.Errorf("ExtractSceneFrames() command = %v, want %v", got, wantCmd)
+	}
+}
+
+func TestGenerateSprite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sprite-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 竖屏拍摄的视频，旋转后显示为 1080x1920
+	var commands [][]string
+	patches := gomonkey.ApplyFuncReturn(Probe, &ProbeInfo{
+		Streams: []Streams{{
+			CodecType:    CodecTypeVideo,
+			Width:        1920,
+			Height:       1080,
+			SideDataList: []SideDataList{{SideDataType: "Display Matrix", Rotation: -90}},
+		}},
+		Format: Format{Duration: "25.5"},
+	}, nil)
+	patches = patchFFmpeg(patches, true, "", &commands)
+	defer patches.Reset()
+
+	outputPath := filepath.Join(dir, "sprite.jpg")
+	got, err := GenerateSprite(context.Background(), "/test/in.mp4", outputPath, &SpriteOptions{Columns: 2})
+	if err != nil {
+		t.Fatalf("GenerateSprite() error = %v", err)
+	}
+	if got.Columns != 2 || got.Rows != 2 || len(got.Tiles) != 3 || got.VTTPath != filepath.Join(dir, "sprite.vtt") {
+		t.Errorf("GenerateSprite() = %+v", got)
+	}
+	wantCmd := ffmpegBin + " -y -loglevel error -i /test/in.mp4 -vf fps=1/10,scale=160:284,tile=2x2 -frames:v 1 -an " + outputPath
+	if got := strings.Join(commands[0], " "); got != wantCmd {
+		t.Errorf("GenerateSprite() command = %v, want %v", got, wantCmd)
+	}
+	wantVTT := "WEBVTT\n\n" +
+		"00:00:00.000 --> 00:00:10.000\nsprite.jpg#xywh=0,0,160,284\n\n" +
+		"00:00:10.000 --> 00:00:20.000\nsprite.jpg#xywh=160,0,160,284\n\n" +
+		"00:00:20.000 --> 00:00:25.500\nsprite.jpg#xywh=0,284,160,284\n"
+	if data, _ := ioutil.ReadFile(got.VTTPath); string(data) != wantVTT {
+		t.Errorf("GenerateSprite() vtt = %q, want %q", data, wantVTT)
+	}
+}
+
+func Test_formatVTTTime(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "00:00:00.000"},
+		{1500 * time.Millisecond, "00:00:01.500"},
+		{time.Hour + 2*time.Minute + 3*time.Second + 4*time.Millisecond, "01:02:03.004"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatVTTTime(tt.d); got != tt.want {
+				t.Errorf("formatVTTTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
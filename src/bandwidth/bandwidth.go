@@ -0,0 +1,195 @@
+// Package bandwidth 按字节数限速，用于限制下载、上传及本地拷贝占用的带宽。
+// 单个传输使用自己的 Limiter 即为单独限速，多个传输共享同一个 Limiter 即为共享的全局带宽
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxChunk 单次读写的最大字节数，避免一次读写大块数据后长时间停顿
+const maxChunk = 32 << 10
+
+// Limiter 令牌桶，可以在多个协程中共享，按字节限速时一个令牌对应一个字节。nil *Limiter 表示不限速
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒产生的令牌数，<= 0 表示不限速
+	burst  float64 // 桶容量，即空闲后允许的突发量
+	tokens float64 // 当前令牌数，可以为负数，表示已预支的令牌数
+	last   time.Time
+}
+
+// NewLimiter 构造限速为 bytesPerSecond 字节/秒的 Limiter，允许一秒的突发流量；bytesPerSecond <= 0 时返回 nil，表示不限速
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return NewLimiterWithBurst(rate, rate)
+}
+
+// NewLimiterWithBurst 构造每秒产生 rate 个令牌、容量为 burst 的令牌桶，初始时桶是满的；rate <= 0 时不限速。
+// 除了按字节限速，也可以用于按请求数限速
+func NewLimiterWithBurst(rate, burst float64) *Limiter {
+	return &Limiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Rate 限速，单位字节/秒，不限速时返回 0
+func (l *Limiter) Rate() int64 {
+	if l == nil || l.rate <= 0 {
+		return 0
+	}
+	return int64(l.rate)
+}
+
+// ReserveN 在 now 时刻预支 n 个令牌，返回需要等待的时间；放弃等待时需要调用 CancelN 归还
+func (l *Limiter) ReserveN(now time.Time, n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// CancelN 归还 ReserveN 预支的 n 个令牌
+func (l *Limiter) CancelN(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return
+	}
+	l.tokens += float64(n)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// WaitN 等待直到允许传输 n 字节，ctx 取消时返回 ctx.Err() 并归还令牌
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	return waitAll(ctx, []*Limiter{l}, n)
+}
+
+// waitAll 先在所有 Limiter 上预支 n 字节，再等待其中最长的时间，即以最严格的限速为准；
+// ctx 取消时归还所有预支的令牌
+func waitAll(ctx context.Context, limiters []*Limiter, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	now := time.Now()
+	var wait time.Duration
+	for _, l := range limiters {
+		if d := l.ReserveN(now, n); d > wait {
+			wait = d
+		}
+	}
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		for _, l := range limiters {
+			l.CancelN(n)
+		}
+		return ctx.Err()
+	}
+}
+
+// compact 去掉 nil 的 Limiter
+func compact(limiters []*Limiter) []*Limiter {
+	var result []*Limiter
+	for _, l := range limiters {
+		if l != nil {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// reader 限速的 io.Reader
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	n, err := r.r.Read(p)
+	if waitErr := waitAll(r.ctx, r.limiters, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+// NewReader 返回同时受所有 limiters 限速的 io.Reader，比如单个传输的限速和全局共享的限速，
+// 读取后等待，ctx 取消时返回 ctx.Err()。limiters 都为 nil 时直接返回 r
+func NewReader(ctx context.Context, r io.Reader, limiters ...*Limiter) io.Reader {
+	limiters = compact(limiters)
+	if len(limiters) == 0 {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, limiters: limiters}
+}
+
+// writer 限速的 io.Writer
+type writer struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxChunk {
+			chunk = chunk[:maxChunk]
+		}
+		if err := waitAll(w.ctx, w.limiters, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// NewWriter 返回同时受所有 limiters 限速的 io.Writer，写入前等待，参见 NewReader
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) io.Writer {
+	limiters = compact(limiters)
+	if len(limiters) == 0 {
+		return w
+	}
+	return &writer{ctx: ctx, w: w, limiters: limiters}
+}
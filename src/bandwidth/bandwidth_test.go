@@ -0,0 +1,117 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLimiter(t *testing.T) {
+	assert.Nil(t, NewLimiter(0))
+	assert.Nil(t, NewLimiter(-1))
+	var l *Limiter
+	assert.Nil(t, l.WaitN(context.Background(), 1<<20))
+	assert.Equal(t, int64(0), l.Rate())
+	assert.Equal(t, int64(100), NewLimiter(100).Rate())
+
+	r := bytes.NewReader(nil)
+	assert.Equal(t, io.Reader(r), NewReader(context.Background(), r, nil, nil))
+}
+
+func TestLimiter_WaitN(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(100 << 10)
+	start := time.Now()
+	// 突发 100K 不需要等待
+	assert.Nil(t, l.WaitN(ctx, 100<<10))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+	// 再传输 20K 需要等待约 200ms
+	assert.Nil(t, l.WaitN(ctx, 20<<10))
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// 等待期间 ctx 取消时归还令牌
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err := l.WaitN(ctx, 100<<10)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, l.ReserveN(time.Now(), 0), 100*time.Millisecond)
+}
+
+func Test_waitAll(t *testing.T) {
+	ctx := context.Background()
+	global, transfer := NewLimiter(100<<10), NewLimiter(50<<10)
+	// 清空两个桶
+	assert.Nil(t, waitAll(ctx, []*Limiter{global, transfer}, 50<<10))
+	global.ReserveN(time.Now(), 50<<10)
+
+	// 同时受两个限速时等待最严格的一个(20K/50K/s 约 400ms)，而不是两者之和(约 600ms)
+	start := time.Now()
+	assert.Nil(t, waitAll(ctx, []*Limiter{global, transfer}, 20<<10))
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 350*time.Millisecond)
+	assert.Less(t, elapsed, 550*time.Millisecond)
+}
+
+func TestNewReader(t *testing.T) {
+	data := make([]byte, 120<<10)
+	tests := []struct {
+		name     string
+		readers  int
+		limiters func() []*Limiter // 每个 reader 使用的 limiters
+		minTime  time.Duration
+	}{
+		{"unlimited", 1, func() []*Limiter { return nil }, 0},
+		{"per_transfer", 2, func() []*Limiter { return []*Limiter{NewLimiter(100 << 10)} }, 150 * time.Millisecond},
+		{"shared", 2, nil, 1100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shared := NewLimiter(100 << 10)
+			start := time.Now()
+			var wg sync.WaitGroup
+			for i := 0; i < tt.readers; i++ {
+				limiters := []*Limiter{shared}
+				if tt.limiters != nil {
+					limiters = tt.limiters()
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					got, err := ioutil.ReadAll(NewReader(context.Background(), bytes.NewReader(data), limiters...))
+					assert.Nil(t, err)
+					assert.Equal(t, len(data), len(got))
+				}()
+			}
+			wg.Wait()
+			elapsed := time.Since(start)
+			assert.GreaterOrEqual(t, elapsed, tt.minTime)
+			// 每个 reader 单独限速时可以并行
+			assert.Less(t, elapsed, tt.minTime+800*time.Millisecond)
+		})
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(context.Background(), &buf, NewLimiter(100<<10), nil)
+	start := time.Now()
+	n, err := w.Write(make([]byte, 120<<10))
+	assert.Nil(t, err)
+	assert.Equal(t, 120<<10, n)
+	assert.Equal(t, 120<<10, buf.Len())
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = NewWriter(ctx, &buf, NewLimiter(1))
+	n, err = w.Write(make([]byte, 10))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 0, n)
+}
@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/url"
@@ -11,6 +12,8 @@ import (
 	"time"
 
 	"errors"
+	"go-utils/src/bandwidth"
+	"go-utils/src/progress"
 )
 
 // GetParentAbsDir 获取所在目录的绝对路径
@@ -60,6 +63,26 @@ func FileNameAppend(inputPath, appText, defaultExt string) string {
 
 // LocalCopy 本地拷贝
 func LocalCopy(src, dst string) (int64, error) {
+	return LocalCopyWithProgress(src, dst, nil)
+}
+
+// LocalCopyWithProgress 本地文件拷贝，并通过 observer 回调拷贝进度
+func LocalCopyWithProgress(src, dst string, observer *progress.Observer) (int64, error) {
+	return LocalCopyWithOptions(context.Background(), src, dst, &CopyOptions{Progress: observer})
+}
+
+// CopyOptions 本地拷贝参数
+type CopyOptions struct {
+	Progress       *progress.Observer // 拷贝进度回调
+	BytesPerSecond int64              // 本次拷贝的限速，单位字节/秒，0 表示不限速
+	Bandwidth      *bandwidth.Limiter // 多个拷贝共享的限速，与 BytesPerSecond 同时生效
+}
+
+// LocalCopyWithOptions 本地文件拷贝，支持进度回调及限速，限速等待期间 ctx 取消时返回 ctx.Err()
+func LocalCopyWithOptions(ctx context.Context, src, dst string, opts *CopyOptions) (int64, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
 	sourceFileStat, err := os.Stat(src)
 	if err != nil {
 		return 0, err
@@ -83,8 +106,14 @@ func LocalCopy(src, dst string) (int64, error) {
 		return 0, err
 	}
 	defer dstHandle.Close()
-	nBytes, err := io.Copy(dstHandle, srcHandle)
-	return nBytes, err
+	tracker := progress.NewTracker(sourceFileStat.Size(), opts.Progress)
+	reader := bandwidth.NewReader(ctx, srcHandle, bandwidth.NewLimiter(opts.BytesPerSecond), opts.Bandwidth)
+	nBytes, err := io.Copy(dstHandle, tracker.Reader(reader))
+	if err != nil {
+		return nBytes, err
+	}
+	tracker.Finish()
+	return nBytes, nil
 }
 
 // GetNameWithNewExt 替换新后缀
@@ -1,11 +1,15 @@
 package fs
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
 	"time"
+
+	"go-utils/src/bandwidth"
+	"go-utils/src/progress"
 )
 
 func TestGetParentAbsDir(t *testing.T) {
@@ -174,6 +178,86 @@ func TestLocalCopy(t *testing.T) {
 	}
 }
 
+func TestLocalCopyWithProgress(t *testing.T) {
+	tmpFile, err := ioutil.TempFile(os.TempDir(), "simple")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write([]byte(strings.Repeat("0123456789", 10000)))
+	if err != nil {
+		return
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name() + ".bak")
+
+	var reports []progress.Progress
+	observer := &progress.Observer{Interval: -1, Func: func(p progress.Progress) {
+		reports = append(reports, p)
+	}}
+	got, err := LocalCopyWithProgress(tmpFile.Name(), tmpFile.Name()+".bak", observer)
+	if err != nil || got != 100000 {
+		t.Errorf("LocalCopyWithProgress() = %v, err %v", got, err)
+		return
+	}
+	if len(reports) == 0 {
+		t.Errorf("LocalCopyWithProgress() no progress reported")
+		return
+	}
+	last := reports[len(reports)-1]
+	if last.Done != 100000 || last.Total != 100000 || last.ETA != 0 {
+		t.Errorf("LocalCopyWithProgress() last progress = %+v", last)
+	}
+}
+
+func TestLocalCopyWithOptions(t *testing.T) {
+	tmpFile, err := ioutil.TempFile(os.TempDir(), "simple")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write([]byte(strings.Repeat("0123456789", 12000)))
+	if err != nil {
+		return
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name() + ".bak")
+
+	shared := bandwidth.NewLimiter(1)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		opts    *CopyOptions
+		wantErr bool
+		minTime time.Duration
+	}{
+		{"nil_opts", context.Background(), nil, false, 0},
+		{"per_copy", context.Background(), &CopyOptions{BytesPerSecond: 100000}, false, 150 * time.Millisecond},
+		{"shared_canceled", canceled, &CopyOptions{Bandwidth: shared}, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			got, err := LocalCopyWithOptions(tt.ctx, tmpFile.Name(), tmpFile.Name()+".bak", tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("[%v] LocalCopyWithOptions() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != 120000 {
+				t.Errorf("[%v] LocalCopyWithOptions() = %v, want 120000", tt.name, got)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minTime {
+				t.Errorf("[%v] LocalCopyWithOptions() elapsed %v, want >= %v", tt.name, elapsed, tt.minTime)
+			}
+		})
+	}
+}
+
 func TestGetNameWithNewExt(t *testing.T) {
 	type args struct {
 		u   string
@@ -0,0 +1,452 @@
+package httputil
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-utils/src/hash"
+	"go-utils/src/logs"
+)
+
+// 缓存文件后缀，每个 url 对应一个 meta 文件和一个 body 文件
+const (
+	cacheMetaExt = ".meta"
+	cacheBodyExt = ".body"
+)
+
+// Cache 响应头 X-Cache 的取值
+const (
+	CacheHeader      = "X-Cache"
+	CacheHit         = "HIT"         // 缓存未过期，直接使用缓存
+	CacheRevalidated = "REVALIDATED" // 缓存过期，服务端返回 304 后使用缓存
+)
+
+// cacheEntry 缓存的响应，序列化为 meta 文件
+type cacheEntry struct {
+	URL     string      `json:"url"`
+	Header  http.Header `json:"header"`
+	Expires time.Time   `json:"expires"` // 过期时间，之后需要重新校验
+	Size    int64       `json:"size"`    // body 大小
+
+	key string
+}
+
+// validators 重新校验时使用的 If-None-Match/If-Modified-Since
+func (e *cacheEntry) validators() http.Header {
+	header := make(http.Header)
+	if etag := e.Header.Get("ETag"); etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := e.Header.Get("Last-Modified"); lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+	return header
+}
+
+// Cache 以 url 为 key 的磁盘 HTTP 缓存，按最近使用时间淘汰，总大小不超过 maxSize，可以被多个 Client 共享。
+// 只缓存 GET 的 200 响应，不缓存 Range 请求、no-store 及带有 Vary 的响应
+type Cache struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+	lru     *list.List               // 最近使用的在前，元素为 *cacheEntry
+	entries map[string]*list.Element // key => lru 元素
+	size    int64
+}
+
+// NewCache 构造磁盘缓存，dir 不存在时自动创建，已有的缓存文件按修改时间恢复 LRU 顺序
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{dir: dir, maxSize: maxSize, lru: list.New(), entries: make(map[string]*list.Element)}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load 加载 dir 中已有的缓存
+func (c *Cache) load() error {
+	metas, err := filepath.Glob(filepath.Join(c.dir, "*"+cacheMetaExt))
+	if err != nil {
+		return err
+	}
+	type loaded struct {
+		entry   *cacheEntry
+		modTime time.Time
+	}
+	var items []loaded
+	for _, meta := range metas {
+		key := strings.TrimSuffix(filepath.Base(meta), cacheMetaExt)
+		entry, err := c.readEntry(key)
+		if err != nil {
+			logs.Log.Infof("drop invalid cache %v, err:%v", meta, err)
+			c.removeFiles(key)
+			continue
+		}
+		fi, _ := os.Stat(meta)
+		items = append(items, loaded{entry, fi.ModTime()})
+	}
+	// 最近使用的在前
+	sort.Slice(items, func(i, j int) bool { return items[i].modTime.After(items[j].modTime) })
+	for _, item := range items {
+		c.entries[item.entry.key] = c.lru.PushBack(item.entry)
+		c.size += item.entry.Size
+	}
+	c.evict()
+	return nil
+}
+
+func (c *Cache) path(key, ext string) string {
+	return filepath.Join(c.dir, key+ext)
+}
+
+func (c *Cache) readEntry(key string) (*cacheEntry, error) {
+	data, err := ioutil.ReadFile(c.path(key, cacheMetaExt))
+	if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{key: key}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(c.path(key, cacheBodyExt))
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() != entry.Size {
+		return nil, fmt.Errorf("body size %v, want %v", fi.Size(), entry.Size)
+	}
+	return entry, nil
+}
+
+// writeMeta 先写临时文件再改名，保证 meta 文件完整
+func (c *Cache) writeMeta(entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmpPath := c.path(entry.key, cacheMetaExt) + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, c.path(entry.key, cacheMetaExt))
+}
+
+func (c *Cache) removeFiles(key string) {
+	os.Remove(c.path(key, cacheMetaExt))
+	os.Remove(c.path(key, cacheBodyExt))
+}
+
+// get 返回 url 对应的缓存并标记为最近使用
+func (c *Cache) get(url string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[hash.Md5String(url)]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*cacheEntry)
+	now := time.Now()
+	os.Chtimes(c.path(entry.key, cacheMetaExt), now, now)
+	return entry
+}
+
+// add 加入已经写入磁盘的缓存，并淘汰超出大小的缓存
+func (c *Cache) add(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[entry.key]; ok {
+		c.size -= elem.Value.(*cacheEntry).Size
+		c.lru.Remove(elem)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.Size
+	c.evict()
+}
+
+// remove 删除 url 对应的缓存
+func (c *Cache) remove(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := hash.Md5String(url)
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*cacheEntry).Size
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+	c.removeFiles(key)
+}
+
+// evict 从最久未使用的缓存开始删除，直到总大小不超过 maxSize，调用时需要持有锁
+func (c *Cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		elem := c.lru.Back()
+		entry := elem.Value.(*cacheEntry)
+		c.lru.Remove(elem)
+		delete(c.entries, entry.key)
+		c.size -= entry.Size
+		c.removeFiles(entry.key)
+	}
+}
+
+// Size 缓存的总大小
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// open 打开缓存的 body 构造响应
+func (c *Cache) open(entry *cacheEntry, req *http.Request, status string) (*http.Response, error) {
+	body, err := os.Open(c.path(entry.key, cacheBodyExt))
+	if err != nil {
+		return nil, err
+	}
+	header := entry.Header.Clone()
+	header.Set(CacheHeader, status)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: entry.Size,
+		Request:       req,
+	}, nil
+}
+
+// parseCacheControl 解析 Cache-Control，指令名转为小写
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, arg, _ := strings.Cut(part, "=")
+			directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return directives
+}
+
+// expiresAt 按照 Cache-Control 的 max-age(扣除 Age)、no-cache 或 Expires 计算过期时间，都没有时立即过期
+func expiresAt(header http.Header, now time.Time) time.Time {
+	cc := parseCacheControl(header)
+	if _, ok := cc["no-cache"]; ok {
+		return now
+	}
+	if maxAge, ok := cc["max-age"]; ok {
+		seconds, err := strconv.ParseInt(maxAge, 10, 64)
+		if err != nil {
+			return now
+		}
+		age, _ := strconv.ParseInt(header.Get("Age"), 10, 64)
+		return now.Add(time.Duration(seconds-age) * time.Second)
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			return t
+		}
+		return now
+	}
+	return now
+}
+
+// storable 判断响应是否可以缓存：200、没有 no-store/private/Vary、未过期或者带有 ETag/Last-Modified 可以重新校验。
+// 缓存只按 url 区分，由所有调用者共享，因此带有 Authorization 的请求只有响应声明了 public 或 s-maxage 时才缓存，
+// 参见 RFC 7234 3.2 节
+func storable(req *http.Request, resp *http.Response, expires, now time.Time) bool {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Vary") != "" {
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if _, ok := cc["private"]; ok {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" {
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		if !public && !sMaxAge {
+			return false
+		}
+	}
+	return expires.After(now) || resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// cacheable 判断请求是否可以使用缓存
+func cacheable(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return false
+	}
+	_, noStore := parseCacheControl(req.Header)["no-store"]
+	return !noStore
+}
+
+// mustRevalidate 请求要求忽略未过期的缓存
+func mustRevalidate(req *http.Request) bool {
+	cc := parseCacheControl(req.Header)
+	_, noCache := cc["no-cache"]
+	return noCache || cc["max-age"] == "0"
+}
+
+// CacheTransport 使用 Cache 的 http.RoundTripper，缓存过期后通过 If-None-Match/If-Modified-Since 重新校验
+type CacheTransport struct {
+	Cache     *Cache
+	Transport http.RoundTripper // 实际发送请求的 Transport，nil 时使用 http.DefaultTransport
+}
+
+func (t *CacheTransport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
+
+// RoundTrip 实现 http.RoundTripper 接口
+func (t *CacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !cacheable(req) {
+		return t.transport().RoundTrip(req)
+	}
+	url := req.URL.String()
+	entry := t.Cache.get(url)
+	if entry != nil && !mustRevalidate(req) && time.Now().Before(entry.Expires) {
+		if resp, err := t.Cache.open(entry, req, CacheHit); err == nil {
+			return resp, nil
+		}
+		t.Cache.remove(url)
+		entry = nil
+	}
+
+	// 调用者自己设置了条件请求时不附加缓存的校验值，服务端的 304 原样返回给调用者
+	conditional := req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+	outReq := req
+	if entry != nil && !conditional {
+		outReq = req.Clone(req.Context())
+		for k, v := range entry.validators() {
+			outReq.Header[k] = v
+		}
+	}
+	resp, err := t.transport().RoundTrip(outReq)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if resp.StatusCode == http.StatusNotModified && entry != nil && !conditional {
+		return t.revalidated(entry, req, resp, now)
+	}
+	expires := expiresAt(resp.Header, now)
+	if !storable(req, resp, expires, now) || resp.ContentLength > t.Cache.maxSize {
+		if entry != nil && resp.StatusCode != http.StatusNotModified {
+			t.Cache.remove(url)
+		}
+		return resp, nil
+	}
+	return t.store(url, resp, expires)
+}
+
+// revalidated 服务端返回 304 时更新缓存的响应头及过期时间，返回缓存的内容
+func (t *CacheTransport) revalidated(entry *cacheEntry, req *http.Request, resp *http.Response, now time.Time) (*http.Response, error) {
+	resp.Body.Close()
+	updated := *entry
+	updated.Header = entry.Header.Clone()
+	for _, k := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified"} {
+		if v := resp.Header.Values(k); len(v) > 0 {
+			updated.Header[k] = v
+		}
+	}
+	updated.Expires = expiresAt(updated.Header, now)
+	if err := t.Cache.writeMeta(&updated); err != nil {
+		logs.Log.Errorf("update cache meta of %v fail, err:%v", entry.URL, err)
+	} else {
+		t.Cache.add(&updated)
+	}
+	return t.Cache.open(&updated, req, CacheRevalidated)
+}
+
+// store 边读边写入缓存，读取完整后才会加入缓存
+func (t *CacheTransport) store(url string, resp *http.Response, expires time.Time) (*http.Response, error) {
+	entry := &cacheEntry{URL: url, Header: resp.Header.Clone(), Expires: expires, key: hash.Md5String(url)}
+	file, err := ioutil.TempFile(t.Cache.dir, entry.key+".*.tmp")
+	if err != nil {
+		logs.Log.Errorf("create cache file of %v fail, err:%v", url, err)
+		return resp, nil
+	}
+	resp.Body = &cacheBody{ReadCloser: resp.Body, cache: t.Cache, entry: entry, file: file}
+	return resp, nil
+}
+
+// cacheBody 读取响应的同时写入临时文件，读到 EOF 后加入缓存，提前关闭时丢弃
+type cacheBody struct {
+	io.ReadCloser
+	cache *Cache
+	entry *cacheEntry
+	file  *os.File // 写入失败或者已经处理后为 nil
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.file != nil {
+		b.entry.Size += int64(n)
+		if _, werr := b.file.Write(p[:n]); werr != nil || b.entry.Size > b.cache.maxSize {
+			b.abort()
+		}
+	}
+	if err == io.EOF && b.file != nil {
+		b.commit()
+	}
+	return n, err
+}
+
+func (b *cacheBody) Close() error {
+	if b.file != nil {
+		b.abort()
+	}
+	return b.ReadCloser.Close()
+}
+
+func (b *cacheBody) abort() {
+	b.file.Close()
+	os.Remove(b.file.Name())
+	b.file = nil
+}
+
+func (b *cacheBody) commit() {
+	tmpPath := b.file.Name()
+	err := b.file.Close()
+	b.file = nil
+	if err == nil {
+		err = os.Rename(tmpPath, b.cache.path(b.entry.key, cacheBodyExt))
+	}
+	if err == nil {
+		err = b.cache.writeMeta(b.entry)
+	}
+	if err != nil {
+		logs.Log.Errorf("save cache of %v fail, err:%v", b.entry.URL, err)
+		os.Remove(tmpPath)
+		b.cache.remove(b.entry.URL)
+		return
+	}
+	b.cache.add(b.entry)
+}
@@ -0,0 +1,238 @@
+package httputil
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_expiresAt(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Time
+	}{
+		{"none", http.Header{}, now},
+		{"max_age", http.Header{"Cache-Control": {"public, max-age=60"}}, now.Add(time.Minute)},
+		{"max_age_with_age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, now.Add(40 * time.Second)},
+		{"no_cache", http.Header{"Cache-Control": {"no-cache, max-age=60"}}, now},
+		{"invalid_max_age", http.Header{"Cache-Control": {"max-age=abc"}}, now},
+		{"expires", http.Header{"Expires": {"Sat, 01 Jan 2022 01:00:00 GMT"}}, now.Add(time.Hour)},
+		{"invalid_expires", http.Header{"Expires": {"0"}}, now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, tt.want.Equal(expiresAt(tt.header, now)), "expiresAt() = %v, want %v", expiresAt(tt.header, now), tt.want)
+		})
+	}
+}
+
+// cacheServer 缓存测试服务，记录每个路径返回 200 的次数
+type cacheServer struct {
+	*httptest.Server
+	hits map[string]*int32
+}
+
+func newCacheServer() *cacheServer {
+	s := &cacheServer{hits: make(map[string]*int32)}
+	mux := http.NewServeMux()
+	handle := func(pattern string, handler func(w http.ResponseWriter, r *http.Request) bool) {
+		s.hits[pattern] = new(int32)
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if handler(w, r) {
+				atomic.AddInt32(s.hits[pattern], 1)
+			}
+		})
+	}
+	handle("/fresh/", func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(strings.Repeat(path.Base(r.URL.Path), 100)))
+		return true
+	})
+	handle("/etag", func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return false
+		}
+		w.Write([]byte("etag"))
+		return true
+	})
+	handle("/last_modified", func(w http.ResponseWriter, r *http.Request) bool {
+		http.ServeContent(w, r, "a.txt", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), strings.NewReader("last_modified"))
+		return r.Header.Get("If-Modified-Since") == ""
+	})
+	handle("/no_store", func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("no_store"))
+		return true
+	})
+	handle("/private", func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		w.Write([]byte("private"))
+		return true
+	})
+	handle("/auth/", func(w http.ResponseWriter, r *http.Request) bool {
+		if path.Base(r.URL.Path) == "public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+		return true
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *cacheServer) get(t *testing.T, client *Client, p string) (string, string) {
+	return s.getWithHeader(t, client, p, nil)
+}
+
+func (s *cacheServer) getWithHeader(t *testing.T, client *Client, p string, header http.Header) (string, string) {
+	resp, _, err := client.TryCountGetRespRedirect(context.Background(), http.MethodGet, s.URL+p, header, nil)
+	if !assert.Nil(t, err) {
+		return "", ""
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return string(data), resp.Header.Get(CacheHeader)
+}
+
+func TestCacheTransport(t *testing.T) {
+	server := newCacheServer()
+	defer server.Close()
+	dir := path.Join(os.TempDir(), uuid.New().String())
+	defer os.RemoveAll(dir)
+	cache, err := NewCache(dir, 1<<20)
+	if !assert.Nil(t, err) {
+		return
+	}
+	client := NewClient(WithCache(cache), WithRetry(1, 0))
+
+	tests := []struct {
+		name      string
+		path      string
+		want      string
+		wantCache []string // 依次请求两次的 X-Cache
+		wantHits  int32
+	}{
+		{"fresh", "/fresh/a", strings.Repeat("a", 100), []string{"", CacheHit}, 1},
+		{"etag", "/etag", "etag", []string{"", CacheRevalidated}, 1},
+		{"last_modified", "/last_modified", "last_modified", []string{"", CacheRevalidated}, 1},
+		{"no_store", "/no_store", "no_store", []string{"", ""}, 2},
+		{"private", "/private", "private", []string{"", ""}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, wantCache := range tt.wantCache {
+				got, gotCache := server.get(t, client, tt.path)
+				assert.Equal(t, tt.want, got)
+				assert.Equal(t, wantCache, gotCache)
+			}
+			pattern := tt.path
+			if strings.HasPrefix(pattern, "/fresh/") {
+				pattern = "/fresh/"
+			}
+			assert.Equal(t, tt.wantHits, atomic.LoadInt32(server.hits[pattern]))
+		})
+	}
+
+	// 重新加载磁盘中的缓存
+	cache, err = NewCache(dir, 1<<20)
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(100+len("etag")+len("last_modified")), cache.Size())
+		got, gotCache := server.get(t, NewClient(WithCache(cache)), "/fresh/a")
+		assert.Equal(t, strings.Repeat("a", 100), got)
+		assert.Equal(t, CacheHit, gotCache)
+	}
+}
+
+func TestCacheTransport_authorization(t *testing.T) {
+	server := newCacheServer()
+	defer server.Close()
+	dir := path.Join(os.TempDir(), uuid.New().String())
+	defer os.RemoveAll(dir)
+	cache, err := NewCache(dir, 1<<20)
+	if !assert.Nil(t, err) {
+		return
+	}
+	client := NewClient(WithCache(cache), WithRetry(1, 0))
+	alice := http.Header{}
+	alice.Set("Authorization", "alice")
+	bob := http.Header{}
+	bob.Set("Authorization", "bob")
+
+	// 带有 Authorization 的请求，响应没有声明 public 时不缓存，其他用户不会拿到 alice 的响应
+	got, gotCache := server.getWithHeader(t, client, "/auth/a", alice)
+	assert.Equal(t, "alice", got)
+	assert.Equal(t, "", gotCache)
+	got, gotCache = server.getWithHeader(t, client, "/auth/a", bob)
+	assert.Equal(t, "bob", got)
+	assert.Equal(t, "", gotCache)
+	assert.Nil(t, cache.get(server.URL+"/auth/a"))
+
+	// 声明了 public 的响应可以共享
+	server.getWithHeader(t, client, "/auth/public", alice)
+	got, gotCache = server.getWithHeader(t, client, "/auth/public", bob)
+	assert.Equal(t, "alice", got)
+	assert.Equal(t, CacheHit, gotCache)
+	assert.Equal(t, int32(3), atomic.LoadInt32(server.hits["/auth/"]))
+}
+
+func TestCacheEvict(t *testing.T) {
+	server := newCacheServer()
+	defer server.Close()
+	dir := path.Join(os.TempDir(), uuid.New().String())
+	defer os.RemoveAll(dir)
+	cache, err := NewCache(dir, 250)
+	if !assert.Nil(t, err) {
+		return
+	}
+	client := NewClient(WithCache(cache), WithRetry(1, 0))
+
+	for _, name := range []string{"a", "b", "a", "c"} {
+		server.get(t, client, "/fresh/"+name)
+	}
+	// b 最久未使用，被淘汰
+	assert.Equal(t, int64(200), cache.Size())
+	assert.Equal(t, int32(3), atomic.LoadInt32(server.hits["/fresh/"]))
+	for _, name := range []string{"a", "c", "b"} {
+		server.get(t, client, "/fresh/"+name)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(server.hits["/fresh/"]))
+
+	// 没有读取完整的响应不会被缓存
+	resp, _, err := client.TryCountGetRespRedirect(context.Background(), http.MethodGet, server.URL+"/fresh/d", nil, nil)
+	if assert.Nil(t, err) {
+		resp.Body.Read(make([]byte, 10))
+		resp.Body.Close()
+	}
+	assert.Nil(t, cache.get(server.URL+"/fresh/d"))
+
+	// Range 请求不使用缓存
+	header := http.Header{}
+	header.Set("Range", "bytes=0-1")
+	resp, _, err = client.TryCountGetRespRedirect(context.Background(), http.MethodGet, server.URL+"/fresh/a", header, nil)
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, "", resp.Header.Get(CacheHeader))
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 4, len(files), fmt.Sprintf("%v", files))
+}
@@ -0,0 +1,113 @@
+package httputil
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// checkpointExt 断点续传记录文件的后缀，记录文件与目标文件放在同一目录
+const checkpointExt = ".checkpoint"
+
+// ErrRemoteChanged 断点续传时远端文件发生了变化(ETag/Last-Modified/大小不一致)
+var ErrRemoteChanged = errors.New("remote file changed")
+
+// checkpointPart 单个分片的下载状态
+type checkpointPart struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  bool  `json:"done"`
+}
+
+// checkpoint 断点续传记录，每完成一个分片就落盘一次
+type checkpoint struct {
+	URL          string           `json:"url"`
+	FileSize     int64            `json:"file_size"`
+	ETag         string           `json:"etag,omitempty"`
+	LastModified string           `json:"last_modified,omitempty"`
+	Parts        []checkpointPart `json:"parts"`
+
+	path   string
+	mu     sync.Mutex
+	closed bool
+}
+
+// getCheckpointPath 获取 filePath 对应的断点续传记录文件路径
+func getCheckpointPath(filePath string) string {
+	return filePath + checkpointExt
+}
+
+// newCheckpoint 根据远端文件信息构造新的断点续传记录
+func newCheckpoint(filePath, url string, info *rangeInfo, partSize int64) *checkpoint {
+	cp := &checkpoint{
+		URL:          url,
+		FileSize:     info.fileSize,
+		ETag:         info.etag,
+		LastModified: info.lastModified,
+		path:         getCheckpointPath(filePath),
+	}
+	for _, r := range splitRange(info.fileSize, partSize) {
+		cp.Parts = append(cp.Parts, checkpointPart{Start: r.start, End: r.end})
+	}
+	return cp
+}
+
+// loadCheckpoint 读取 filePath 对应的断点续传记录，记录或目标文件不存在时返回 nil
+func loadCheckpoint(filePath string) *checkpoint {
+	cpPath := getCheckpointPath(filePath)
+	data, err := ioutil.ReadFile(cpPath)
+	if err != nil {
+		return nil
+	}
+	if _, err = os.Stat(filePath); err != nil {
+		return nil
+	}
+	cp := &checkpoint{path: cpPath}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil
+	}
+	return cp
+}
+
+// sameRemote 判断记录中的远端文件与 info 是否一致
+func (cp *checkpoint) sameRemote(info *rangeInfo) bool {
+	return cp.FileSize == info.fileSize && cp.ETag == info.etag && cp.LastModified == info.lastModified
+}
+
+// save 写入临时文件后 rename，避免进程中断导致记录文件损坏
+func (cp *checkpoint) save() error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmpPath := cp.path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, cp.path)
+}
+
+// complete 标记第 index 个分片下载完成并落盘
+func (cp *checkpoint) complete(index int) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.closed {
+		return nil
+	}
+	cp.Parts[index].Done = true
+	return cp.save()
+}
+
+// close 停止记录，之后完成的分片不再落盘
+func (cp *checkpoint) close() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.closed = true
+}
+
+// remove 删除记录文件
+func (cp *checkpoint) remove() {
+	os.Remove(cp.path)
+}
@@ -0,0 +1,47 @@
+package httputil
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	filePath := path.Join(os.TempDir(), uuid.New().String())
+	defer os.Remove(filePath)
+	info := &rangeInfo{fileSize: 25, etag: `"abc"`}
+
+	// 目标文件不存在时忽略记录
+	cp := newCheckpoint(filePath, "http://test.com/a.mp4", info, 10)
+	assert.Equal(t, 3, len(cp.Parts))
+	assert.Nil(t, cp.complete(1))
+	defer cp.remove()
+	assert.Nil(t, loadCheckpoint(filePath))
+
+	if err := ioutil.WriteFile(filePath, nil, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	loaded := loadCheckpoint(filePath)
+	if assert.NotNil(t, loaded) {
+		assert.Equal(t, cp.Parts, loaded.Parts)
+		assert.True(t, loaded.Parts[1].Done)
+		assert.True(t, loaded.sameRemote(info))
+		assert.False(t, loaded.sameRemote(&rangeInfo{fileSize: 25, etag: `"def"`}))
+		assert.False(t, loaded.sameRemote(&rangeInfo{fileSize: 26, etag: `"abc"`}))
+	}
+
+	// close 之后不再落盘
+	cp.close()
+	assert.Nil(t, cp.complete(2))
+	loaded = loadCheckpoint(filePath)
+	if assert.NotNil(t, loaded) {
+		assert.False(t, loaded.Parts[2].Done)
+	}
+
+	cp.remove()
+	assert.Nil(t, loadCheckpoint(filePath))
+}
@@ -0,0 +1,157 @@
+package httputil
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrChecksumMismatch 下载内容的摘要与期望值不一致
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// 支持的摘要算法
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+)
+
+// Checksum 期望的摘要
+type Checksum struct {
+	Algorithm string // ChecksumMD5/ChecksumSHA1/ChecksumSHA256，不区分大小写，也支持 Digest 头中的 "SHA-256" 等写法
+	Value     string // 摘要值，hex 或 base64 编码
+}
+
+func (c *Checksum) String() string {
+	return c.Algorithm + ":" + c.Value
+}
+
+// newHash 根据算法名构造 hash.Hash
+func newHash(algorithm string) (hash.Hash, error) {
+	switch strings.ReplaceAll(strings.ToLower(algorithm), "-", "") {
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumSHA1, "sha":
+		return sha1.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %v", algorithm)
+}
+
+// decodeDigest 解码 hex 或 base64 编码的摘要值
+func decodeDigest(value string, size int) ([]byte, error) {
+	if len(value) == hex.EncodedLen(size) {
+		if data, err := hex.DecodeString(value); err == nil {
+			return data, nil
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(data) != size {
+		return nil, fmt.Errorf("invalid digest value: %v", value)
+	}
+	return data, nil
+}
+
+// verifier 计算内容的摘要并和期望值比较
+type verifier struct {
+	hash.Hash
+	checksum *Checksum
+	want     []byte
+}
+
+func newVerifier(checksum *Checksum) (*verifier, error) {
+	h, err := newHash(checksum.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	want, err := decodeDigest(checksum.Value, h.Size())
+	if err != nil {
+		return nil, err
+	}
+	return &verifier{Hash: h, checksum: checksum, want: want}, nil
+}
+
+// verify 比较摘要，不一致时返回 ErrChecksumMismatch
+func (v *verifier) verify() error {
+	if got := v.Sum(nil); !bytes.Equal(got, v.want) {
+		return fmt.Errorf("%w, %v want %x but got %x", ErrChecksumMismatch, v.checksum.Algorithm, v.want, got)
+	}
+	return nil
+}
+
+// digestPriority 多个 Digest 时优先使用更安全的算法
+var digestPriority = []string{"sha-256", "sha", "md5"}
+
+// checksumFromHeader 从响应头的 Digest(RFC 3230) 或 Content-MD5 获取完整内容的摘要，没有时返回 nil。
+// partial 为 true 表示 206 响应，Content-MD5 只是分片的摘要，不能使用
+func checksumFromHeader(header http.Header, partial bool) *Checksum {
+	digests := make(map[string]string)
+	for _, value := range header.Values("Digest") {
+		for _, item := range strings.Split(value, ",") {
+			name, digest, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if ok {
+				digests[strings.ToLower(name)] = digest
+			}
+		}
+	}
+	for _, name := range digestPriority {
+		if digest, ok := digests[name]; ok {
+			return &Checksum{Algorithm: name, Value: digest}
+		}
+	}
+	if contentMD5 := header.Get("Content-MD5"); contentMD5 != "" && !partial {
+		return &Checksum{Algorithm: ChecksumMD5, Value: contentMD5}
+	}
+	return nil
+}
+
+// rangeVerifier 分片下载时按文件顺序计算摘要，分片可以乱序完成，
+// 前面的分片都完成后从刚写入的文件中读取(通常还在 page cache 中)，不需要下载完成后再完整读一遍文件
+type rangeVerifier struct {
+	*verifier
+	mu    sync.Mutex
+	file  io.ReaderAt
+	parts []byteRange
+	done  []bool
+	next  int // 下一个需要计算摘要的分片
+}
+
+func newRangeVerifier(v *verifier, file io.ReaderAt, parts []byteRange) *rangeVerifier {
+	return &rangeVerifier{verifier: v, file: file, parts: parts, done: make([]bool, len(parts))}
+}
+
+// complete 标记分片完成，并计算所有已连续完成的分片的摘要
+func (r *rangeVerifier) complete(index int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done[index] = true
+	for r.next < len(r.parts) && r.done[r.next] {
+		part := r.parts[r.next]
+		if _, err := io.Copy(r.Hash, io.NewSectionReader(r.file, part.start, part.size())); err != nil {
+			return fmt.Errorf("hash range %d-%d fail: %w", part.start, part.end, err)
+		}
+		r.next++
+	}
+	return nil
+}
+
+// verify 所有分片完成后比较摘要
+func (r *rangeVerifier) verify() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next != len(r.parts) {
+		return fmt.Errorf("%w, %v/%v parts hashed", ErrChecksumMismatch, r.next, len(r.parts))
+	}
+	return r.verifier.verify()
+}
@@ -0,0 +1,91 @@
+package httputil
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newVerifier(t *testing.T) {
+	content := []byte("hello world")
+	sum := sha256.Sum256(content)
+	md5Sum := md5.Sum(content)
+	tests := []struct {
+		name     string
+		checksum *Checksum
+		wantErr  bool
+		wantOK   bool
+	}{
+		{"sha256_hex", &Checksum{ChecksumSHA256, hex.EncodeToString(sum[:])}, false, true},
+		{"sha256_base64", &Checksum{"SHA-256", base64.StdEncoding.EncodeToString(sum[:])}, false, true},
+		{"md5_upper_hex", &Checksum{"MD5", strings.ToUpper(hex.EncodeToString(md5Sum[:]))}, false, true},
+		{"sha1_mismatch", &Checksum{ChecksumSHA1, strings.Repeat("0", 40)}, false, false},
+		{"unsupported", &Checksum{"crc32", "00000000"}, true, false},
+		{"invalid_value", &Checksum{ChecksumMD5, "not a digest"}, true, false},
+		{"wrong_size", &Checksum{ChecksumSHA256, hex.EncodeToString(md5Sum[:])}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := newVerifier(tt.checksum)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("[%v] newVerifier() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			v.Write(content)
+			err = v.verify()
+			assert.Equal(t, tt.wantOK, err == nil)
+			if !tt.wantOK {
+				assert.True(t, errors.Is(err, ErrChecksumMismatch))
+			}
+		})
+	}
+}
+
+func Test_checksumFromHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  http.Header
+		partial bool
+		want    *Checksum
+	}{
+		{"none", http.Header{}, false, nil},
+		{"content_md5", http.Header{"Content-Md5": {"abc="}}, false, &Checksum{ChecksumMD5, "abc="}},
+		{"content_md5_partial", http.Header{"Content-Md5": {"abc="}}, true, nil},
+		{"digest", http.Header{"Digest": {"MD5=abc=, SHA-256=def="}}, true, &Checksum{"sha-256", "def="}},
+		{"digest_over_content_md5", http.Header{"Digest": {"sha=ghi="}, "Content-Md5": {"abc="}}, false, &Checksum{"sha", "ghi="}},
+		{"unknown_digest", http.Header{"Digest": {"crc32c=abc"}}, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, checksumFromHeader(tt.header, tt.partial))
+		})
+	}
+}
+
+func TestRangeVerifier(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+	sum := sha256.Sum256(content)
+	v, _ := newVerifier(&Checksum{ChecksumSHA256, hex.EncodeToString(sum[:])})
+	rv := newRangeVerifier(v, bytes.NewReader(content), splitRange(int64(len(content)), 30))
+
+	// 乱序完成
+	for _, index := range []int{2, 0, 3} {
+		assert.Nil(t, rv.complete(index))
+	}
+	assert.Equal(t, 1, rv.next)
+	assert.True(t, errors.Is(rv.verify(), ErrChecksumMismatch))
+	assert.Nil(t, rv.complete(1))
+	assert.Equal(t, 4, rv.next)
+	assert.Nil(t, rv.verify())
+}
@@ -0,0 +1,108 @@
+package httputil
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-utils/src/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClient(t *testing.T) {
+	client := NewClient()
+	assert.Equal(t, defaultMaxRedirects, client.maxRedirects)
+	assert.Equal(t, NewExponentialBackoff(defaultRetryCount), client.retryPolicy)
+	assert.Equal(t, GetDefaultHeader(), client.header)
+
+	proxyURL, _ := url.Parse("http://127.0.0.1:8080")
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	client = NewClient(
+		WithMaxRedirects(1),
+		WithRetry(5, time.Millisecond),
+		WithTimeout(time.Second),
+		WithResponseHeaderTimeout(2*time.Second),
+		WithProxy(http.ProxyURL(proxyURL)),
+		WithTLSConfig(tlsConfig),
+	)
+	assert.Equal(t, 1, client.maxRedirects)
+	assert.Equal(t, NewConstantBackoff(5, time.Millisecond), client.retryPolicy)
+	assert.Equal(t, time.Second, client.httpClient.Timeout)
+	transport, ok := client.httpClient.Transport.(*http.Transport)
+	if assert.True(t, ok) {
+		assert.Equal(t, tlsConfig, transport.TLSClientConfig)
+		assert.Equal(t, 2*time.Second, transport.ResponseHeaderTimeout)
+		gotProxy, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "http", Host: "test.com"}})
+		assert.Nil(t, err)
+		assert.Equal(t, proxyURL, gotProxy)
+	}
+}
+
+func Test_defaultClient(t *testing.T) {
+	retryCount := config.ServerCnf.ControlConfig.HTTPRequestRetryCounts
+	defer func() {
+		config.ServerCnf.ControlConfig.HTTPRequestRetryCounts = retryCount
+	}()
+
+	config.ServerCnf.ControlConfig.HTTPRequestRetryCounts = 5
+	assert.Equal(t, NewExponentialBackoff(5), defaultClient().retryPolicy)
+	// 未配置时只请求一次
+	config.ServerCnf.ControlConfig.HTTPRequestRetryCounts = 0
+	assert.Equal(t, NewExponentialBackoff(1), defaultClient().retryPolicy)
+}
+
+func TestWithTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var gotHost string
+	client := NewClient(WithTransport(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		gotHost = req.URL.Host
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: req}, nil
+	})))
+	resp, err := client.GetResp(context.Background(), http.MethodGet, server.URL, nil, nil)
+	if assert.Nil(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+	assert.Equal(t, server.Listener.Addr().String(), gotHost)
+}
+
+func TestClient_mergeHeader(t *testing.T) {
+	defaultHeader := http.Header{}
+	defaultHeader.Set("Accept", "*/*")
+	defaultHeader.Set("User-Agent", "go-utils")
+	header := http.Header{}
+	header.Set("User-Agent", "custom")
+	want := http.Header{}
+	want.Set("Accept", "*/*")
+	want.Set("User-Agent", "custom")
+
+	tests := []struct {
+		name   string
+		client *Client
+		header http.Header
+		want   http.Header
+	}{
+		{"no_default", &Client{}, header, header},
+		{"nil_header", &Client{header: defaultHeader}, nil, defaultHeader},
+		{"merge", &Client{header: defaultHeader}, header, want},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.client.mergeHeader(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// 不修改调用者的 header
+	assert.Equal(t, "", header.Get("Accept"))
+}
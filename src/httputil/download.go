@@ -0,0 +1,431 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"go-utils/src/bandwidth"
+	"go-utils/src/logs"
+	"go-utils/src/pool"
+	"go-utils/src/progress"
+)
+
+// 分片下载的默认参数
+const (
+	defaultPartSize       int64 = 4 << 20 // 默认分片大小 4M
+	defaultConcurrencyNum       = 4       // 默认并发下载协程数
+)
+
+// ErrNotSupportRange 服务端不支持按字节范围下载
+var ErrNotSupportRange = errors.New("not support Ranges")
+
+// DownloadOptions 下载参数，零值字段使用默认配置
+type DownloadOptions struct {
+	ConcurrencyNum int   // 并发下载的协程数
+	PartSize       int64 // 分片大小，单位字节
+	// Resume 断点续传，分片下载时在 filePath+".checkpoint" 记录已完成的分片，
+	// 失败时保留已下载内容，再次下载时只拉取缺失的分片
+	Resume bool
+	// Progress 下载进度回调，续传时已下载的分片计入 Done 但不计入速率
+	Progress *progress.Observer
+	// Checksum 期望的摘要，下载时同步计算，不一致时删除本地文件并返回 ErrChecksumMismatch
+	Checksum *Checksum
+	// VerifyServerDigest 未设置 Checksum 时使用服务端返回的 Digest 或 Content-MD5 校验，服务端没有返回时不校验
+	VerifyServerDigest bool
+	// MinSpeed 有多个镜像时下载速度的下限，单位字节/秒，一个 SpeedWindow 内的平均速度低于下限时切换到下一个镜像，
+	// 0 表示不检测。同时限速时 MinSpeed 需要小于限速，否则所有镜像都会被认为过慢
+	MinSpeed int64
+	// SpeedWindow 计算下载速度的时间窗口，默认 5s
+	SpeedWindow time.Duration
+	// BytesPerSecond 本次下载的限速，单位字节/秒，分片下载时所有分片共享，0 表示不限速
+	BytesPerSecond int64
+	// Bandwidth 多个下载共享的限速，与 BytesPerSecond 及 WithBandwidth 同时生效
+	Bandwidth *bandwidth.Limiter
+}
+
+func (o *DownloadOptions) getConcurrencyNum() int {
+	if o == nil || o.ConcurrencyNum <= 0 {
+		return defaultConcurrencyNum
+	}
+	return o.ConcurrencyNum
+}
+
+func (o *DownloadOptions) getPartSize() int64 {
+	if o == nil || o.PartSize <= 0 {
+		return defaultPartSize
+	}
+	return o.PartSize
+}
+
+func (o *DownloadOptions) resumable() bool {
+	return o != nil && o.Resume
+}
+
+func (o *DownloadOptions) observer() *progress.Observer {
+	if o == nil {
+		return nil
+	}
+	return o.Progress
+}
+
+// limiters 返回本次下载需要遵守的所有限速，每次调用都会构造新的单次下载限速
+func (o *DownloadOptions) limiters(c *Client) []*bandwidth.Limiter {
+	limiters := []*bandwidth.Limiter{c.bandwidth}
+	if o != nil {
+		limiters = append(limiters, bandwidth.NewLimiter(o.BytesPerSecond), o.Bandwidth)
+	}
+	return limiters
+}
+
+// verifier 根据 Checksum 或服务端的摘要构造 verifier，不需要校验时返回 nil
+func (o *DownloadOptions) verifier(serverDigest *Checksum) (*verifier, error) {
+	if o == nil {
+		return nil, nil
+	}
+	checksum := o.Checksum
+	if checksum == nil && o.VerifyServerDigest {
+		checksum = serverDigest
+	}
+	if checksum == nil {
+		return nil, nil
+	}
+	return newVerifier(checksum)
+}
+
+// byteRange 闭区间 [start, end] 的字节范围
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func (r byteRange) size() int64 {
+	return r.end - r.start + 1
+}
+
+// splitRange 将 [0, fileSize) 按 partSize 切分为多个字节范围
+func splitRange(fileSize, partSize int64) []byteRange {
+	if fileSize <= 0 || partSize <= 0 {
+		return nil
+	}
+	ranges := make([]byteRange, 0, (fileSize+partSize-1)/partSize)
+	for start := int64(0); start < fileSize; start += partSize {
+		end := start + partSize - 1
+		if end >= fileSize {
+			end = fileSize - 1
+		}
+		ranges = append(ranges, byteRange{start, end})
+	}
+	return ranges
+}
+
+// offsetWriter 从指定偏移开始顺序写入 io.WriterAt，记录写入错误以便和读取错误区分
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+	err error
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	o.err = err
+	return n, err
+}
+
+// rangeTask 单个分片的下载任务，从 mirrors[first] 开始下载，失败时从已写入的位置切换到下一个镜像继续
+type rangeTask struct {
+	pool.TaskBase
+	ctx      context.Context
+	client   *Client
+	mirrors  []*rangeInfo // 内容一致的镜像，使用 redirectURL 下载，validator 作为 If-Range
+	first    int
+	file     *os.File
+	fileSize int64
+	speed    *speedLimit // 速度下限，nil 表示不检测
+	limiters []*bandwidth.Limiter
+	done     func() error // 分片下载完成的回调
+	tracker  *progress.Tracker
+	written  int64 // 已写入的字节数
+	byteRange
+}
+
+func (t *rangeTask) process() error {
+	var err error
+	for i := range t.mirrors {
+		m := t.mirrors[(t.first+i)%len(t.mirrors)]
+		if err = t.fetch(m); err == nil {
+			break
+		}
+		if !canFailover(t.ctx, err) || i == len(t.mirrors)-1 {
+			return err
+		}
+		logs.Log.Wainf("range %d-%d fail on mirror %v, try next mirror: %v", t.start, t.end, m.redirectURL, err)
+	}
+	if t.done != nil {
+		return t.done()
+	}
+	return nil
+}
+
+// fetch 从镜像 m 下载分片中还未写入的部分
+func (t *rangeTask) fetch(m *rangeInfo) error {
+	ctx, cancel := context.WithCancel(t.ctx)
+	defer cancel()
+	watch := t.speed.watch(cancel)
+	defer watch.stop()
+	err := t.fetchWith(ctx, m, watch)
+	if watch.isSlow() {
+		return fmt.Errorf("%w, range %d-%d of %s: %v", ErrSlowMirror, t.start, t.end, m.redirectURL, err)
+	}
+	return err
+}
+
+func (t *rangeTask) fetchWith(ctx context.Context, m *rangeInfo, watch *speedWatch) error {
+	start := t.start + t.written
+	header := make(http.Header)
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, t.end))
+	if validator := m.validator(); validator != "" {
+		header.Set("If-Range", validator)
+	}
+	resp, _, err := t.client.TryCountGetRespRedirect(ctx, http.MethodGet, m.redirectURL, header, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && m.validator() != "" {
+		return fmt.Errorf("%w, range %d-%d of %s", ErrRemoteChanged, start, t.end, m.redirectURL)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range %d-%d of %s, unexpected code=%v", start, t.end, m.redirectURL, resp.StatusCode)
+	}
+	if size, ok := parseContentRangeSize(resp.Header.Get("Content-Range")); ok && size != t.fileSize {
+		return fmt.Errorf("%w, range %d-%d of %s, file size %v != %v", ErrMirrorMismatch, start, t.end, m.redirectURL, size, t.fileSize)
+	}
+
+	size := t.end - start + 1
+	w := &offsetWriter{w: t.file, off: start}
+	body := bandwidth.NewReader(ctx, watch.reader(io.LimitReader(resp.Body, size)), t.limiters...)
+	n, err := io.Copy(w, t.tracker.Reader(body))
+	t.written += n
+	if w.err != nil {
+		return fmt.Errorf("range %d-%d of %s, %w: %v", start, t.end, m.redirectURL, errWriteFile, w.err)
+	}
+	if err != nil {
+		return fmt.Errorf("range %d-%d of %s, read fail: %w", start, t.end, m.redirectURL, err)
+	}
+	if n != size {
+		return fmt.Errorf("range %d-%d of %s, want %v bytes but got %v", start, t.end, m.redirectURL, size, n)
+	}
+	return nil
+}
+
+// downloadRangeHandle pool.Executor 的任务处理函数
+func downloadRangeHandle(data interface{}) {
+	var err error
+	defer func() {
+		data.(pool.ProcessTasker).SetResult(err)
+	}()
+
+	task, ok := data.(*rangeTask)
+	if !ok {
+		err = fmt.Errorf("data is must rangeTask, data=%v", data)
+		logs.Log.Error(err)
+		return
+	}
+
+	select {
+	case <-task.ctx.Done():
+		err = task.ctx.Err()
+	default:
+		err = task.process()
+	}
+}
+
+// openDownloadFile 打开下载目标文件并预分配空间，续传时保留已有内容，校验摘要时需要读取已写入的内容
+func openDownloadFile(filePath string, fileSize int64, resume bool) (*os.File, error) {
+	flag := os.O_CREATE | os.O_RDWR
+	if !resume {
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(filePath, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = file.Truncate(fileSize); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// downloadRanges 预分配本地文件，按分片并发下载写入，分片轮流分配给各个镜像，opts.Resume 时跳过记录中已完成的分片。
+// mirrors[0] 为基准镜像，其余镜像的文件大小及 ETag 与其一致
+func (c *Client) downloadRanges(ctx context.Context, filePath string, mirrors []*rangeInfo, opts *DownloadOptions) error {
+	info := mirrors[0]
+	url := info.redirectURL
+	var cp *checkpoint
+	if opts.resumable() {
+		cp = loadCheckpoint(filePath)
+		if cp != nil && !cp.sameRemote(info) {
+			return fmt.Errorf("%w, %v => %v", ErrRemoteChanged, url, filePath)
+		}
+	}
+	resume := cp != nil
+	if !resume {
+		cp = newCheckpoint(filePath, url, info, opts.getPartSize())
+	}
+
+	v, err := opts.verifier(info.digest)
+	if err != nil {
+		return err
+	}
+	file, err := openDownloadFile(filePath, info.fileSize, resume)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var rv *rangeVerifier
+	if v != nil {
+		parts := make([]byteRange, len(cp.Parts))
+		for i, part := range cp.Parts {
+			parts[i] = byteRange{part.Start, part.End}
+		}
+		rv = newRangeVerifier(v, file, parts)
+	}
+
+	// 提前返回时通知仍在执行的分片退出
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tracker := progress.NewTracker(info.fileSize, opts.observer())
+	limiters := opts.limiters(c)
+	tasks := make(chan interface{}, len(cp.Parts))
+	for i, part := range cp.Parts {
+		if part.Done {
+			tracker.Skip(byteRange{part.Start, part.End}.size())
+			if rv != nil {
+				if err = rv.complete(i); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		task := &rangeTask{
+			ctx:       ctx,
+			client:    c,
+			mirrors:   mirrors,
+			first:     i % len(mirrors),
+			file:      file,
+			fileSize:  info.fileSize,
+			speed:     opts.speedLimit(len(mirrors)),
+			limiters:  limiters,
+			tracker:   tracker,
+			byteRange: byteRange{part.Start, part.End},
+		}
+		index := i
+		task.done = func() error {
+			if rv != nil {
+				if err := rv.complete(index); err != nil {
+					return err
+				}
+			}
+			if opts.resumable() {
+				return cp.complete(index)
+			}
+			return nil
+		}
+		tasks <- task
+	}
+	close(tasks)
+	if resume {
+		logs.Log.Infof("resume %v => %v, %v/%v parts left", url, filePath, len(tasks), len(cp.Parts))
+	}
+
+	if len(tasks) > 0 {
+		err = pool.Executor(ctx, tasks, downloadRangeHandle, opts.getConcurrencyNum())
+	}
+	cp.close()
+	if err != nil {
+		return err
+	}
+	if rv != nil {
+		if err = rv.verify(); err != nil {
+			return fmt.Errorf("verify %v => %v fail: %w", url, filePath, err)
+		}
+	}
+	cp.remove()
+	tracker.Finish()
+	return nil
+}
+
+// downloadStream 单连接顺序下载，用于服务端不支持 Range 的情况，speed 不为 nil 时速度过慢会返回 ErrSlowMirror
+func (c *Client) downloadStream(ctx context.Context, url, filePath string, opts *DownloadOptions, speed *speedLimit) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watch := speed.watch(cancel)
+	defer watch.stop()
+	n, err := c.downloadStreamWith(ctx, url, filePath, opts, watch)
+	if watch.isSlow() {
+		return n, fmt.Errorf("%w, %s: %v", ErrSlowMirror, url, err)
+	}
+	return n, err
+}
+
+func (c *Client) downloadStreamWith(ctx context.Context, url, filePath string, opts *DownloadOptions, watch *speedWatch) (int64, error) {
+	resp, _, err := c.TryCountGetRespRedirect(ctx, http.MethodGet, url, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	v, err := opts.verifier(checksumFromHeader(resp.Header, false))
+	if err != nil {
+		return 0, err
+	}
+	body := bandwidth.NewReader(ctx, watch.reader(resp.Body), opts.limiters(c)...)
+	if v != nil {
+		body = io.TeeReader(body, v)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	tracker := progress.NewTracker(resp.ContentLength, opts.observer())
+	w := &offsetWriter{w: file}
+	n, err := io.Copy(w, tracker.Reader(body))
+	if w.err != nil {
+		return n, fmt.Errorf("%v => %v, %w: %v", url, filePath, errWriteFile, w.err)
+	}
+	if err != nil {
+		return n, err
+	}
+	if v != nil {
+		if err = v.verify(); err != nil {
+			return n, fmt.Errorf("verify %v => %v fail: %w", url, filePath, err)
+		}
+	}
+	tracker.Finish()
+	return n, nil
+}
+
+// Download 下载 url 到本地 filePath，返回文件大小。
+// 服务端支持 Range 时按 opts.PartSize 切片，并发下载写入预分配的文件；否则退化为单连接下载。
+// 下载失败时会删除本地不完整的文件，opts.Resume 时保留以便续传，但远端文件变化或摘要校验失败时仍会删除，
+// 并分别返回 ErrRemoteChanged、ErrChecksumMismatch。
+func (c *Client) Download(ctx context.Context, url, filePath string, opts *DownloadOptions) (int64, error) {
+	return c.DownloadMirrors(ctx, []string{url}, filePath, opts)
+}
+
+// Download 使用默认 Client 下载 url 到本地 filePath，参见 Client.Download
+func Download(ctx context.Context, url, filePath string, opts *DownloadOptions) (int64, error) {
+	return defaultClient().Download(ctx, url, filePath, opts)
+}
@@ -0,0 +1,365 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-utils/src/bandwidth"
+	"go-utils/src/fs"
+	"go-utils/src/httputil/httptestutil"
+	"go-utils/src/progress"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_splitRange(t *testing.T) {
+	type args struct {
+		fileSize int64
+		partSize int64
+	}
+	tests := []struct {
+		name string
+		args args
+		want []byteRange
+	}{
+		{"empty", args{0, 10}, nil},
+		{"invalid_part", args{10, 0}, nil},
+		{"one_part", args{5, 10}, []byteRange{{0, 4}}},
+		{"exact", args{20, 10}, []byteRange{{0, 9}, {10, 19}}},
+		{"remainder", args{25, 10}, []byteRange{{0, 9}, {10, 19}, {20, 24}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitRange(tt.args.fileSize, tt.args.partSize); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newDownloadServer 构造测试服务，/range 支持 Range 请求，/stream 不支持且不返回 Content-Length，其他路径返回 404
+func newDownloadServer(content []byte) *httptestutil.Server {
+	server := httptestutil.NewServer()
+	server.Handle("/range", httptestutil.Response{Range: true, Body: content})
+	server.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	})
+	return server
+}
+
+func TestClient_Download(t *testing.T) {
+	client := NewClient(WithRetry(2, 0))
+	content := []byte(strings.Repeat("0123456789", 1000))
+	server := newDownloadServer(content)
+	defer server.Close()
+
+	ctx := context.Background()
+
+	type args struct {
+		ctx  context.Context
+		url  string
+		opts *DownloadOptions
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    int64
+		wantErr bool
+	}{
+		{"normal_range", args{ctx, server.URL + "/range", &DownloadOptions{ConcurrencyNum: 3, PartSize: 1024}}, 10000, false},
+		{"normal_range_default", args{ctx, server.URL + "/range", nil}, 10000, false},
+		{"normal_stream", args{ctx, server.URL + "/stream", nil}, 10000, false},
+		{"not_found", args{ctx, server.URL + "/not_found", nil}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := path.Join(os.TempDir(), uuid.New().String())
+			defer os.Remove(filePath)
+
+			got, err := client.Download(tt.args.ctx, tt.args.url, filePath, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("[%v] Download() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("[%v] Download() = %v, want %v", tt.name, got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			data, err := ioutil.ReadFile(filePath)
+			if err != nil || !bytes.Equal(data, content) {
+				t.Errorf("[%v] Download() content mismatch, err = %v", tt.name, err)
+			}
+		})
+	}
+}
+
+func TestClient_DownloadProgress(t *testing.T) {
+	client := NewClient(WithRetry(2, 0))
+	content := []byte(strings.Repeat("0123456789", 1000))
+	server := newDownloadServer(content)
+	defer server.Close()
+
+	// /stream 未返回 Content-Length，总大小未知
+	for name, total := range map[string]int64{"range": int64(len(content)), "stream": -1} {
+		name, total := name, total
+		t.Run(name, func(t *testing.T) {
+			filePath := path.Join(os.TempDir(), uuid.New().String())
+			defer os.Remove(filePath)
+
+			var count int32
+			var last atomic.Value
+			opts := &DownloadOptions{ConcurrencyNum: 3, PartSize: 1024, Progress: &progress.Observer{
+				Interval: -1,
+				Func: func(p progress.Progress) {
+					atomic.AddInt32(&count, 1)
+					last.Store(p)
+				},
+			}}
+			_, err := client.Download(context.Background(), server.URL+"/"+name, filePath, opts)
+			assert.Nil(t, err)
+			assert.Greater(t, atomic.LoadInt32(&count), int32(1))
+			p := last.Load().(progress.Progress)
+			assert.Equal(t, int64(len(content)), p.Done)
+			assert.Equal(t, total, p.Total)
+			assert.Equal(t, time.Duration(0), p.ETA)
+		})
+	}
+}
+
+func TestClient_DownloadChecksum(t *testing.T) {
+	client := NewClient(WithRetry(1, 0))
+	content := []byte(strings.Repeat("0123456789", 1000))
+	sum := sha256.Sum256(content)
+	md5Sum := md5.Sum(content)
+	sha256Hex := hex.EncodeToString(sum[:])
+
+	server := httptestutil.NewServer()
+	defer server.Close()
+	server.Handle("/range", httptestutil.Response{
+		Range:  true,
+		Header: http.Header{"Digest": {"SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])}},
+		Body:   content,
+	})
+	server.Handle("/stream", httptestutil.Response{
+		Header: http.Header{"Content-MD5": {base64.StdEncoding.EncodeToString(md5Sum[:])}},
+		Body:   content,
+	})
+	server.Handle("/bad_digest", httptestutil.Response{
+		Header: http.Header{"Content-MD5": {base64.StdEncoding.EncodeToString(make([]byte, md5.Size))}},
+		Body:   content,
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		opts     *DownloadOptions
+		mismatch bool
+	}{
+		{"range_ok", "/range", &DownloadOptions{PartSize: 1000, Checksum: &Checksum{ChecksumSHA256, sha256Hex}}, false},
+		{"range_mismatch", "/range", &DownloadOptions{PartSize: 1000, Checksum: &Checksum{ChecksumMD5, hex.EncodeToString(make([]byte, md5.Size))}}, true},
+		{"range_mismatch_resume", "/range", &DownloadOptions{PartSize: 1000, Resume: true, Checksum: &Checksum{ChecksumMD5, hex.EncodeToString(make([]byte, md5.Size))}}, true},
+		{"range_server_digest", "/range", &DownloadOptions{PartSize: 1000, VerifyServerDigest: true}, false},
+		{"stream_ok", "/stream", &DownloadOptions{Checksum: &Checksum{ChecksumSHA256, sha256Hex}}, false},
+		{"stream_server_digest", "/stream", &DownloadOptions{VerifyServerDigest: true}, false},
+		{"stream_server_digest_mismatch", "/bad_digest", &DownloadOptions{VerifyServerDigest: true}, true},
+		{"stream_ignore_server_digest", "/bad_digest", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := path.Join(os.TempDir(), uuid.New().String())
+			defer os.Remove(filePath)
+			defer os.Remove(getCheckpointPath(filePath))
+
+			_, err := client.Download(context.Background(), server.URL+tt.path, filePath, tt.opts)
+			if tt.mismatch {
+				assert.True(t, errors.Is(err, ErrChecksumMismatch), "err = %v", err)
+				assert.False(t, fs.IsFile(filePath))
+				assert.False(t, fs.IsFile(getCheckpointPath(filePath)))
+				return
+			}
+			assert.Nil(t, err)
+			data, _ := ioutil.ReadFile(filePath)
+			assert.Equal(t, content, data)
+		})
+	}
+}
+
+// resumeFile 断点续传测试的下载路径
+const resumeFile = "/resume.mp4"
+
+// handleFlaky 设置 resumeFile 的响应：第一个请求为 bytes=0-1 的探测，第 dropAt 个分片请求只返回一半数据后断开连接，
+// dropAt 为 0 时不断开
+func handleFlaky(server *httptestutil.Server, content []byte, etag string, dropAt int) {
+	good := httptestutil.Response{Range: true, Header: http.Header{"ETag": {etag}}, Body: content}
+	responses := []httptestutil.Response{good}
+	if dropAt > 0 {
+		drop := good
+		drop.DropAfter = 500
+		responses = append(append(responses, httptestutil.Times(dropAt-1, good)...), drop, good)
+	}
+	server.Handle(resumeFile, responses...)
+}
+
+// partRequests 分片请求数，不包含 bytes=0-1 的探测请求
+func partRequests(server *httptestutil.Server) int {
+	n := 0
+	for _, r := range server.Requests(resumeFile) {
+		if r.Header.Get("Range") != "bytes=0-1" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDownloadResume(t *testing.T) {
+	client := NewClient(WithRetry(1, 0))
+	content := []byte(strings.Repeat("0123456789", 1000))
+	server := httptestutil.NewServer()
+	defer server.Close()
+	handleFlaky(server, content, `"v1"`, 4)
+
+	ctx := context.Background()
+	filePath := path.Join(os.TempDir(), uuid.New().String())
+	defer os.Remove(filePath)
+	defer os.Remove(getCheckpointPath(filePath))
+	opts := &DownloadOptions{ConcurrencyNum: 1, PartSize: 1000, Resume: true}
+
+	// 第一次下载中途断开，保留已下载内容和记录文件
+	_, err := client.Download(ctx, server.URLFor(resumeFile), filePath, opts)
+	assert.NotNil(t, err)
+	cp := loadCheckpoint(filePath)
+	if assert.NotNil(t, cp) {
+		assert.Equal(t, `"v1"`, cp.ETag)
+		assert.Equal(t, 10, len(cp.Parts))
+		assert.True(t, cp.Parts[0].Done)
+	}
+
+	// 续传只请求缺失的分片，已下载的分片计入进度
+	handleFlaky(server, content, `"v1"`, 0)
+	server.Reset()
+	var first, last progress.Progress
+	opts.Progress = &progress.Observer{Interval: -1, Func: func(p progress.Progress) {
+		if first.Done == 0 {
+			first = p
+		}
+		last = p
+	}}
+	fileSize, err := client.Download(ctx, server.URLFor(resumeFile), filePath, opts)
+	assert.GreaterOrEqual(t, first.Done, int64(1000))
+	assert.Equal(t, int64(len(content)), last.Done)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)), fileSize)
+	assert.LessOrEqual(t, partRequests(server), 7)
+	data, _ := ioutil.ReadFile(filePath)
+	assert.Equal(t, content, data)
+	assert.Nil(t, loadCheckpoint(filePath))
+}
+
+func TestDownloadResumeProbeFail(t *testing.T) {
+	client := NewClient(WithRetry(1, 0))
+	content := []byte(strings.Repeat("0123456789", 1000))
+	server := httptestutil.NewServer()
+	defer server.Close()
+	handleFlaky(server, content, `"v1"`, 4)
+
+	ctx := context.Background()
+	filePath := path.Join(os.TempDir(), uuid.New().String())
+	defer os.Remove(filePath)
+	defer os.Remove(getCheckpointPath(filePath))
+	opts := &DownloadOptions{ConcurrencyNum: 1, PartSize: 1000, Resume: true}
+
+	_, err := client.Download(ctx, server.URLFor(resumeFile), filePath, opts)
+	assert.NotNil(t, err)
+	assert.NotNil(t, loadCheckpoint(filePath))
+
+	// 探测失败时保留已下载内容和记录文件
+	server.Handle(resumeFile, httptestutil.Response{Status: http.StatusServiceUnavailable})
+	_, err = client.Download(ctx, server.URLFor(resumeFile), filePath, opts)
+	assert.NotNil(t, err)
+	assert.True(t, fs.IsFile(filePath))
+	cp := loadCheckpoint(filePath)
+	if assert.NotNil(t, cp) {
+		assert.True(t, cp.Parts[0].Done)
+	}
+
+	// 再次下载从记录文件续传
+	handleFlaky(server, content, `"v1"`, 0)
+	server.Reset()
+	fileSize, err := client.Download(ctx, server.URLFor(resumeFile), filePath, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)), fileSize)
+	assert.LessOrEqual(t, partRequests(server), 7)
+	data, _ := ioutil.ReadFile(filePath)
+	assert.Equal(t, content, data)
+}
+
+func TestDownloadResumeRemoteChanged(t *testing.T) {
+	client := NewClient(WithRetry(1, 0))
+	content := []byte(strings.Repeat("0123456789", 1000))
+	server := httptestutil.NewServer()
+	defer server.Close()
+	handleFlaky(server, content, `"v1"`, 2)
+
+	ctx := context.Background()
+	filePath := path.Join(os.TempDir(), uuid.New().String())
+	defer os.Remove(filePath)
+	defer os.Remove(getCheckpointPath(filePath))
+	opts := &DownloadOptions{ConcurrencyNum: 1, PartSize: 1000, Resume: true}
+
+	_, err := client.Download(ctx, server.URLFor(resumeFile), filePath, opts)
+	assert.NotNil(t, err)
+	assert.NotNil(t, loadCheckpoint(filePath))
+
+	// 远端文件变化后续传失败，并清理本地文件
+	handleFlaky(server, content, `"v2"`, 0)
+	_, err = client.Download(ctx, server.URLFor(resumeFile), filePath, opts)
+	assert.True(t, errors.Is(err, ErrRemoteChanged))
+	assert.False(t, fs.IsFile(filePath))
+	assert.False(t, fs.IsFile(getCheckpointPath(filePath)))
+}
+
+func TestClient_DownloadBandwidth(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 12<<10))
+	server := newDownloadServer(content)
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		client  *Client
+		path    string
+		opts    *DownloadOptions
+		minTime time.Duration
+	}{
+		{"range_per_download", NewClient(), "/range", &DownloadOptions{PartSize: 16 << 10, BytesPerSecond: 100 << 10}, 150 * time.Millisecond},
+		{"range_shared", NewClient(), "/range", &DownloadOptions{PartSize: 16 << 10, Bandwidth: bandwidth.NewLimiter(100 << 10)}, 150 * time.Millisecond},
+		{"stream_client", NewClient(WithBandwidth(bandwidth.NewLimiter(100 << 10))), "/stream", nil, 150 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := path.Join(os.TempDir(), uuid.New().String())
+			defer os.Remove(filePath)
+			start := time.Now()
+			got, err := tt.client.Download(context.Background(), server.URL+tt.path, filePath, tt.opts)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(len(content)), got)
+			assert.GreaterOrEqual(t, time.Since(start), tt.minTime)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package httputil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBodySize HTTPError 保留的响应内容的最大字节数
+const maxErrorBodySize = 4 << 10
+
+// HTTPError 请求失败的详细信息，可以通过 errors.As 获取；网络错误等底层错误可以通过 errors.Is/errors.As 继续判断
+type HTTPError struct {
+	Method     string      // 最后一次请求的方法
+	URL        string      // 最后一次请求的 url，有重定向时为重定向后的地址
+	Redirects  []string    // 重定向经过的 url，按请求顺序排列，不包含 URL
+	StatusCode int         // 响应状态码，没有收到响应时为 0
+	Header     http.Header // 响应头，没有收到响应时为 nil
+	Body       []byte      // 响应内容，最多保留 maxErrorBodySize 字节
+	Truncated  bool        // Body 是否被截断
+	Err        error       // 底层错误，比如网络错误、ctx 取消
+}
+
+// newHTTPError 根据跟随重定向的结果及错误构造 HTTPError，会读取 r.Body 但不会关闭
+func newHTTPError(r *Response, err error) *HTTPError {
+	e := &HTTPError{Method: r.Method, URL: r.URL, Redirects: r.Redirects, Err: err}
+	if resp := r.Response; resp != nil {
+		e.StatusCode = resp.StatusCode
+		e.Header = resp.Header
+		if resp.Body != nil {
+			body, readErr := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize+1))
+			if readErr == nil && len(body) > maxErrorBodySize {
+				body, e.Truncated = body[:maxErrorBodySize], true
+			}
+			e.Body = body
+		}
+	}
+	return e
+}
+
+func (e *HTTPError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", e.Method, e.URL)
+	if len(e.Redirects) > 0 {
+		fmt.Fprintf(&b, " (redirected from %s)", e.Redirects[0])
+	}
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, ": status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	if len(e.Body) > 0 {
+		fmt.Fprintf(&b, ", body: %s", e.Body)
+		if e.Truncated {
+			b.WriteString("...")
+		}
+	}
+	return b.String()
+}
+
+// Unwrap 返回底层错误
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode 返回 err 中 HTTPError 的状态码，不是 HTTPError 或没有收到响应时返回 0
+func StatusCode(err error) int {
+	var e *HTTPError
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound 判断 err 是否为 404 或 410 响应
+func IsNotFound(err error) bool {
+	code := StatusCode(err)
+	return code == http.StatusNotFound || code == http.StatusGone
+}
+
+// IsRetryable 判断 err 是否可以重试，规则同 IsRetryableResponse
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var e *HTTPError
+	if errors.As(err, &e) && e.StatusCode != 0 {
+		return isRetryableStatus(e.StatusCode)
+	}
+	return IsRetryableResponse(nil, err)
+}
@@ -0,0 +1,130 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newHTTPError(t *testing.T) {
+	newResp := func(code int, body string) *http.Response {
+		return &http.Response{
+			StatusCode: code,
+			Header:     http.Header{"X-Test": []string{"1"}},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}
+	}
+	longBody := strings.Repeat("x", maxErrorBodySize+10)
+
+	newResult := func(resp *http.Response, urls ...string) *Response {
+		return &Response{Response: resp, Method: http.MethodGet, URL: urls[len(urls)-1], Redirects: urls[:len(urls)-1]}
+	}
+
+	type args struct {
+		r   *Response
+		err error
+	}
+	tests := []struct {
+		name          string
+		args          args
+		wantURL       string
+		wantRedirects []string
+		wantCode      int
+		wantBody      string
+		wantTruncated bool
+		wantMsg       string
+	}{
+		{"status", args{newResult(newResp(404, "not found"), "http://a/1"), nil},
+			"http://a/1", []string{}, 404, "not found", false, "GET http://a/1: status 404 Not Found, body: not found"},
+		{"redirected", args{newResult(newResp(500, ""), "http://a/1", "http://b/2"), nil},
+			"http://b/2", []string{"http://a/1"}, 500, "", false,
+			"GET http://b/2 (redirected from http://a/1): status 500 Internal Server Error"},
+		{"truncated", args{newResult(newResp(502, longBody), "http://a/1"), nil},
+			"http://a/1", []string{}, 502, longBody[:maxErrorBodySize], true, ""},
+		{"network", args{newResult(nil, "http://a/1"), io.EOF},
+			"http://a/1", []string{}, 0, "", false, "GET http://a/1: EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newHTTPError(tt.args.r, tt.args.err)
+			assert.Equal(t, tt.wantURL, got.URL)
+			assert.Equal(t, tt.wantRedirects, got.Redirects)
+			assert.Equal(t, tt.wantCode, got.StatusCode)
+			assert.Equal(t, tt.wantBody, string(got.Body))
+			assert.Equal(t, tt.wantTruncated, got.Truncated)
+			assert.Equal(t, tt.args.err, got.Unwrap())
+			if tt.wantMsg != "" {
+				assert.Equal(t, tt.wantMsg, got.Error())
+			}
+			if tt.args.r.Response != nil {
+				assert.Equal(t, "1", got.Header.Get("X-Test"))
+			}
+		})
+	}
+}
+
+func TestHTTPErrorClassify(t *testing.T) {
+	wrap := func(code int, err error) error {
+		return fmt.Errorf("wrapped: %w", &HTTPError{Method: http.MethodGet, URL: "http://a/1", StatusCode: code, Err: err})
+	}
+	tests := []struct {
+		name          string
+		err           error
+		wantCode      int
+		wantNotFound  bool
+		wantRetryable bool
+	}{
+		{"nil", nil, 0, false, false},
+		{"not_found", wrap(404, nil), 404, true, false},
+		{"gone", wrap(410, nil), 410, true, false},
+		{"forbidden", wrap(403, nil), 403, false, false},
+		{"too_many", wrap(429, nil), 429, false, true},
+		{"unavailable", wrap(503, nil), 503, false, true},
+		{"not_implemented", wrap(501, nil), 501, false, false},
+		{"network", wrap(0, io.ErrUnexpectedEOF), 0, false, true},
+		{"canceled", wrap(0, context.Canceled), 0, false, false},
+		{"plain_error", io.EOF, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantCode, StatusCode(tt.err))
+			assert.Equal(t, tt.wantNotFound, IsNotFound(tt.err))
+			assert.Equal(t, tt.wantRetryable, IsRetryable(tt.err))
+		})
+	}
+}
+
+func TestClient_HTTPError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://"+r.Host+"/missing", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient(WithRetry(1, 0))
+	_, _, err := client.TryCountGetRespRedirect(context.Background(), http.MethodGet, server.URL+"/start", nil, nil)
+	var httpErr *HTTPError
+	if assert.True(t, errors.As(err, &httpErr)) {
+		assert.Equal(t, http.MethodGet, httpErr.Method)
+		assert.Equal(t, server.URL+"/missing", httpErr.URL)
+		assert.Equal(t, []string{server.URL + "/start"}, httpErr.Redirects)
+		assert.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+		assert.Equal(t, "404 page not found\n", string(httpErr.Body))
+	}
+	assert.True(t, IsNotFound(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = client.GetRespRedirect(ctx, http.MethodGet, server.URL+"/start", nil, nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, IsRetryable(err))
+}
@@ -0,0 +1,282 @@
+package httputil
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// 文件名相关的限制
+const (
+	maxFileNameLen = 200 // 文件名的最大字节数
+	maxExtLen      = 16  // 截断文件名时保留的后缀的最大字节数
+	sniffLen       = 512 // 推断后缀时预读的字节数，同 http.DetectContentType
+)
+
+// contentTypeExts 常见 Content-Type 对应的后缀，优先于系统的 mime 配置
+var contentTypeExts = map[string]string{
+	"video/mp4":                     ".mp4",
+	"video/webm":                    ".webm",
+	"video/x-flv":                   ".flv",
+	"video/quicktime":               ".mov",
+	"video/x-matroska":              ".mkv",
+	"video/mp2t":                    ".ts",
+	"audio/mpeg":                    ".mp3",
+	"audio/mp3":                     ".mp3",
+	"audio/mp4":                     ".m4a",
+	"audio/aac":                     ".aac",
+	"audio/wav":                     ".wav",
+	"audio/x-wav":                   ".wav",
+	"audio/wave":                    ".wav",
+	"audio/flac":                    ".flac",
+	"audio/ogg":                     ".ogg",
+	"image/jpeg":                    ".jpg",
+	"image/png":                     ".png",
+	"image/gif":                     ".gif",
+	"image/webp":                    ".webp",
+	"application/vnd.apple.mpegurl": ".m3u8",
+	"application/x-mpegurl":         ".m3u8",
+	"application/json":              ".json",
+	"text/plain":                    ".txt",
+	"text/html":                     ".html",
+}
+
+// magicExts 音视频文件头对应的后缀，http.DetectContentType 无法识别的格式
+var magicExts = []struct {
+	offset int
+	magic  []byte
+	ext    string
+}{
+	{0, []byte("ID3"), ".mp3"},
+	{0, []byte("fLaC"), ".flac"},
+	{0, []byte("FLV"), ".flv"},
+	{0, []byte("#EXTM3U"), ".m3u8"},
+	{0, []byte("\x1a\x45\xdf\xa3"), ".webm"},
+	{4, []byte("ftypM4A"), ".m4a"},
+	{4, []byte("ftypqt"), ".mov"},
+	{4, []byte("ftyp"), ".mp4"},
+}
+
+// GetHTTPFileName 获取默认文件名 ext:默认后缀比如".mp4"
+// 文件名依次取自 Content-Disposition(支持 RFC 5987 的 filename*)、url 路径的最后一段(会做百分号解码)，
+// 并去掉路径及不安全的字符；没有后缀时依次根据 Content-Type、响应内容推断，都无法推断时使用 defaultExt。
+// 根据响应内容推断时会预读 resp.Body 的前 512 字节，resp.Body 会被替换，调用者仍然可以读到完整的内容
+func GetHTTPFileName(uri string, resp *http.Response, defaultExt string, pre string) string {
+	if pre == "" {
+		pre = uuid.New().String()
+	}
+	pre += "_"
+
+	var name string
+	if resp != nil {
+		name = sanitizeFileName(fileNameFromDisposition(resp.Header.Get("Content-Disposition")))
+	}
+	if name == "" {
+		name = sanitizeFileName(fileNameFromURL(uri))
+	}
+	if path.Ext(name) == "" {
+		name += extFromResponse(resp, defaultExt)
+	}
+	return pre + name
+}
+
+// fileNameFromDisposition 解析 Content-Disposition 中的文件名，filename* 优先，兼容不规范的写法
+func fileNameFromDisposition(disposition string) string {
+	if disposition == "" {
+		return ""
+	}
+	// mime.ParseMediaType 会忽略非 UTF-8 的 filename*，有 filename* 时手动解析
+	if !strings.Contains(strings.ToLower(disposition), "filename*") {
+		if _, params, err := mime.ParseMediaType(disposition); err == nil {
+			if name, ok := params["filename"]; ok {
+				return name
+			}
+		}
+	}
+
+	var name, extName string
+	for _, param := range splitParams(disposition) {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "filename*":
+			extName = decodeExtValue(strings.TrimSpace(value))
+		case "filename":
+			name = unquote(strings.TrimSpace(value))
+		}
+	}
+	if extName != "" {
+		return extName
+	}
+	return name
+}
+
+// splitParams 按引号外的 ; 切分参数
+func splitParams(s string) []string {
+	var params []string
+	inQuote, escaped, start := false, false, 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && inQuote:
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+		case r == ';' && !inQuote:
+			params = append(params, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(params, s[start:])
+}
+
+// unquote 去掉 quoted-string 的引号及转义
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range s[1 : len(s)-1] {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// decodeExtValue 解码 RFC 5987 的 charset'language'value，支持 UTF-8 及 ISO-8859-1
+func decodeExtValue(s string) string {
+	parts := strings.SplitN(unquote(s), "'", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	value, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(parts[0]) {
+	case "utf-8":
+		if utf8.ValidString(value) {
+			return value
+		}
+	case "iso-8859-1":
+		runes := make([]rune, 0, len(value))
+		for i := 0; i < len(value); i++ {
+			runes = append(runes, rune(value[i]))
+		}
+		return string(runes)
+	}
+	return ""
+}
+
+// fileNameFromURL 取 url 路径的最后一段并做百分号解码
+func fileNameFromURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	escaped := u.EscapedPath()
+	name := escaped[strings.LastIndex(escaped, "/")+1:]
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+// sanitizeFileName 去掉路径，替换控制字符及 Windows 不允许的字符，去掉首尾的空格和点，并限制长度
+func sanitizeFileName(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Trim(name, " ./")
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) <= maxFileNameLen {
+		return name
+	}
+
+	ext := path.Ext(name)
+	if len(ext) > maxExtLen {
+		ext = ""
+	}
+	base := name[:maxFileNameLen-len(ext)]
+	for !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+	return base + ext
+}
+
+// extFromContentType 根据 Content-Type 推断后缀，无法推断时返回空
+func extFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		return ""
+	}
+	if ext, ok := contentTypeExts[mediaType]; ok {
+		return ext
+	}
+	exts, _ := mime.ExtensionsByType(mediaType)
+	if len(exts) == 0 {
+		return ""
+	}
+	sort.Strings(exts)
+	return exts[0]
+}
+
+// peekedBody 预读后的 resp.Body
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// sniffExt 预读响应内容推断后缀，206 响应不是文件开头，不推断
+func sniffExt(resp *http.Response) string {
+	if resp.Body == nil || resp.Body == http.NoBody || resp.StatusCode == http.StatusPartialContent {
+		return ""
+	}
+	reader := bufio.NewReaderSize(resp.Body, sniffLen)
+	head, _ := reader.Peek(sniffLen)
+	resp.Body = &peekedBody{Reader: reader, Closer: resp.Body}
+	if len(head) == 0 {
+		return ""
+	}
+	for _, m := range magicExts {
+		if len(head) >= m.offset+len(m.magic) && bytes.Equal(head[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.ext
+		}
+	}
+	return extFromContentType(http.DetectContentType(head))
+}
+
+// extFromResponse 依次根据 Content-Type、响应内容推断后缀，都无法推断时返回 defaultExt
+func extFromResponse(resp *http.Response, defaultExt string) string {
+	if resp == nil {
+		return defaultExt
+	}
+	if ext := extFromContentType(resp.Header.Get("Content-Type")); ext != "" {
+		return ext
+	}
+	if ext := sniffExt(resp); ext != "" {
+		return ext
+	}
+	return defaultExt
+}
@@ -0,0 +1,128 @@
+package httputil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetHTTPFileName(t *testing.T) {
+	newHeader := func(key, value string) http.Header {
+		header := http.Header{}
+		header.Set(key, value)
+		return header
+	}
+	type args struct {
+		uri        string
+		resp       *http.Response
+		defaultExt string
+		pre        string
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{"normal_uri_empty", args{"", &http.Response{}, ".mp4", "pre"}, "pre_.mp4"},
+		{"normal", args{"http://tencent.com/test.mp3", &http.Response{}, ".mp4", "pre"}, "pre_test.mp3"},
+		{"normal_no_ext", args{"http://tencent.com/test", &http.Response{}, ".mp4", "pre"}, "pre_test.mp4"},
+		{"normal_resp", args{"", &http.Response{Header: newHeader("Content-Disposition", "text/html; charset=utf-8; filename=hello.ts")}, ".mp4", "pre"}, "pre_hello.ts"},
+		{"nil_resp", args{"http://tencent.com/test", nil, ".mp4", "pre"}, "pre_test.mp4"},
+		{"trailing_slash", args{"http://tencent.com/dir/", &http.Response{}, ".mp4", "pre"}, "pre_.mp4"},
+		{"path_escaped", args{"http://tencent.com/a%2F..%2Fb%20c.mp3?x=1", &http.Response{}, ".mp4", "pre"}, "pre_b c.mp3"},
+		{"path_unicode", args{"http://tencent.com/%E4%BD%A0%E5%A5%BD.mp3", &http.Response{}, ".mp4", "pre"}, "pre_你好.mp3"},
+		{"disposition_traversal", args{"", &http.Response{Header: newHeader("Content-Disposition", `attachment; filename="../../etc/passwd"`)}, ".mp4", "pre"}, "pre_passwd.mp4"},
+		{"disposition_windows_path", args{"", &http.Response{Header: newHeader("Content-Disposition", `attachment; filename="C:\\tmp\\a.mp3"`)}, ".mp4", "pre"}, "pre_a.mp3"},
+		{"disposition_unquoted_traversal", args{"", &http.Response{Header: newHeader("Content-Disposition", "attachment; filename=../../x.mp3")}, ".mp4", "pre"}, "pre_x.mp3"},
+		{"disposition_utf8", args{"", &http.Response{Header: newHeader("Content-Disposition", `attachment; filename="a.mp3"; filename*=UTF-8''%E4%BD%A0%E5%A5%BD.mp3`)}, ".mp4", "pre"}, "pre_你好.mp3"},
+		{"disposition_latin1", args{"", &http.Response{Header: newHeader("Content-Disposition", `attachment; filename="a.mp3"; filename*=iso-8859-1'en'caf%E9.mp3`)}, ".mp4", "pre"}, "pre_café.mp3"},
+		{"disposition_hidden", args{"http://tencent.com/test.mp3", &http.Response{Header: newHeader("Content-Disposition", `attachment; filename=".."`)}, ".mp4", "pre"}, "pre_test.mp3"},
+		{"content_type", args{"http://tencent.com/test", &http.Response{Header: newHeader("Content-Type", "audio/mpeg")}, ".mp4", "pre"}, "pre_test.mp3"},
+		{"content_type_m3u8", args{"http://tencent.com/index", &http.Response{Header: newHeader("Content-Type", "application/vnd.apple.mpegurl; charset=utf-8")}, ".mp4", "pre"}, "pre_index.m3u8"},
+		{"content_type_octet_stream", args{"http://tencent.com/test", &http.Response{Header: newHeader("Content-Type", "application/octet-stream")}, ".mp4", "pre"}, "pre_test.mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetHTTPFileName(tt.args.uri, tt.args.resp, tt.args.defaultExt, tt.args.pre); got != tt.want {
+				t.Errorf("GetHTTPFileName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetHTTPFileName_sniff(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"mp3", http.StatusOK, "ID3" + strings.Repeat("a", 1000), "pre_test.mp3"},
+		{"png", http.StatusOK, "\x89PNG\r\n\x1a\n" + strings.Repeat("a", 100), "pre_test.png"},
+		{"html", http.StatusOK, "<html><body>hello</body></html>", "pre_test.html"},
+		{"unknown", http.StatusOK, "\x00\x01\x02\x03", "pre_test.mp4"},
+		{"partial", http.StatusPartialContent, "ID3" + strings.Repeat("a", 1000), "pre_test.mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(tt.body))}
+			assert.Equal(t, tt.want, GetHTTPFileName("http://tencent.com/test", resp, ".mp4", "pre"))
+			// 预读后仍然可以读到完整的内容
+			data, err := ioutil.ReadAll(resp.Body)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.body, string(data))
+			assert.Nil(t, resp.Body.Close())
+		})
+	}
+}
+
+func Test_sanitizeFileName(t *testing.T) {
+	long := strings.Repeat("你", 100) + ".mp4"
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"normal", "a.mp4", "a.mp4"},
+		{"dot", ".", ""},
+		{"dot_dot", "..", ""},
+		{"traversal", "../../a.mp4", "a.mp4"},
+		{"windows", `..\..\a.mp4`, "a.mp4"},
+		{"trim", " .a.mp4. ", "a.mp4"},
+		{"reserved", `a<b>c:d"e|f?g*h.mp4`, "a_b_c_d_e_f_g_h.mp4"},
+		{"control", "a\x00b\nc\x7f.mp4", "a_b_c_.mp4"},
+		{"invalid_utf8", "a\xffb.mp4", "a_b.mp4"},
+		{"long", long, strings.Repeat("你", 65) + ".mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeFileName(tt.in))
+		})
+	}
+}
+
+func Test_fileNameFromDisposition(t *testing.T) {
+	tests := []struct {
+		name        string
+		disposition string
+		want        string
+	}{
+		{"empty", "", ""},
+		{"no_filename", "inline", ""},
+		{"quoted", `attachment; filename="a b.mp4"`, "a b.mp4"},
+		{"escaped", `attachment; filename="a\"b.mp4"`, `a"b.mp4`},
+		{"unquoted_space", "attachment; filename=a b.mp4", "a b.mp4"},
+		{"semicolon_in_quote", `attachment; filename="a;b.mp4"; size=1`, "a;b.mp4"},
+		{"ext_value_preferred", `attachment; filename=a.mp4; filename*=UTF-8''b.mp4`, "b.mp4"},
+		{"ext_value_invalid", `attachment; filename=a.mp4; filename*=gbk''b.mp4`, "a.mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, fileNameFromDisposition(tt.disposition))
+		})
+	}
+}
@@ -4,23 +4,14 @@ package httputil
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"mime"
-	"mime/multipart"
 	"net/http"
-	"net/url"
-	"os"
-	"strconv"
-	"strings"
 	"time"
 
-	"errors"
-	"go-utils/src/config"
-	"errors"
-	
-	"github.com/google/uuid"
+	"go-utils/src/logs"
+	"go-utils/src/progress"
 )
 
 // GetDefaultHeader 获取默认构造的 header
@@ -34,12 +25,42 @@ func GetDefaultHeader() http.Header {
 	return defaultHeader
 }
 
+// requestBody 请求内容，每次发送(包括重试和重定向)都通过 open 重新获取 body，保证 body 可以重放
+type requestBody interface {
+	open() (io.Reader, error)
+	String() string // 打印日志使用
+}
+
+// sizedBody 能够预先确定大小的请求内容，open 返回的 body 无法由 http.NewRequest 获取大小时使用，
+// contentLength 小于 0 表示大小未知，以 chunked 方式发送
+type sizedBody interface {
+	contentLength() int64
+}
+
+// bytesBody 内存中的请求内容
+type bytesBody []byte
+
+func (b bytesBody) open() (io.Reader, error) {
+	return bytes.NewReader(b), nil
+}
+
+func (b bytesBody) String() string {
+	return string(b)
+}
+
+// closeBody 关闭 open 返回的 body，比如 io.Pipe 的读端
+func closeBody(body io.Reader) {
+	if closer, ok := body.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
 // getNewRequest NewRequest 简单封装
 func getNewRequest(
 	ctx context.Context,
 	method, url string,
 	header http.Header,
-	data *bytes.Buffer,
+	data io.Reader,
 ) (*http.Request, error) {
 	var req *http.Request
 	var err error
@@ -58,24 +79,43 @@ func getNewRequest(
 	return req, nil
 }
 
-// getResp NewRequest+Do 简单封装
-func getResp(
+// GetResp NewRequest+Do 简单封装，header 中未设置的字段使用 Client 的默认 header 填充
+func (c *Client) GetResp(
 	ctx context.Context,
 	method, url string,
 	header http.Header,
 	data []byte,
 ) (*http.Response, error) {
-	reqBody := bytes.NewBuffer(data)
-	req, err := getNewRequest(ctx, method, url, header, reqBody)
+	return c.getResp(ctx, method, url, header, bytesBody(data))
+}
+
+func (c *Client) getResp(
+	ctx context.Context,
+	method, url string,
+	header http.Header,
+	body requestBody,
+) (*http.Response, error) {
+	if err := c.limits.wait(ctx, url); err != nil {
+		return nil, err
+	}
+	reqBody, err := body.open()
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	req, err := getNewRequest(ctx, method, url, c.mergeHeader(header), reqBody)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Do fail: %v %+v %s => %+v", url, req.Header, string(data), err))
+		closeBody(reqBody)
+		return nil, err
+	}
+	if sized, ok := body.(sizedBody); ok && sized.contentLength() >= 0 {
+		req.ContentLength = sized.contentLength()
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Do fail: %v %+v %s => %w", url, req.Header, body, err)
 	}
 	if resp.StatusCode >= 400 {
-		logs.Log.Wainf("%+v %+v %s => %+v", url, req.Header, string(data), resp)
+		logs.Log.Wainf("%+v %+v %s => %+v", url, req.Header, body, resp)
 	}
 	return resp, nil
 }
@@ -89,184 +129,200 @@ func find(slice []int, val int) bool {
 	return false
 }
 
-// getRespRedirect NewRequest 简单封装，支持重定向
-func getRespRedirect(
+// GetRespRedirect NewRequest 简单封装，支持重定向，最多重定向 maxRedirects 次，失败时返回 *HTTPError
+func (c *Client) GetRespRedirect(
 	ctx context.Context,
 	method, url string,
 	header http.Header,
 	data []byte,
 ) (*http.Response, string, error) {
-	codes := []int{301, 302, 303, 307, 308}
-	var resp *http.Response
-	var err error
-	maxRedirectTimes := int(config.ServerCnf.ControlConfig.MaxRedirectCounts)
-	redirectURL := url
-	for i := 0; i < maxRedirectTimes; i++ {
-		resp, err = getResp(ctx, method, redirectURL, header, data)
-		if err != nil {
-			return nil, redirectURL, errorFromResponse(resp, err)
-		}
-		if find(codes, resp.StatusCode) {
-			redirectURL = resp.Header["Location"][0]
-			resp.Body.Close()
-		} else {
-			break
-		}
+	r, err := c.getRespRedirect(ctx, method, url, header, bytesBody(data))
+	if err != nil {
+		return nil, r.URL, newHTTPError(r, err)
 	}
-	if resp.StatusCode > 299 {
+	if r.StatusCode > 299 {
 		// the body was closed in redirect codes
-		respErr := errorFromResponse(resp, err)
-		if !find(codes, resp.StatusCode) {
-			resp.Body.Close()
-		}
-		return nil, redirectURL, respErr
+		respErr := newHTTPError(r, nil)
+		r.Body.Close()
+		return nil, r.URL, respErr
 	}
-	return resp, redirectURL, nil
+	return r.Response, r.URL, nil
 }
 
-func errorFromResponse(resp *http.Response, err error) error {
-	if resp == nil && err == nil {
-		return nil
-	}
-
-	var errMsg string
-
+// TryCountGetRespRedirect 按照 Client 的重试策略多次尝试 GetRespRedirect，等待重试期间响应 ctx 取消，
+// 失败时返回最后一次请求的 *HTTPError
+func (c *Client) TryCountGetRespRedirect(
+	ctx context.Context,
+	method, url string,
+	header http.Header,
+	data []byte,
+) (resp *http.Response, redirectURL string, err error) {
+	r, err := c.tryCountGetRespRedirect(ctx, method, url, header, bytesBody(data))
 	if err != nil {
-		if e, ok := err.(*errors.Error); ok {
-			return e
-		}
-		errMsg += fmt.Sprintf("%+v", err)
-	}
-
-	errCode := errors.RetResponseCodeErr
-	if resp != nil {
-		errCode = resp.StatusCode + errors.RetHTTPStatusCodeBegin
-		content, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			errMsg += fmt.Sprintf("%+v", resp)
-		} else {
-			errMsg += fmt.Sprintf("%+v", string(content))
-		}
+		return nil, "", err
 	}
+	return r.Response, r.URL, nil
+}
 
-	if len(errMsg) == 0 {
-		errMsg = "unknown error"
-	}
-	return errors.New(errCode, errMsg)
+// Do 同 TryCountGetRespRedirect，返回的 Response 中包含重定向经过的 url 及最终的请求方法
+func (c *Client) Do(
+	ctx context.Context,
+	method, url string,
+	header http.Header,
+	data []byte,
+) (*Response, error) {
+	return c.tryCountGetRespRedirect(ctx, method, url, header, bytesBody(data))
 }
 
-// GetHTTPFileName 获取默认文件名 ext:默认后缀比如".mp4"
-func GetHTTPFileName(uri string, resp *http.Response, defaultExt string, pre string) string {
-	if pre == "" {
-		pre = uuid.New().String()
-	}
-	pre += "_"
-	getDispos := resp.Header.Get("Content-Disposition")
-	if getDispos != "" {
-		_, params, err := mime.ParseMediaType(getDispos)
-		if err == nil {
-			if name, ok := params["filename"]; ok {
-				return pre + name
-			}
+func (c *Client) tryCountGetRespRedirect(
+	ctx context.Context,
+	method, url string,
+	header http.Header,
+	body requestBody,
+) (*Response, error) {
+	var r *Response
+	var err error
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		r, err = c.getRespRedirect(ctx, method, url, header, body)
+		if err == nil && r.StatusCode <= 299 { // ok
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wait, retry := c.retryPolicy.Retry(attempt, time.Since(start), r.Response, err)
+		if !retry {
+			break
 		}
-	}
 
-	u, err := url.Parse(uri)
-	if err == nil {
-		index := strings.LastIndex(u.Path, "/")
-		if index != -1 {
-			name := u.Path[index+1:]
-			return pre + name
+		code := 0
+		if r.Response != nil {
+			code = r.StatusCode
+			r.Body.Close()
+		}
+		logs.Log.Wainf("redirect: %v %+v %s retry count %d after %v => code=%v, %+v",
+			url, header, body, attempt, wait, code, err)
+		if err = sleepContext(ctx, wait); err != nil {
+			r.Response = nil
+			break
 		}
 	}
-
-	return pre + defaultExt
+	respErr := newHTTPError(r, err)
+	if r.Response != nil {
+		r.Body.Close()
+	}
+	return nil, respErr
 }
 
-// TryCountGetRespRedirect 多次尝试 GetRespRedirect
-func TryCountGetRespRedirect(
-	ctx context.Context,
-	method, url string,
-	header http.Header,
-	data []byte,
-) (resp *http.Response, redirectURL string, err error) {
-	count := int(config.ServerCnf.ControlConfig.HTTPRequestRetryCounts)
-	sleep := time.Second
-	for i := 0; i < count; i++ {
-		resp, redirectURL, err = getRespRedirect(ctx, method, url, header, data)
-		if err == nil { // ok
-			return
-		}
+// rangeInfo 通过 Range 请求探测到的远端文件信息
+type rangeInfo struct {
+	fileSize     int64
+	fileName     string
+	redirectURL  string
+	etag         string // 用于断点续传时校验远端文件是否变化
+	lastModified string
+	digest       *Checksum // 服务端返回的完整文件摘要
+}
 
-		time.Sleep(sleep)
-		logs.Log.Wainf("redirect: %v %+v %s retry count %d => %+v", url, header, string(data), i, err)
+// validator 返回 If-Range 使用的校验值，优先使用 ETag
+func (r *rangeInfo) validator() string {
+	if r.etag != "" {
+		return r.etag
 	}
-	return nil, "", errorFromResponse(resp, err)
+	return r.lastModified
 }
 
-// AcceptRange 判断 url 是否支持按照字节下载，通过 GET 方法判断
-func AcceptRange(ctx context.Context, url string) (fileSize int64, fileName, redirectURL string, err error) {
-	header := GetDefaultHeader()
+// getRangeInfo 通过 GET bytes=0-1 探测 url 是否支持按照字节下载，并获取文件大小等信息
+func (c *Client) getRangeInfo(ctx context.Context, url string) (info *rangeInfo, err error) {
+	header := make(http.Header)
 	header.Set("Range", "bytes=0-1")
-	var resp *http.Response
-	resp, redirectURL, err = TryCountGetRespRedirect(ctx, http.MethodGet, url, header, nil)
+	resp, redirectURL, err := c.TryCountGetRespRedirect(ctx, http.MethodGet, url, header, nil)
 	if err != nil {
-		return
+		return nil, err
+	}
+	defer resp.Body.Close()
+	info = &rangeInfo{
+		fileName:     GetHTTPFileName(url, resp, ".mp4", ""),
+		redirectURL:  redirectURL,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		digest:       checksumFromHeader(resp.Header, resp.StatusCode == http.StatusPartialContent),
 	}
-	fileName = GetHTTPFileName(url, resp, ".mp4", "")
 	// 检查是否支持 断点续传
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Accept-Ranges
 	if resp.Header.Get("Accept-Ranges") != "bytes" && resp.StatusCode != 206 {
-		err = errors.New(fmt.Sprintf("not support Ranges, code=%v", resp.StatusCode))
-		return
+		return info, fmt.Errorf("%w, code=%v", ErrNotSupportRange, resp.StatusCode)
 	}
-	defer resp.Body.Close()
 
 	// bytes 3600-5000/5000
 	contentRange := resp.Header.Get("Content-Range")
-	index := strings.LastIndex(contentRange, "/")
-	fileSizeText := contentRange[index+1:]
-	fileSize, err = strconv.ParseInt(fileSizeText, 10, 64)
-	return
+	var ok bool
+	if info.fileSize, ok = parseContentRangeSize(contentRange); !ok {
+		return info, fmt.Errorf("invalid Content-Range %q of %v", contentRange, url)
+	}
+	return info, nil
 }
 
-// PostFile 发送文件
-func PostFile(ctx context.Context, fieldname, file, url string, header http.Header) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	writer := multipart.NewWriter(buf)
-	formFile, err := writer.CreateFormFile(fieldname, file)
-	if err != nil {
-		errWarp := errors.New(fmt.Sprintf("Create form file failed: %v, %v, %+v", file, url, err))
-		logs.Log.Error(errWarp)
-		return nil, errWarp
+// AcceptRange 判断 url 是否支持按照字节下载，通过 GET 方法判断
+func (c *Client) AcceptRange(ctx context.Context, url string) (fileSize int64, fileName, redirectURL string, err error) {
+	info, err := c.getRangeInfo(ctx, url)
+	if info != nil {
+		fileSize, fileName, redirectURL = info.fileSize, info.fileName, info.redirectURL
 	}
+	return
+}
 
-	srcFile, err := os.Open(file)
-	if err != nil {
-		errWarp := errors.New(fmt.Sprintf("Open source file failed: %v, %v, %+v", file, url, err))
-		logs.Log.Error(errWarp)
-		return nil, errWarp
-	}
-	defer srcFile.Close()
-	_, err = io.Copy(formFile, srcFile)
-	if err != nil {
-		errWarp := errors.New(fmt.Sprintf("Write to form file falied: %v, %v, %+v", file, url, err))
-		logs.Log.Error(errWarp)
-		return nil, errWarp
+// PostFile 发送文件，文件内容边读边发送，不会整体读入内存
+func (c *Client) PostFile(ctx context.Context, fieldname, file, url string, header http.Header) ([]byte, error) {
+	return c.PostFileWithProgress(ctx, fieldname, file, url, header, nil)
+}
+
+// PostFileWithProgress 发送文件并通过 observer 回调上传进度
+func (c *Client) PostFileWithProgress(
+	ctx context.Context,
+	fieldname, file, url string,
+	header http.Header,
+	observer *progress.Observer,
+) ([]byte, error) {
+	form := &MultipartForm{
+		Files:    []FormFile{{FieldName: fieldname, FilePath: file, FileName: file}},
+		Progress: observer,
 	}
+	return c.PostMultipart(ctx, url, form, header)
+}
+
+// TryCountGetRespRedirect 使用默认 Client 多次尝试 GetRespRedirect
+func TryCountGetRespRedirect(
+	ctx context.Context,
+	method, url string,
+	header http.Header,
+	data []byte,
+) (resp *http.Response, redirectURL string, err error) {
+	return defaultClient().TryCountGetRespRedirect(ctx, method, url, header, data)
+}
 
-	writer.Close()
+// Do 使用默认 Client 发送请求，参见 Client.Do
+func Do(ctx context.Context, method, url string, header http.Header, data []byte) (*Response, error) {
+	return defaultClient().Do(ctx, method, url, header, data)
+}
 
-	if header == nil {
-		header = make(http.Header)
-	}
-	header.Set("Content-Type", writer.FormDataContentType())
-	resp, _, err := TryCountGetRespRedirect(ctx, http.MethodPost, url, header, buf.Bytes())
-	if err != nil {
-		errWarp := errors.New(fmt.Sprintf("TryCountGetRespRedirect fail: %v %+v %+v", url, resp, err))
-		logs.Log.Error(errWarp)
-		return nil, errWarp
-	}
-	defer resp.Body.Close()
-	return ioutil.ReadAll(resp.Body)
+// AcceptRange 使用默认 Client 判断 url 是否支持按照字节下载
+func AcceptRange(ctx context.Context, url string) (fileSize int64, fileName, redirectURL string, err error) {
+	return defaultClient().AcceptRange(ctx, url)
+}
+
+// PostFile 使用默认 Client 发送文件
+func PostFile(ctx context.Context, fieldname, file, url string, header http.Header) ([]byte, error) {
+	return defaultClient().PostFile(ctx, fieldname, file, url, header)
+}
+
+// PostFileWithProgress 使用默认 Client 发送文件并回调上传进度
+func PostFileWithProgress(
+	ctx context.Context,
+	fieldname, file, url string,
+	header http.Header,
+	observer *progress.Observer,
+) ([]byte, error) {
+	return defaultClient().PostFileWithProgress(ctx, fieldname, file, url, header, observer)
 }
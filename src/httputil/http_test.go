@@ -4,16 +4,20 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"errors"
-	"go-utils/src/config"
 	"github.com/agiledragon/gomonkey/v2"
 	"github.com/google/uuid"
 )
@@ -80,18 +84,16 @@ func Test_getNewRequest(t *testing.T) {
 	}
 }
 
-func Test_getResp(t *testing.T) {
-	patchesGetNewRequest := gomonkey.ApplyFunc(getNewRequest, func(
-		ctx context.Context,
-		method, url string,
-		header http.Header,
-		data *bytes.Buffer,
-	) (*http.Request, error) {
-		return &http.Request{Host: url}, nil
-	})
-	defer patchesGetNewRequest.Reset()
+func TestClient_GetResp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-User-Agent", r.Header.Get("User-Agent"))
+		w.Header().Set("X-Token", r.Header.Get("X-Token"))
+	}))
+	defer server.Close()
 
 	ctx := context.Background()
+	tokenHeader := http.Header{}
+	tokenHeader.Set("X-Token", "abc")
 
 	type args struct {
 		ctx    context.Context
@@ -101,50 +103,35 @@ func Test_getResp(t *testing.T) {
 		data   []byte
 	}
 	tests := []struct {
-		name    string
-		args    args
-		want    *http.Response
-		wantErr bool
+		name      string
+		client    *Client
+		args      args
+		wantAgent string
+		wantToken string
+		wantErr   bool
 	}{
-		{"err_do", args{ctx, "", "", nil, nil}, nil, true},
+		{"err_do", NewClient(), args{ctx, "", "", nil, nil}, "", "", true},
+		{"normal_default_header", NewClient(), args{ctx, http.MethodGet, server.URL, tokenHeader, nil},
+			GetDefaultHeader().Get("User-Agent"), "abc", false},
+		{"normal_no_default_header", NewClient(WithDefaultHeader(nil)), args{ctx, http.MethodGet, server.URL, nil, nil},
+			"Go-http-client/1.1", "", false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := getResp(tt.args.ctx, tt.args.method, tt.args.url, tt.args.header, tt.args.data)
+			got, err := tt.client.GetResp(tt.args.ctx, tt.args.method, tt.args.url, tt.args.header, tt.args.data)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("[%v] getResp() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+				t.Errorf("[%v] GetResp() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("getResp() = %v, want %v", got, tt.want)
+			if err != nil {
+				return
 			}
-		})
-	}
-}
-
-func TestGetHTTPFileName(t *testing.T) {
-	header := http.Header{}
-	header.Set("Content-Disposition", "text/html; charset=utf-8; filename=hello.ts")
-	type args struct {
-		uri        string
-		resp       *http.Response
-		defaultExt string
-		pre        string
-	}
-	tests := []struct {
-		name string
-		args args
-		want string
-	}{
-		{"normal_uri_empty", args{"", &http.Response{}, ".mp4", "pre"}, "pre_.mp4"},
-		{"normal", args{"http://tencent.com/test.mp3", &http.Response{}, ".mp4", "pre"}, "pre_test.mp3"},
-		{"normal_no_ext", args{"http://tencent.com/test", &http.Response{}, ".mp4", "pre"}, "pre_test"},
-		{"normal_resp", args{"", &http.Response{Header: header}, ".mp4", "pre"}, "pre_hello.ts"},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := GetHTTPFileName(tt.args.uri, tt.args.resp, tt.args.defaultExt, tt.args.pre); got != tt.want {
-				t.Errorf("GetHTTPFileName() = %v, want %v", got, tt.want)
+			defer got.Body.Close()
+			if agent := got.Header.Get("X-User-Agent"); agent != tt.wantAgent {
+				t.Errorf("[%v] GetResp() User-Agent = %v, want %v", tt.name, agent, tt.wantAgent)
+			}
+			if token := got.Header.Get("X-Token"); token != tt.wantToken {
+				t.Errorf("[%v] GetResp() X-Token = %v, want %v", tt.name, token, tt.wantToken)
 			}
 		})
 	}
@@ -190,30 +177,32 @@ func (rc *readWriteCloserImpl) Close() error {
 	return nil
 }
 
-func Test_getRespRedirect(t *testing.T) {
-	cfg := config.ServerConfig{}
-	cfg.ControlConfig.MaxRedirectCounts = 2
-	patchesRedirectCounts := gomonkey.ApplyGlobalVar(&config.ServerCnf, cfg)
-	defer patchesRedirectCounts.Reset()
-
-	header := http.Header{}
-	header.Add("Location", "")
-
-	bytes.NewBuffer([]byte{1, 2, 3})
-
-	patchesGetResp := gomonkey.ApplyFuncSeq(getResp, []gomonkey.OutputCell{
-		{Values: gomonkey.Params{nil, errors.New("getResp fail")}, Times: 1}, // getResp Fail
-		{Values: gomonkey.Params{&http.Response{StatusCode: 301, Header: header, Body: new(readWriteCloserImpl)}, nil},
-			Times: 1,
-		}, // redirectOK
-		{Values: gomonkey.Params{&http.Response{StatusCode: 200}, nil}, Times: 1}, // redirectOK
-		{Values: gomonkey.Params{&http.Response{StatusCode: 404, Body: new(readWriteCloserImpl)}, nil},
-			Times: 1,
-		}, // 404
+// newRedirectServer 构造测试服务，/redirect/n 重定向 n 次后到 /ok，/not_found 返回 404
+func newRedirectServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(path.Base(r.URL.Path))
+		if n <= 1 {
+			http.Redirect(w, r, "http://"+r.Host+"/ok", http.StatusMovedPermanently)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("http://%s/redirect/%d", r.Host, n-1), http.StatusFound)
 	})
-	defer patchesGetResp.Reset()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/not_found", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestClient_GetRespRedirect(t *testing.T) {
+	server := newRedirectServer()
+	defer server.Close()
 
 	ctx := context.Background()
+	client := NewClient(WithMaxRedirects(2))
 
 	type args struct {
 		ctx    context.Context
@@ -225,69 +214,65 @@ func Test_getRespRedirect(t *testing.T) {
 	tests := []struct {
 		name    string
 		args    args
-		want    *http.Response
+		want    int
 		want1   string
 		wantErr bool
 	}{
-		{"getResp Fail", args{ctx, "", "", nil, nil}, nil, "", true},
-		{"redirectOK", args{ctx, "", "", nil, nil}, &http.Response{StatusCode: 200}, "", false},
-		{"404", args{ctx, "", "", nil, nil}, nil, "", true},
+		{"getResp Fail", args{ctx, "", "", nil, nil}, 0, "", true},
+		{"normal", args{ctx, http.MethodGet, server.URL + "/ok", nil, nil}, 200, server.URL + "/ok", false},
+		{"redirectOK", args{ctx, http.MethodGet, server.URL + "/redirect/2", nil, nil}, 200, server.URL + "/ok", false},
+		{"too_many_redirect", args{ctx, http.MethodGet, server.URL + "/redirect/3", nil, nil}, 0, server.URL + "/redirect/1", true},
+		{"404", args{ctx, http.MethodGet, server.URL + "/not_found", nil, nil}, 0, server.URL + "/not_found", true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, err := getRespRedirect(tt.args.ctx, tt.args.method, tt.args.url, tt.args.header, tt.args.data)
+			got, got1, err := client.GetRespRedirect(tt.args.ctx, tt.args.method, tt.args.url, tt.args.header, tt.args.data)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("[%v] getRespRedirect() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+				t.Errorf("[%v] GetRespRedirect() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("[%v] getRespRedirect() got = %v, want %v", tt.name, got, tt.want)
+			if got != nil {
+				defer got.Body.Close()
+				if got.StatusCode != tt.want {
+					t.Errorf("[%v] GetRespRedirect() got = %v, want %v", tt.name, got.StatusCode, tt.want)
+				}
 			}
 			if got1 != tt.want1 {
-				t.Errorf("[%v] getRespRedirect() got1 = %v, want %v", tt.name, got1, tt.want1)
+				t.Errorf("[%v] GetRespRedirect() got1 = %v, want %v", tt.name, got1, tt.want1)
 			}
 		})
 	}
 }
 
-func Test_errorFromResponse(t *testing.T) {
-	type args struct {
-		resp *http.Response
-		err  error
-	}
-
-	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
-	}{
-		{"nil", args{nil, nil}, false},
-		{"render_error", args{nil, errors.errorserverNoService}, true},
-		{"system_error", args{&http.Response{StatusCode: 404, Body: new(readWriteCloserImpl)}, io.EOF}, true},
-		{"system_resp_body_close_error", args{&http.Response{StatusCode: 404, Body: new(readWriteCloserImpl)}, io.EOF}, true},
-		{"unknow_error", args{nil, errors.New("")}, true},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := errorFromResponse(tt.args.resp, tt.args.err); (err != nil) != tt.wantErr {
-				t.Errorf("[%v] errorFromResponse() error = %v, wantErr %v", tt.name, err, tt.wantErr)
-			}
-		})
-	}
-}
-
-func TestTryCountGetRespRedirect(t *testing.T) {
-	cfg := config.ServerConfig{}
-	cfg.ControlConfig.HTTPRequestRetryCounts = 2
-	patchesRedirectCounts := gomonkey.ApplyGlobalVar(&config.ServerCnf, cfg)
-	defer patchesRedirectCounts.Reset()
-
-	patchesGetRespRedirect := gomonkey.ApplyFuncSeq(getRespRedirect, []gomonkey.OutputCell{
-		{Values: gomonkey.Params{nil, "", errors.New("getRespRedirect fail")}, Times: 1}, // normal
-		{Values: gomonkey.Params{&http.Response{}, "", nil}, Times: 1},                   // normal
+func TestClient_TryCountGetRespRedirect(t *testing.T) {
+	var count int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/unavailable", func(w http.ResponseWriter, r *http.Request) {
+		// 第一次请求失败，之后成功
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/too_many", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/not_found", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		http.NotFound(w, r)
 	})
-	defer patchesGetRespRedirect.Reset()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
 	ctx := context.Background()
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
 
 	type args struct {
 		ctx    context.Context
@@ -298,54 +283,63 @@ func TestTryCountGetRespRedirect(t *testing.T) {
 	}
 	tests := []struct {
 		name            string
+		client          *Client
 		args            args
-		wantResp        *http.Response
+		wantCode        int
 		wantRedirectURL string
+		wantCount       int32
 		wantErr         bool
 	}{
-		{"normal", args{ctx, "", "", nil, nil}, &http.Response{}, "", false},
+		{"fail_no_retry", NewClient(WithRetry(1, 0)),
+			args{ctx, http.MethodGet, server.URL + "/unavailable", nil, nil}, 0, "", 1, true},
+		{"normal", NewClient(WithRetry(2, 0)),
+			args{ctx, http.MethodGet, server.URL + "/unavailable", nil, nil}, 200, server.URL + "/unavailable", 2, false},
+		{"retry_after", NewClient(WithRetry(2, time.Hour)),
+			args{ctx, http.MethodGet, server.URL + "/too_many", nil, nil}, 200, server.URL + "/too_many", 2, false},
+		{"not_retryable", NewClient(WithRetry(3, 0)),
+			args{ctx, http.MethodGet, server.URL + "/not_found", nil, nil}, 0, "", 1, true},
+		{"ctx_timeout_while_sleep", NewClient(WithRetry(3, time.Hour)),
+			args{timeoutCtx, http.MethodGet, server.URL + "/unavailable", nil, nil}, 0, "", 1, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotResp, gotRedirectURL, err := TryCountGetRespRedirect(
+			atomic.StoreInt32(&count, 0)
+			gotResp, gotRedirectURL, err := tt.client.TryCountGetRespRedirect(
 				tt.args.ctx, tt.args.method, tt.args.url, tt.args.header, tt.args.data,
 			)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("TryCountGetRespRedirect() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("[%v] TryCountGetRespRedirect() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(gotResp, tt.wantResp) {
-				t.Errorf("TryCountGetRespRedirect() gotResp = %v, want %v", gotResp, tt.wantResp)
+			if gotResp != nil {
+				defer gotResp.Body.Close()
+				if gotResp.StatusCode != tt.wantCode {
+					t.Errorf("[%v] TryCountGetRespRedirect() gotResp = %v, want %v", tt.name, gotResp.StatusCode, tt.wantCode)
+				}
 			}
 			if gotRedirectURL != tt.wantRedirectURL {
-				t.Errorf("TryCountGetRespRedirect() gotRedirectURL = %v, want %v", gotRedirectURL, tt.wantRedirectURL)
+				t.Errorf("[%v] TryCountGetRespRedirect() gotRedirectURL = %v, want %v", tt.name, gotRedirectURL, tt.wantRedirectURL)
+			}
+			if got := atomic.LoadInt32(&count); got != tt.wantCount {
+				t.Errorf("[%v] TryCountGetRespRedirect() request count = %v, want %v", tt.name, got, tt.wantCount)
 			}
 		})
 	}
 }
 
-func TestAcceptRange(t *testing.T) {
-
-	header := http.Header{}
-	header.Add("Accept-Ranges", "bytes")
-	header.Add("Content-Range", "3600-5000/5000")
-	patchesTryCountGetRespRedirect := gomonkey.ApplyFuncSeq(TryCountGetRespRedirect, []gomonkey.OutputCell{
-		{Values: gomonkey.Params{nil, "", errors.New("getRespRedirect fail")}, Times: 1},
-		{Values: gomonkey.Params{&http.Response{StatusCode: 200}, "", nil}, Times: 1},
-		{
-			Values: gomonkey.Params{
-				&http.Response{Header: header, StatusCode: 206, Body: new(readWriteCloserImpl)}, "", nil,
-			},
-			Times: 1,
-		},
+func TestClient_AcceptRange(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 500))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/range/test.mp4", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "test.mp4", time.Time{}, bytes.NewReader(content))
 	})
-	defer patchesTryCountGetRespRedirect.Reset()
-
-	patchesGetHTTPFileName := gomonkey.ApplyFuncSeq(GetHTTPFileName, []gomonkey.OutputCell{
-		{Values: gomonkey.Params{""}, Times: 3},
+	mux.HandleFunc("/stream/test.mp4", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
 	})
-	defer patchesGetHTTPFileName.Reset()
+	server := httptest.NewServer(mux)
+	defer server.Close()
 
+	client := NewClient(WithRetry(1, 0))
 	ctx := context.Background()
 
 	type args struct {
@@ -360,13 +354,13 @@ func TestAcceptRange(t *testing.T) {
 		wantRedirectURL string
 		wantErr         bool
 	}{
-		{"error_TryCountGetRespRedirect", args{ctx, ""}, 0, "", "", true},
-		{"normal_not_support", args{ctx, ""}, 0, "", "", true},
-		{"normal_support", args{ctx, ""}, 5000, "", "", false},
+		{"error_TryCountGetRespRedirect", args{ctx, server.URL + "/not_found"}, 0, "", "", true},
+		{"normal_not_support", args{ctx, server.URL + "/stream/test.mp4"}, 0, "_test.mp4", server.URL + "/stream/test.mp4", true},
+		{"normal_support", args{ctx, server.URL + "/range/test.mp4"}, 5000, "_test.mp4", server.URL + "/range/test.mp4", false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotFileSize, gotFileName, gotRedirectURL, err := AcceptRange(tt.args.ctx, tt.args.url)
+			gotFileSize, gotFileName, gotRedirectURL, err := client.AcceptRange(tt.args.ctx, tt.args.url)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("AcceptRange() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -374,8 +368,8 @@ func TestAcceptRange(t *testing.T) {
 			if gotFileSize != tt.wantFileSize {
 				t.Errorf("AcceptRange() gotFileSize = %v, want %v", gotFileSize, tt.wantFileSize)
 			}
-			if gotFileName != tt.wantFileName {
-				t.Errorf("AcceptRange() gotFileName = %v, want %v", gotFileName, tt.wantFileName)
+			if !strings.HasSuffix(gotFileName, tt.wantFileName) {
+				t.Errorf("AcceptRange() gotFileName = %v, want suffix %v", gotFileName, tt.wantFileName)
 			}
 			if gotRedirectURL != tt.wantRedirectURL {
 				t.Errorf("AcceptRange() gotRedirectURL = %v, want %v", gotRedirectURL, tt.wantRedirectURL)
@@ -384,12 +378,17 @@ func TestAcceptRange(t *testing.T) {
 	}
 }
 
-func TestPostFile(t *testing.T) {
-	patchesTryCountGetRespRedirect := gomonkey.ApplyFuncSeq(TryCountGetRespRedirect, []gomonkey.OutputCell{
-		{Values: gomonkey.Params{&http.Response{StatusCode: 200, Body: new(readWriteCloserImpl)}, "", nil}, Times: 1},
-		{Values: gomonkey.Params{nil, "", errors.New("getRespRedirect fail")}, Times: 1},
-	})
-	defer patchesTryCountGetRespRedirect.Reset()
+func TestClient_PostFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("fieldname")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		io.Copy(w, file)
+	}))
+	defer server.Close()
 
 	tmpFile := path.Join(os.TempDir(), uuid.New().String())
 	if err := ioutil.WriteFile(tmpFile, []byte{1, 2, 3}, os.ModePerm); err != nil {
@@ -397,6 +396,7 @@ func TestPostFile(t *testing.T) {
 	}
 	defer os.Remove(tmpFile)
 
+	client := NewClient(WithRetry(1, 0))
 	ctx := context.Background()
 
 	type args struct {
@@ -412,13 +412,13 @@ func TestPostFile(t *testing.T) {
 		want    []byte
 		wantErr bool
 	}{
-		{"normal", args{ctx, "fieldname", tmpFile, "", GetDefaultHeader()}, []byte{}, false},
-		{"err_os.Open", args{ctx, "fieldname", "", "", nil}, nil, true},
-		{"err_TryCountGetRespRedirect", args{ctx, "fieldname", tmpFile, "", nil}, nil, true},
+		{"normal", args{ctx, "fieldname", tmpFile, server.URL, GetDefaultHeader()}, []byte{1, 2, 3}, false},
+		{"err_os.Open", args{ctx, "fieldname", "", server.URL, nil}, nil, true},
+		{"err_TryCountGetRespRedirect", args{ctx, "other", tmpFile, server.URL, nil}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := PostFile(tt.args.ctx, tt.args.fieldname, tt.args.file, tt.args.url, tt.args.header)
+			got, err := client.PostFile(tt.args.ctx, tt.args.fieldname, tt.args.file, tt.args.url, tt.args.header)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("[%v] PostFile() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 				return
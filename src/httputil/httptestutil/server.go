@@ -0,0 +1,264 @@
+// Package httptestutil 可编排的测试 HTTP 服务，按路径依次返回预设的响应，支持重定向、Range、
+// 慢速响应、中途断开等故障注入，用于测试下载、重试、重定向等逻辑，不需要 monkey patch
+package httptestutil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Fault 故障类型
+type Fault int
+
+// 支持的故障
+const (
+	FaultNone  Fault = iota
+	FaultAbort       // 不返回任何响应直接断开连接
+	FaultHang        // 不返回响应，直到客户端取消请求
+)
+
+// Response 预设的响应
+type Response struct {
+	Status   int         // 状态码，默认 200，设置了 Redirect 时默认 302；Range 为 true 时由 http.ServeContent 决定
+	Header   http.Header // 响应头，Range 为 true 时可以设置 ETag/Last-Modified 供 If-Range 使用
+	Body     []byte
+	Redirect string // 重定向地址，可以是相对路径
+	Range    bool   // 使用 http.ServeContent 返回 Body，支持 Range/If-Range/If-None-Match
+
+	Delay      time.Duration // 返回响应头前的等待时间
+	ChunkSize  int           // 每次写入 body 的字节数，0 表示不分块
+	ChunkDelay time.Duration // 每写入一块后的等待时间，配合 ChunkSize 模拟慢速响应
+	DropAfter  int64         // 大于 0 时写入 DropAfter 字节 body 后断开连接，模拟传输中断
+	Fault      Fault
+}
+
+// Times 重复 n 次同一个响应，便于编排 "失败 n 次后成功" 的场景
+func Times(n int, resp Response) []Response {
+	responses := make([]Response, n)
+	for i := range responses {
+		responses[i] = resp
+	}
+	return responses
+}
+
+// Request 服务收到的请求
+type Request struct {
+	Method string
+	URL    *url.URL // 请求的路径及参数
+	Header http.Header
+	Body   []byte
+}
+
+// route 一个路径的响应序列
+type route struct {
+	responses []Response
+	handler   http.Handler
+	count     int
+}
+
+// Server 可编排的测试服务，未注册的路径返回 404
+type Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	routes   map[string]*route
+	requests []Request
+}
+
+// NewServer 启动测试服务，使用完需要调用 Close
+func NewServer() *Server {
+	s := &Server{routes: make(map[string]*route)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Handle 设置 path 的响应序列，第 i 次请求返回 responses[i]，超出后一直返回最后一个响应。
+// 重复设置同一个 path 时替换原有序列并重新计数
+func (s *Server) Handle(path string, responses ...Response) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes[path] = &route{responses: responses}
+	return s
+}
+
+// HandleFunc 使用自定义 handler 处理 path，请求同样会被记录
+func (s *Server) HandleFunc(path string, handler http.HandlerFunc) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes[path] = &route{handler: handler}
+	return s
+}
+
+// URLFor 返回 path 的完整 url
+func (s *Server) URLFor(path string) string {
+	return s.URL + path
+}
+
+// Requests 返回 path 收到的请求，path 为空时返回所有请求
+func (s *Server) Requests(path string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []Request
+	for _, r := range s.requests {
+		if path == "" || r.URL.Path == path {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// Count 返回 path 收到的请求数，path 为空时返回所有请求数
+func (s *Server) Count(path string) int {
+	return len(s.Requests(path))
+}
+
+// Reset 清空请求记录并重置所有路径的响应序列
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+	for _, r := range s.routes {
+		r.count = 0
+	}
+}
+
+// next 记录请求并返回本次请求对应的响应
+func (s *Server) next(r *http.Request, body []byte) (*Response, http.Handler, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := *r.URL
+	s.requests = append(s.requests, Request{Method: r.Method, URL: &u, Header: r.Header.Clone(), Body: body})
+	rt, ok := s.routes[r.URL.Path]
+	if !ok {
+		return nil, nil, false
+	}
+	if rt.handler != nil {
+		return nil, rt.handler, true
+	}
+	if len(rt.responses) == 0 {
+		return &Response{}, nil, true
+	}
+	index := rt.count
+	if index >= len(rt.responses) {
+		index = len(rt.responses) - 1
+	}
+	rt.count++
+	resp := rt.responses[index]
+	return &resp, nil, true
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp, handler, ok := s.next(r, body)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if handler != nil {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	if resp.Delay > 0 && !sleep(r, resp.Delay) {
+		return
+	}
+	switch resp.Fault {
+	case FaultAbort:
+		panic(http.ErrAbortHandler)
+	case FaultHang:
+		<-r.Context().Done()
+		return
+	}
+
+	for k, v := range resp.Header {
+		w.Header()[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
+	bw := &bodyWriter{ResponseWriter: w, req: r, resp: resp}
+	if resp.Range {
+		http.ServeContent(bw, r, "", time.Time{}, bytes.NewReader(resp.Body))
+		return
+	}
+
+	status := resp.Status
+	if resp.Redirect != "" {
+		w.Header().Set("Location", resp.Redirect)
+		if status == 0 {
+			status = http.StatusFound
+		}
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		bw.Write(resp.Body)
+	}
+}
+
+// sleep 等待 d，客户端取消请求时返回 false
+func sleep(r *http.Request, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// bodyWriter 按 Response 的配置分块、限速写入 body，写入 DropAfter 字节后断开连接
+type bodyWriter struct {
+	http.ResponseWriter
+	req     *http.Request
+	resp    *Response
+	written int64
+}
+
+func (w *bodyWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if w.resp.ChunkSize > 0 && len(chunk) > w.resp.ChunkSize {
+			chunk = chunk[:w.resp.ChunkSize]
+		}
+		drop := false
+		if w.resp.DropAfter > 0 && w.written+int64(len(chunk)) >= w.resp.DropAfter {
+			chunk = chunk[:w.resp.DropAfter-w.written]
+			drop = true
+		}
+		m, err := w.ResponseWriter.Write(chunk)
+		n += m
+		w.written += int64(m)
+		if err != nil {
+			return n, err
+		}
+		if drop {
+			w.flush()
+			panic(http.ErrAbortHandler)
+		}
+		p = p[len(chunk):]
+		if w.resp.ChunkDelay > 0 {
+			w.flush()
+			if !sleep(w.req, w.resp.ChunkDelay) {
+				return n, w.req.Context().Err()
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *bodyWriter) flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
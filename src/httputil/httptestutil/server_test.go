@@ -0,0 +1,182 @@
+package httptestutil
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noRedirectClient 不跟随重定向的 http.Client
+var noRedirectClient = &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}}
+
+func get(client *http.Client, req *http.Request) (*http.Response, string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp, string(data), err
+}
+
+func TestServer_Handle(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Handle("/seq", append(Times(2, Response{Status: http.StatusServiceUnavailable}), Response{Body: []byte("ok")})...)
+	server.Handle("/redirect", Response{Redirect: "/seq"})
+	server.Handle("/header", Response{Status: http.StatusCreated, Header: http.Header{"x-test": {"1"}}, Body: []byte("created")})
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"first", "/seq", http.StatusServiceUnavailable, ""},
+		{"second", "/seq", http.StatusServiceUnavailable, ""},
+		{"third", "/seq", http.StatusOK, "ok"},
+		{"repeat_last", "/seq", http.StatusOK, "ok"},
+		{"redirect", "/redirect", http.StatusFound, ""},
+		{"header", "/header", http.StatusCreated, "created"},
+		{"not_found", "/unknown", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URLFor(tt.path), nil)
+			resp, body, err := get(noRedirectClient, req)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, tt.wantBody, body)
+			if tt.path == "/redirect" {
+				assert.Equal(t, "/seq", resp.Header.Get("Location"))
+			}
+			if tt.path == "/header" {
+				assert.Equal(t, "1", resp.Header.Get("X-Test"))
+			}
+		})
+	}
+	assert.Equal(t, 4, server.Count("/seq"))
+	assert.Equal(t, 7, server.Count(""))
+
+	server.Reset()
+	assert.Equal(t, 0, server.Count(""))
+	req, _ := http.NewRequest(http.MethodPost, server.URLFor("/seq?a=1"), strings.NewReader("data"))
+	resp, _, err := get(noRedirectClient, req)
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	requests := server.Requests("/seq")
+	if assert.Equal(t, 1, len(requests)) {
+		assert.Equal(t, http.MethodPost, requests[0].Method)
+		assert.Equal(t, "a=1", requests[0].URL.RawQuery)
+		assert.Equal(t, "data", string(requests[0].Body))
+	}
+}
+
+func TestServer_Range(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	content := []byte(strings.Repeat("0123456789", 10))
+	server.Handle("/file", Response{Range: true, Header: http.Header{"ETag": {`"v1"`}}, Body: content})
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+	}{
+		{"full", nil, http.StatusOK, string(content)},
+		{"range", http.Header{"Range": {"bytes=10-19"}}, http.StatusPartialContent, "0123456789"},
+		{"if_range_match", http.Header{"Range": {"bytes=95-"}, "If-Range": {`"v1"`}}, http.StatusPartialContent, "56789"},
+		{"if_range_changed", http.Header{"Range": {"bytes=95-"}, "If-Range": {`"v0"`}}, http.StatusOK, string(content)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URLFor("/file"), nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			resp, body, err := get(http.DefaultClient, req)
+			if assert.Nil(t, err) {
+				assert.Equal(t, tt.wantStatus, resp.StatusCode)
+				assert.Equal(t, tt.wantBody, body)
+				assert.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestServer_Fault(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	content := []byte(strings.Repeat("0123456789", 10))
+	server.Handle("/abort", Response{Fault: FaultAbort}, Response{Body: []byte("ok")})
+	server.Handle("/hang", Response{Fault: FaultHang})
+	server.Handle("/delay", Response{Delay: time.Second})
+	server.Handle("/drop", Response{Body: content, DropAfter: 50})
+	server.Handle("/drop_range", Response{Range: true, Body: content, DropAfter: 5})
+	server.Handle("/slow", Response{Body: content, ChunkSize: 25, ChunkDelay: 20 * time.Millisecond})
+	// 不复用连接，避免 Transport 在复用的连接断开后自动重试
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	tests := []struct {
+		name     string
+		path     string
+		header   http.Header
+		timeout  time.Duration
+		wantErr  bool
+		wantBody string
+		minTime  time.Duration
+	}{
+		{"abort", "/abort", nil, 0, true, "", 0},
+		{"abort_then_ok", "/abort", nil, 0, false, "ok", 0},
+		{"hang", "/hang", nil, 50 * time.Millisecond, true, "", 50 * time.Millisecond},
+		{"delay", "/delay", nil, 50 * time.Millisecond, true, "", 50 * time.Millisecond},
+		{"drop", "/drop", nil, 0, true, string(content[:50]), 0},
+		{"drop_range", "/drop_range", http.Header{"Range": {"bytes=10-29"}}, 0, true, string(content[10:15]), 0},
+		{"slow", "/slow", nil, 0, false, string(content), 60 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URLFor(tt.path), nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			start := time.Now()
+			_, body, err := get(client, req)
+			assert.Equal(t, tt.wantErr, err != nil, "%v", err)
+			assert.Equal(t, tt.wantBody, body)
+			assert.GreaterOrEqual(t, time.Since(start), tt.minTime)
+		})
+	}
+}
+
+func TestServer_HandleFunc(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		w.Write(data)
+	})
+	req, _ := http.NewRequest(http.MethodPut, server.URLFor("/echo"), strings.NewReader("hello"))
+	_, body, err := get(http.DefaultClient, req)
+	assert.Nil(t, err)
+	// 记录请求后 handler 仍然可以读取 body
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "hello", string(server.Requests("/echo")[0].Body))
+}
@@ -0,0 +1,102 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// ErrResponseTooLarge 响应内容超过 Client 的 maxRespSize
+var ErrResponseTooLarge = errors.New("response too large")
+
+const jsonContentType = "application/json"
+
+// readLimited 读取最多 limit 字节的内容，超过时返回 ErrResponseTooLarge，limit<=0 表示不限制
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w, limit %d bytes", ErrResponseTooLarge, limit)
+	}
+	return data, nil
+}
+
+// jsonHeader 复制 header 并设置 JSON 请求需要的 Accept/Content-Type
+func jsonHeader(header http.Header, hasBody bool) http.Header {
+	header = header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("Accept") == "" {
+		header.Set("Accept", jsonContentType)
+	}
+	if hasBody && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", jsonContentType)
+	}
+	return header
+}
+
+// doJSON 发送请求并将响应解析为 Resp，空响应(比如 204)返回零值
+func doJSON[Resp any](ctx context.Context, c *Client, method, url string, header http.Header, data []byte) (Resp, error) {
+	var result Resp
+	if c == nil {
+		c = defaultClient()
+	}
+	resp, err := c.Do(ctx, method, url, jsonHeader(header, data != nil), data)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	content, err := readLimited(resp.Body, c.maxRespSize)
+	if err != nil {
+		return result, fmt.Errorf("read response of %v %v fail: %w", method, resp.URL, err)
+	}
+	if len(content) == 0 {
+		return result, nil
+	}
+	if err = json.Unmarshal(content, &result); err != nil {
+		return result, fmt.Errorf("decode response of %v %v fail: %w", method, resp.URL, err)
+	}
+	return result, nil
+}
+
+// DoJSON 将 req 编码为 JSON 发送，并将响应解析为 Resp，复用 Client 的重试及重定向处理。
+// c 为 nil 时使用默认 Client；失败的响应返回 *HTTPError，可以通过 DecodeErrorBody 解析错误内容
+func DoJSON[Req, Resp any](ctx context.Context, c *Client, method, url string, req Req, header http.Header) (Resp, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		var result Resp
+		return result, fmt.Errorf("encode request of %v %v fail: %w", method, url, err)
+	}
+	return doJSON[Resp](ctx, c, method, url, header, data)
+}
+
+// GetJSON GET 请求并将响应解析为 T，参见 DoJSON
+func GetJSON[T any](ctx context.Context, c *Client, url string, header http.Header) (T, error) {
+	return doJSON[T](ctx, c, http.MethodGet, url, header, nil)
+}
+
+// PostJSON POST JSON 请求并将响应解析为 Resp，参见 DoJSON
+func PostJSON[Req, Resp any](ctx context.Context, c *Client, url string, req Req, header http.Header) (Resp, error) {
+	return DoJSON[Req, Resp](ctx, c, http.MethodPost, url, req, header)
+}
+
+// DecodeErrorBody 将 err 中 HTTPError 的响应内容按 JSON 解析到 v，比如服务端返回的 {"code":1,"msg":"..."}。
+// err 不是 HTTPError、没有响应内容或解析失败时返回 false；响应内容超过 maxErrorBodySize 时会被截断，无法解析
+func DecodeErrorBody(err error, v interface{}) bool {
+	var e *HTTPError
+	if !errors.As(err, &e) || len(e.Body) == 0 || e.Truncated {
+		return false
+	}
+	return json.Unmarshal(e.Body, v) == nil
+}
@@ -0,0 +1,121 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type testAPIError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func newJSONServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != jsonContentType {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(testUser{ID: 1, Name: "get"})
+		case http.MethodPost:
+			var user testUser
+			if r.Header.Get("Content-Type") != jsonContentType || json.NewDecoder(r.Body).Decode(&user) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(testAPIError{Code: 1001, Msg: "invalid user"})
+				return
+			}
+			user.ID = 2
+			json.NewEncoder(w).Encode(user)
+		}
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"` + strings.Repeat("x", 100) + `"}`))
+	})
+	mux.HandleFunc("/invalid", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not json"))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/user", http.StatusMovedPermanently)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestGetJSON(t *testing.T) {
+	server := newJSONServer()
+	defer server.Close()
+	client := NewClient(WithRetry(1, 0), WithMaxResponseSize(64))
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		path    string
+		want    testUser
+		wantErr bool
+		wantIs  error
+	}{
+		{"normal", "/user", testUser{ID: 1, Name: "get"}, false, nil},
+		{"empty", "/empty", testUser{}, false, nil},
+		{"too_large", "/large", testUser{}, true, ErrResponseTooLarge},
+		{"invalid", "/invalid", testUser{}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetJSON[testUser](ctx, client, server.URL+tt.path, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("[%v] GetJSON() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+				return
+			}
+			if tt.wantIs != nil {
+				assert.True(t, errors.Is(err, tt.wantIs))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPostJSON(t *testing.T) {
+	server := newJSONServer()
+	defer server.Close()
+	client := NewClient(WithRetry(1, 0))
+	ctx := context.Background()
+
+	got, err := PostJSON[testUser, testUser](ctx, client, server.URL+"/user", testUser{Name: "post"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, testUser{ID: 2, Name: "post"}, got)
+
+	// 结构化的错误内容
+	_, err = PostJSON[string, testUser](ctx, client, server.URL+"/user", "invalid", nil)
+	assert.Equal(t, http.StatusBadRequest, StatusCode(err))
+	var apiErr testAPIError
+	if assert.True(t, DecodeErrorBody(err, &apiErr)) {
+		assert.Equal(t, testAPIError{Code: 1001, Msg: "invalid user"}, apiErr)
+	}
+	assert.False(t, DecodeErrorBody(errors.New("other"), &apiErr))
+
+	// 301 重定向后 POST 改为 GET
+	got, err = PostJSON[testUser, testUser](ctx, client, server.URL+"/moved", testUser{Name: "post"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, testUser{ID: 1, Name: "get"}, got)
+
+	// 无法编码的请求
+	_, err = PostJSON[chan int, testUser](ctx, client, server.URL+"/user", make(chan int), nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,179 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-utils/src/logs"
+
+	"github.com/google/uuid"
+)
+
+// DefaultRequestIDHeader RequestID 默认使用的 header
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// RoundTripperFunc 函数形式的 http.RoundTripper
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip 实现 http.RoundTripper
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware 包装 http.RoundTripper，每次实际发出的请求(包括重试及重定向)都会经过 Middleware。
+// 按 http.RoundTripper 的约定，Middleware 不能修改传入的请求，需要修改时先 req.Clone
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// Chain 按顺序组合 Middleware，第一个 Middleware 在最外层，最先处理请求、最后处理响应
+func Chain(transport http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		transport = middlewares[i](transport)
+	}
+	return transport
+}
+
+// WithMiddleware 添加 Middleware，多次调用时按调用顺序追加，Middleware 在缓存之外，缓存命中时同样会经过 Middleware
+func WithMiddleware(middlewares ...Middleware) ClientOption {
+	return func(c *Client) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// Logging 使用 logs.Log 记录每个请求的方法、url、状态码及耗时，失败时记录错误
+func Logging() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				logs.Log.Wainf("%v %v fail after %v: %v", req.Method, req.URL, time.Since(start), err)
+				return resp, err
+			}
+			logs.Log.Infof("%v %v => %v in %v", req.Method, req.URL, resp.StatusCode, time.Since(start))
+			return resp, nil
+		})
+	}
+}
+
+// InjectHeader 在每个请求中设置 header，覆盖请求中已有的同名字段
+func InjectHeader(header http.Header) Middleware {
+	return modifyRequest(func(req *http.Request) bool {
+		return len(header) > 0
+	}, func(req *http.Request) {
+		for k, v := range header {
+			req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+		}
+	})
+}
+
+// BearerAuth 设置 "Authorization: Bearer token"，hosts 为空时只设置与原始请求(重定向之前)同一 host 的请求，
+// 否则只设置指定 host 的请求，避免重定向到其他 host 时泄露 token。请求中已有 Authorization 时不覆盖
+func BearerAuth(token string, hosts ...string) Middleware {
+	return authorization("Bearer "+token, hosts)
+}
+
+// BasicAuth 设置 Basic 认证，hosts 的含义同 BearerAuth
+func BasicAuth(username, password string, hosts ...string) Middleware {
+	req := &http.Request{Header: make(http.Header)}
+	req.SetBasicAuth(username, password)
+	return authorization(req.Header.Get("Authorization"), hosts)
+}
+
+func authorization(value string, hosts []string) Middleware {
+	return modifyRequest(func(req *http.Request) bool {
+		if req.Header.Get("Authorization") != "" {
+			return false
+		}
+		if len(hosts) == 0 {
+			return strings.EqualFold(originURL(req).Host, req.URL.Host)
+		}
+		return matchHost(hosts, req.URL)
+	}, func(req *http.Request) {
+		req.Header.Set("Authorization", value)
+	})
+}
+
+// matchHost host 可以带端口，不带端口时匹配该域名的所有端口
+func matchHost(hosts []string, u *url.URL) bool {
+	for _, host := range hosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// originKey contextWithOrigin 使用的 key
+type originKey struct{}
+
+// contextWithOrigin 记录重定向之前的原始地址，已经记录时不修改
+func contextWithOrigin(ctx context.Context, origin *url.URL) context.Context {
+	if _, ok := ctx.Value(originKey{}).(*url.URL); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+// originURL 请求重定向之前的原始地址，优先使用 ctx 中记录的地址(Client 自己处理重定向)，
+// 其次沿着 http.Client 重定向的 Response 找到第一个请求，都没有时为请求本身的地址
+func originURL(req *http.Request) *url.URL {
+	if origin, ok := req.Context().Value(originKey{}).(*url.URL); ok {
+		return origin
+	}
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req.URL
+}
+
+// requestIDKey ContextWithRequestID 使用的 key
+type requestIDKey struct{}
+
+// ContextWithRequestID 设置 ctx 中的请求 ID，RequestID 优先使用 ctx 中的请求 ID，
+// 同一次调用的重试及重定向使用同一个 ID
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext 获取 ctx 中的请求 ID，没有时返回空
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID 传递请求 ID，header 为空时使用 DefaultRequestIDHeader。请求中已有该 header 时不修改，
+// 否则使用 ctx 中的请求 ID(见 ContextWithRequestID)，都没有时生成 uuid
+func RequestID(header string) Middleware {
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+	return modifyRequest(func(req *http.Request) bool {
+		return req.Header.Get(header) == ""
+	}, func(req *http.Request) {
+		id := RequestIDFromContext(req.Context())
+		if id == "" {
+			id = uuid.New().String()
+		}
+		req.Header.Set(header, id)
+	})
+}
+
+// modifyRequest 需要修改请求时复制请求后再修改
+func modifyRequest(need func(req *http.Request) bool, modify func(req *http.Request)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if !need(req) {
+				return next.RoundTrip(req)
+			}
+			clone := req.Clone(req.Context())
+			if clone.Header == nil {
+				clone.Header = make(http.Header)
+			}
+			modify(clone)
+			return next.RoundTrip(clone)
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"go-utils/src/httputil/httptestutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name+">")
+				resp, err := next.RoundTrip(req)
+				order = append(order, "<"+name)
+				return resp, err
+			})
+		}
+	}
+	transport := Chain(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		order = append(order, "transport")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}), trace("a"), trace("b"))
+	req, _ := http.NewRequest(http.MethodGet, "http://test.com", nil)
+	_, err := transport.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a>", "b>", "transport", "<b", "<a"}, order)
+}
+
+func TestMiddleware(t *testing.T) {
+	u, _ := url.Parse("http://api.test.com:8080/path")
+	tests := []struct {
+		name       string
+		middleware Middleware
+		header     http.Header
+		ctx        context.Context
+		want       http.Header // 期望的 header，值为空表示期望没有该字段
+	}{
+		{"inject", InjectHeader(http.Header{"x-trace": {"1"}}), http.Header{"X-Trace": {"0"}}, nil, http.Header{"X-Trace": {"1"}}},
+		{"bearer", BearerAuth("token"), nil, nil, http.Header{"Authorization": {"Bearer token"}}},
+		{"bearer_host", BearerAuth("token", "api.test.com"), nil, nil, http.Header{"Authorization": {"Bearer token"}}},
+		{"bearer_host_port", BearerAuth("token", "api.test.com:8080"), nil, nil, http.Header{"Authorization": {"Bearer token"}}},
+		{"bearer_other_host", BearerAuth("token", "cdn.test.com"), nil, nil, http.Header{"Authorization": nil}},
+		{"bearer_exist", BearerAuth("token"), http.Header{"Authorization": {"Bearer other"}}, nil, http.Header{"Authorization": {"Bearer other"}}},
+		{"basic", BasicAuth("user", "pass"), nil, nil, http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}},
+		{"bearer_redirected", BearerAuth("token"), nil, contextWithOrigin(context.Background(), &url.URL{Host: "api.test.com:8080"}), http.Header{"Authorization": {"Bearer token"}}},
+		{"bearer_redirected_other_host", BearerAuth("token"), nil, contextWithOrigin(context.Background(), &url.URL{Host: "login.test.com"}), http.Header{"Authorization": nil}},
+		{"request_id_ctx", RequestID(""), nil, ContextWithRequestID(context.Background(), "id"), http.Header{DefaultRequestIDHeader: {"id"}}},
+		{"request_id_exist", RequestID("X-Trace-Id"), http.Header{"X-Trace-Id": {"exist"}}, ContextWithRequestID(context.Background(), "id"), http.Header{"X-Trace-Id": {"exist"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			req := (&http.Request{Method: http.MethodGet, URL: u, Header: tt.header}).WithContext(ctx)
+			var got http.Header
+			transport := tt.middleware(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				got = req.Header
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}))
+			_, err := transport.RoundTrip(req)
+			assert.Nil(t, err)
+			for k, v := range tt.want {
+				assert.Equal(t, v, got[k], k)
+			}
+			// 不修改传入的请求
+			assert.Equal(t, tt.header, req.Header)
+		})
+	}
+}
+
+func TestMiddleware_httpClientRedirect(t *testing.T) {
+	u, _ := url.Parse("http://cdn.test.com/path")
+	origin, _ := url.Parse("http://api.test.com/path")
+	// http.Client 跟随重定向时，请求的 Response 为上一个响应
+	req := &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{},
+		Response: &http.Response{Request: &http.Request{URL: origin}}}
+	var got http.Header
+	transport := BearerAuth("token")(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		got = req.Header
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	_, err := transport.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "", got.Get("Authorization"))
+}
+
+func TestClient_MiddlewareCrossHostRedirect(t *testing.T) {
+	target := httptestutil.NewServer()
+	defer target.Close()
+	target.Handle("/ok", httptestutil.Response{Body: []byte("ok")})
+	origin := httptestutil.NewServer()
+	defer origin.Close()
+	origin.Handle("/", httptestutil.Response{Redirect: target.URLFor("/ok")})
+
+	client := NewClient(WithMiddleware(BearerAuth("token"), BasicAuth("user", "pass")))
+	resp, _, err := client.TryCountGetRespRedirect(context.Background(), http.MethodGet, origin.URLFor("/"), nil, nil)
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+	}
+	if assert.Equal(t, 1, origin.Count("/")) && assert.Equal(t, 1, target.Count("/ok")) {
+		assert.Equal(t, "Bearer token", origin.Requests("/")[0].Header.Get("Authorization"))
+		// 重定向到其他 host 时不设置 Authorization
+		assert.Equal(t, "", target.Requests("/ok")[0].Header.Get("Authorization"))
+	}
+}
+
+func TestClient_Middleware(t *testing.T) {
+	var requestIDs, auths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs = append(requestIDs, r.Header.Get(DefaultRequestIDHeader))
+		auths = append(auths, r.Header.Get("Authorization"))
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/ok", http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	client := NewClient(WithMiddleware(Logging(), RequestID("")), WithMiddleware(BearerAuth("token", serverURL.Host)))
+	resp, _, err := client.TryCountGetRespRedirect(context.Background(), http.MethodGet, server.URL, nil, nil)
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+	}
+	assert.Equal(t, []string{"Bearer token", "Bearer token"}, auths)
+	// 没有设置 ctx 时每个请求生成新的 ID
+	if assert.Equal(t, 2, len(requestIDs)) {
+		assert.NotEqual(t, "", requestIDs[0])
+		assert.NotEqual(t, requestIDs[0], requestIDs[1])
+	}
+
+	requestIDs = nil
+	ctx := ContextWithRequestID(context.Background(), "id")
+	resp, _, err = client.TryCountGetRespRedirect(ctx, http.MethodGet, server.URL, nil, nil)
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+	}
+	assert.Equal(t, []string{"id", "id"}, requestIDs)
+}
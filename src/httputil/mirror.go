@@ -0,0 +1,289 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-utils/src/logs"
+)
+
+// defaultSpeedWindow 计算下载速度的默认时间窗口
+const defaultSpeedWindow = 5 * time.Second
+
+var (
+	// ErrNoMirror 没有可用的镜像地址
+	ErrNoMirror = errors.New("no mirror url")
+	// ErrMirrorMismatch 镜像返回的文件大小或 ETag 与基准镜像不一致
+	ErrMirrorMismatch = errors.New("mirror mismatch")
+	// ErrSlowMirror 镜像的下载速度低于 DownloadOptions.MinSpeed
+	ErrSlowMirror = errors.New("mirror too slow")
+
+	// errWriteFile 写本地文件失败，切换镜像也无法解决
+	errWriteFile = errors.New("write file fail")
+)
+
+// canFailover 判断 err 是否可以通过切换镜像解决
+func canFailover(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return !errors.Is(err, ErrRemoteChanged) && !errors.Is(err, ErrChecksumMismatch) && !errors.Is(err, errWriteFile)
+}
+
+// parseContentRangeSize 解析 Content-Range 中的文件大小，比如 "bytes 3600-5000/5000"
+func parseContentRangeSize(contentRange string) (int64, bool) {
+	index := strings.LastIndex(contentRange, "/")
+	if index < 0 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(contentRange[index+1:], 10, 64)
+	return size, err == nil
+}
+
+// DoMirrors 按顺序尝试 urls 中的镜像发送请求，请求失败(包括重试后仍失败及 4xx/5xx)时切换到下一个镜像，
+// 返回第一个成功的响应，都失败时返回最后一个错误。ctx 取消时不再尝试后续镜像
+func (c *Client) DoMirrors(ctx context.Context, method string, urls []string, header http.Header, data []byte) (*Response, error) {
+	err := ErrNoMirror
+	for i, url := range urls {
+		var r *Response
+		if r, err = c.Do(ctx, method, url, header, data); err == nil {
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if i < len(urls)-1 {
+			logs.Log.Wainf("%v %v fail, try next mirror: %v", method, url, err)
+		}
+	}
+	return nil, err
+}
+
+// speedLimit 下载速度下限
+type speedLimit struct {
+	min    int64
+	window time.Duration
+}
+
+// speedLimit 只有多个镜像时才检测速度，只有一个镜像时切换不了
+func (o *DownloadOptions) speedLimit(mirrors int) *speedLimit {
+	if o == nil || o.MinSpeed <= 0 || mirrors < 2 {
+		return nil
+	}
+	window := o.SpeedWindow
+	if window <= 0 {
+		window = defaultSpeedWindow
+	}
+	return &speedLimit{min: o.MinSpeed, window: window}
+}
+
+// speedWatch 每个时间窗口检查一次读取的字节数，低于下限时取消请求
+type speedWatch struct {
+	n    int64 // 当前窗口内读取的字节数
+	slow int32
+	done chan struct{}
+}
+
+// watch 开始检测速度，速度过慢时调用 cancel，s 为 nil 时返回 nil，nil *speedWatch 的方法都可以安全调用
+func (s *speedLimit) watch(cancel context.CancelFunc) *speedWatch {
+	if s == nil {
+		return nil
+	}
+	w := &speedWatch{done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(s.window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				n := atomic.SwapInt64(&w.n, 0)
+				if float64(n)/s.window.Seconds() < float64(s.min) {
+					atomic.StoreInt32(&w.slow, 1)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return w
+}
+
+// reader 统计从 r 读取的字节数
+func (w *speedWatch) reader(r io.Reader) io.Reader {
+	if w == nil {
+		return r
+	}
+	return &countReader{r: r, n: &w.n}
+}
+
+func (w *speedWatch) stop() {
+	if w != nil {
+		close(w.done)
+	}
+}
+
+// isSlow 是否因为速度过慢取消了请求
+func (w *speedWatch) isSlow() bool {
+	return w != nil && atomic.LoadInt32(&w.slow) == 1
+}
+
+// countReader 原子地累加读取的字节数
+type countReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// consistentWith 判断镜像 m 与基准镜像 ref 的内容是否一致，两者都有 ETag 时要求 ETag 相同
+func (m *rangeInfo) consistentWith(ref *rangeInfo) bool {
+	if m.fileSize != ref.fileSize {
+		return false
+	}
+	return m.etag == "" || ref.etag == "" || m.etag == ref.etag
+}
+
+// probeMirrors 并发探测所有镜像，返回支持 Range 且内容一致的镜像，基准镜像在第一个；没有支持 Range 的镜像时
+// 返回可以单连接下载的 url。cp 不为 nil 时使用与续传记录一致的镜像作为基准，都不一致时返回 ErrRemoteChanged
+func (c *Client) probeMirrors(ctx context.Context, urls []string, cp *checkpoint) ([]*rangeInfo, []string, error) {
+	infos := make([]*rangeInfo, len(urls))
+	errs := make([]error, len(urls))
+	var wg sync.WaitGroup
+	for i := range urls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			infos[i], errs[i] = c.getRangeInfo(ctx, urls[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var ranged []*rangeInfo
+	var streams []string
+	err := ErrNoMirror
+	for i, url := range urls {
+		switch {
+		case errs[i] == nil:
+			ranged = append(ranged, infos[i])
+		case errors.Is(errs[i], ErrNotSupportRange):
+			streams = append(streams, url)
+		default:
+			logs.Log.Wainf("probe mirror %v fail: %v", url, errs[i])
+			err = errs[i]
+		}
+	}
+	if len(ranged) == 0 {
+		if len(streams) == 0 {
+			if len(urls) > 1 {
+				err = fmt.Errorf("all %d mirrors fail, last error: %w", len(urls), err)
+			}
+			return nil, nil, err
+		}
+		return nil, streams, nil
+	}
+
+	ref := 0
+	if cp != nil {
+		ref = -1
+		for i, info := range ranged {
+			if cp.sameRemote(info) {
+				ref = i
+				break
+			}
+		}
+		if ref < 0 {
+			return nil, nil, fmt.Errorf("%w, no mirror matches checkpoint of %v", ErrRemoteChanged, cp.URL)
+		}
+	}
+	mirrors := []*rangeInfo{ranged[ref]}
+	for i, info := range ranged {
+		if i == ref {
+			continue
+		}
+		if !info.consistentWith(ranged[ref]) {
+			logs.Log.Wainf("%v, skip %v: size=%v etag=%v, want size=%v etag=%v", ErrMirrorMismatch, info.redirectURL,
+				info.fileSize, info.etag, ranged[ref].fileSize, ranged[ref].etag)
+			continue
+		}
+		mirrors = append(mirrors, info)
+	}
+	return mirrors, nil, nil
+}
+
+// downloadStreamMirrors 按顺序尝试镜像单连接下载
+func (c *Client) downloadStreamMirrors(ctx context.Context, urls []string, filePath string, opts *DownloadOptions) (int64, error) {
+	speed := opts.speedLimit(len(urls))
+	var n int64
+	var err error
+	for i, url := range urls {
+		if n, err = c.downloadStream(ctx, url, filePath, opts, speed); err == nil {
+			return n, nil
+		}
+		if !canFailover(ctx, err) || i == len(urls)-1 {
+			break
+		}
+		logs.Log.Wainf("download %v fail, try next mirror: %v", url, err)
+	}
+	return n, err
+}
+
+// DownloadMirrors 从多个内容相同的镜像下载到本地 filePath，返回文件大小，urls 按优先级排列。
+// 先并发探测所有镜像，文件大小或 ETag 与基准镜像(第一个可用的镜像)不一致的镜像会被忽略；
+// 分片轮流从各个镜像下载，某个镜像失败或速度低于 opts.MinSpeed 时，从已下载的位置切换到下一个镜像继续。
+// 所有镜像都不支持 Range 时按顺序尝试单连接下载。其他行为同 Download
+func (c *Client) DownloadMirrors(ctx context.Context, urls []string, filePath string, opts *DownloadOptions) (fileSize int64, err error) {
+	// 探测失败(超时、5xx 等)时同样保留续传记录，只有远端文件变化或摘要校验失败时才删除
+	keepPartial := opts.resumable()
+	defer func() {
+		if err != nil && (!keepPartial || errors.Is(err, ErrRemoteChanged) || errors.Is(err, ErrChecksumMismatch)) {
+			os.Remove(filePath)
+			os.Remove(getCheckpointPath(filePath))
+		}
+	}()
+
+	var cp *checkpoint
+	if opts.resumable() {
+		cp = loadCheckpoint(filePath)
+	}
+	mirrors, streams, err := c.probeMirrors(ctx, urls, cp)
+	if err != nil {
+		return 0, err
+	}
+	if len(mirrors) == 0 {
+		// 单连接下载会覆盖本地文件，失败时不能续传
+		keepPartial = false
+		logs.Log.Infof("%v not support range, download with single stream", streams)
+		return c.downloadStreamMirrors(ctx, streams, filePath, opts)
+	}
+
+	if err = c.downloadRanges(ctx, filePath, mirrors, opts); err != nil {
+		logs.Log.Errorf("download %v => %v fail, err:%+v", urls, filePath, err)
+		return 0, err
+	}
+	return mirrors[0].fileSize, nil
+}
+
+// DoMirrors 使用默认 Client 按顺序尝试镜像发送请求，参见 Client.DoMirrors
+func DoMirrors(ctx context.Context, method string, urls []string, header http.Header, data []byte) (*Response, error) {
+	return defaultClient().DoMirrors(ctx, method, urls, header, data)
+}
+
+// DownloadMirrors 使用默认 Client 从多个镜像下载，参见 Client.DownloadMirrors
+func DownloadMirrors(ctx context.Context, urls []string, filePath string, opts *DownloadOptions) (int64, error) {
+	return defaultClient().DownloadMirrors(ctx, urls, filePath, opts)
+}
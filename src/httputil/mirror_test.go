@@ -0,0 +1,149 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"go-utils/src/httputil/httptestutil"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseContentRangeSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentRange string
+		want         int64
+		wantOK       bool
+	}{
+		{"normal", "bytes 0-1/5000", 5000, true},
+		{"unknown_size", "bytes 0-1/*", 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseContentRangeSize(tt.contentRange)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func TestClient_DoMirrors(t *testing.T) {
+	server := httptestutil.NewServer()
+	defer server.Close()
+	server.Handle("/not_found", httptestutil.Response{Status: http.StatusNotFound})
+	server.Handle("/unavailable", httptestutil.Response{Status: http.StatusServiceUnavailable})
+	server.Handle("/ok", httptestutil.Response{Body: []byte("ok")})
+	client := NewClient(WithRetry(1, 0))
+
+	tests := []struct {
+		name       string
+		paths      []string
+		wantErr    error
+		wantStatus int
+	}{
+		{"first_ok", []string{"/ok", "/not_found"}, nil, http.StatusOK},
+		{"failover", []string{"/not_found", "/unavailable", "/ok"}, nil, http.StatusOK},
+		{"all_fail", []string{"/ok_missing", "/unavailable"}, nil, http.StatusServiceUnavailable},
+		{"empty", nil, ErrNoMirror, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var urls []string
+			for _, p := range tt.paths {
+				urls = append(urls, server.URLFor(p))
+			}
+			r, err := client.DoMirrors(context.Background(), http.MethodGet, urls, nil, nil)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "%v", err)
+				return
+			}
+			if tt.wantStatus != http.StatusOK {
+				assert.Equal(t, tt.wantStatus, StatusCode(err))
+				return
+			}
+			if assert.Nil(t, err) {
+				data, _ := ioutil.ReadAll(r.Body)
+				r.Body.Close()
+				assert.Equal(t, "ok", string(data))
+			}
+		})
+	}
+}
+
+func TestClient_DownloadMirrors(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	other := []byte(strings.Repeat("9876543210", 1001))
+	etag := http.Header{"ETag": {`"v1"`}}
+	good := httptestutil.Response{Range: true, Header: etag, Body: content}
+	tests := []struct {
+		name      string
+		mirrors   [][]httptestutil.Response
+		opts      *DownloadOptions
+		wantErr   bool
+		wantParts []int // 每个镜像收到的分片请求数(不包含探测请求)，-1 表示不检查
+	}{
+		{"spread", [][]httptestutil.Response{{good}, {good}}, nil, false, []int{5, 5}},
+		{"first_down", [][]httptestutil.Response{{{Status: http.StatusNotFound}}, {good}}, nil, false, []int{-1, 10}},
+		{"size_mismatch", [][]httptestutil.Response{{good}, {{Range: true, Header: etag, Body: other}}}, nil, false, []int{10, 0}},
+		{"etag_mismatch", [][]httptestutil.Response{{good}, {{Range: true, Header: http.Header{"ETag": {`"v2"`}}, Body: content}}}, nil, false, []int{10, 0}},
+		{"drop_failover", [][]httptestutil.Response{{{Range: true, Header: etag, Body: content, DropAfter: 500}}, {good}}, nil, false, []int{5, 10}},
+		{"slow_failover", [][]httptestutil.Response{{{Range: true, Header: etag, Body: content, ChunkSize: 10, ChunkDelay: 50 * time.Millisecond}}, {good}},
+			&DownloadOptions{MinSpeed: 10 << 10, SpeedWindow: 100 * time.Millisecond}, false, []int{5, 10}},
+		{"stream_failover", [][]httptestutil.Response{{{Body: content, DropAfter: 500}}, {{Body: content}}}, nil, false, []int{-1, -1}},
+		{"all_fail", [][]httptestutil.Response{{{Status: http.StatusNotFound}}, {{Status: http.StatusNotFound}}}, nil, true, []int{-1, -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var servers []*httptestutil.Server
+			var urls []string
+			for _, responses := range tt.mirrors {
+				server := httptestutil.NewServer()
+				defer server.Close()
+				server.Handle("/file", responses...)
+				servers = append(servers, server)
+				urls = append(urls, server.URLFor("/file"))
+			}
+			opts := &DownloadOptions{ConcurrencyNum: 2, PartSize: 1000}
+			if tt.opts != nil {
+				opts.MinSpeed, opts.SpeedWindow = tt.opts.MinSpeed, tt.opts.SpeedWindow
+			}
+			filePath := path.Join(os.TempDir(), uuid.New().String())
+			defer os.Remove(filePath)
+
+			client := NewClient(WithRetry(1, 0))
+			got, err := client.DownloadMirrors(context.Background(), urls, filePath, opts)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, int64(len(content)), got)
+			data, _ := ioutil.ReadFile(filePath)
+			assert.Equal(t, content, data)
+			for i, want := range tt.wantParts {
+				if want < 0 {
+					continue
+				}
+				parts := 0
+				for _, r := range servers[i].Requests("/file") {
+					if r.Header.Get("Range") != "bytes=0-1" {
+						parts++
+					}
+				}
+				assert.Equal(t, want, parts, "mirror %d", i)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package httputil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go-utils/src/bandwidth"
+	"go-utils/src/logs"
+	"go-utils/src/progress"
+)
+
+// FormFile multipart 表单中的文件
+type FormFile struct {
+	FieldName string // 表单字段名
+	FilePath  string // 本地文件路径
+	FileName  string // 上传使用的文件名，为空时使用 FilePath 的文件名
+}
+
+func (f *FormFile) getFileName() string {
+	if f.FileName == "" {
+		return filepath.Base(f.FilePath)
+	}
+	return f.FileName
+}
+
+// MultipartForm multipart/form-data 表单
+type MultipartForm struct {
+	Files    []FormFile         // 文件，按顺序写入
+	Fields   map[string]string  // 普通表单字段，按字段名排序后写在文件之前
+	Progress *progress.Observer // 上传进度回调，只统计文件内容，重试时从 0 开始
+	// BytesPerSecond 本次上传的限速，单位字节/秒，只限制文件内容，0 表示不限速
+	BytesPerSecond int64
+	// Bandwidth 多个上传共享的限速，与 BytesPerSecond 及 WithBandwidth 同时生效
+	Bandwidth *bandwidth.Limiter
+}
+
+// multipartBody 流式 multipart body，通过 io.Pipe 边读文件边发送，每次 open 都会重新打开文件以支持重试
+type multipartBody struct {
+	ctx      context.Context
+	form     *MultipartForm
+	boundary string
+	total    int64 // 文件内容的总大小
+	size     int64 // 整个 body 的大小，包括分隔符、part 头及字段，未知时为 -1
+	limiters []*bandwidth.Limiter
+}
+
+func newMultipartBody(ctx context.Context, form *MultipartForm, limiters ...*bandwidth.Limiter) (*multipartBody, error) {
+	body := &multipartBody{
+		ctx:      ctx,
+		form:     form,
+		boundary: multipart.NewWriter(ioutil.Discard).Boundary(),
+		limiters: limiters,
+	}
+	for _, f := range form.Files {
+		fi, err := os.Stat(f.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil, fmt.Errorf("%s is not a regular file", f.FilePath)
+		}
+		body.total += fi.Size()
+	}
+	body.size = body.computeSize()
+	return body, nil
+}
+
+// countWriter 只统计写入的字节数
+type countWriter int64
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	*w += countWriter(len(p))
+	return len(p), nil
+}
+
+// computeSize 写入不包含文件内容的表单，加上文件大小得到 body 的大小，避免以 chunked 方式上传，
+// 部分服务及对象存储的预签名地址不接受 chunked 上传
+func (b *multipartBody) computeSize() int64 {
+	var counter countWriter
+	if err := b.write(&counter, nil); err != nil {
+		return -1
+	}
+	return int64(counter) + b.total
+}
+
+// contentLength 实现 sizedBody
+func (b *multipartBody) contentLength() int64 {
+	return b.size
+}
+
+// contentType multipart 请求的 Content-Type
+func (b *multipartBody) contentType() string {
+	return "multipart/form-data; boundary=" + b.boundary
+}
+
+func (b *multipartBody) open() (io.Reader, error) {
+	pr, pw := io.Pipe()
+	tracker := progress.NewTracker(b.total, b.form.Progress)
+	go func() {
+		err := b.write(pw, tracker)
+		if err == nil {
+			tracker.Finish()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// write 依次写入表单字段和文件内容，tracker 为 nil 时不写入文件内容，只用于计算大小
+func (b *multipartBody) write(w io.Writer, tracker *progress.Tracker) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(b.boundary); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(b.form.Fields))
+	for k := range b.form.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := writer.WriteField(k, b.form.Fields[k]); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range b.form.Files {
+		if err := b.writeFile(writer, &f, tracker); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func (b *multipartBody) writeFile(writer *multipart.Writer, f *FormFile, tracker *progress.Tracker) error {
+	formFile, err := writer.CreateFormFile(f.FieldName, f.getFileName())
+	if err != nil {
+		return fmt.Errorf("create form file failed: %v, %w", f.FilePath, err)
+	}
+	if tracker == nil {
+		return nil
+	}
+	srcFile, err := os.Open(f.FilePath)
+	if err != nil {
+		return fmt.Errorf("open source file failed: %v, %w", f.FilePath, err)
+	}
+	defer srcFile.Close()
+	if _, err = io.Copy(formFile, tracker.Reader(bandwidth.NewReader(b.ctx, srcFile, b.limiters...))); err != nil {
+		return fmt.Errorf("write to form file failed: %v, %w", f.FilePath, err)
+	}
+	return nil
+}
+
+func (b *multipartBody) String() string {
+	names := make([]string, 0, len(b.form.Files))
+	for _, f := range b.form.Files {
+		names = append(names, f.FilePath)
+	}
+	return fmt.Sprintf("multipart%v", names)
+}
+
+// PostMultipart 以 multipart/form-data 流式上传多个文件及表单字段，返回响应内容。
+// 文件内容边读边发送，不会整体读入内存；根据文件大小预先计算并设置 Content-Length，不使用 chunked 编码；重试时会重新打开文件。
+func (c *Client) PostMultipart(ctx context.Context, url string, form *MultipartForm, header http.Header) ([]byte, error) {
+	body, err := newMultipartBody(ctx, form, c.bandwidth, bandwidth.NewLimiter(form.BytesPerSecond), form.Bandwidth)
+	if err != nil {
+		errWarp := fmt.Errorf("open source file failed: %v, %w", url, err)
+		logs.Log.Error(errWarp)
+		return nil, errWarp
+	}
+
+	header = header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Type", body.contentType())
+	resp, err := c.tryCountGetRespRedirect(ctx, http.MethodPost, url, header, body)
+	if err != nil {
+		errWarp := fmt.Errorf("TryCountGetRespRedirect fail: %v %s, %w", url, body, err)
+		logs.Log.Error(errWarp)
+		return nil, errWarp
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// PostMultipart 使用默认 Client 流式上传多个文件及表单字段
+func PostMultipart(ctx context.Context, url string, form *MultipartForm, header http.Header) ([]byte, error) {
+	return defaultClient().PostMultipart(ctx, url, form, header)
+}
@@ -0,0 +1,160 @@
+package httputil
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-utils/src/bandwidth"
+	"go-utils/src/httputil/httptestutil"
+	"go-utils/src/progress"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMultipartServer 解析 multipart 请求，返回 "字段=值;" 及 "文件字段:文件名=内容;"，failFirst 时第一次请求返回 503，
+// 与大多数对象存储一致，拒绝 chunked 上传
+func newMultipartServer(failFirst bool) (*httptest.Server, *int32) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 && failFirst {
+			ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if len(r.TransferEncoding) != 0 || r.ContentLength <= 0 {
+			w.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+		reader, err := r.MultipartReader()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			data, _ := ioutil.ReadAll(part)
+			if part.FileName() == "" {
+				fmt.Fprintf(w, "%s=%s;", part.FormName(), data)
+			} else {
+				fmt.Fprintf(w, "%s:%s=%s;", part.FormName(), part.FileName(), data)
+			}
+		}
+	}))
+	return server, &count
+}
+
+func TestClient_PostMultipart(t *testing.T) {
+	fileA := path.Join(os.TempDir(), uuid.New().String()+".txt")
+	fileB := path.Join(os.TempDir(), uuid.New().String()+".txt")
+	if err := ioutil.WriteFile(fileA, []byte("aaa"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fileA)
+	if err := ioutil.WriteFile(fileB, []byte("bbbbb"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fileB)
+
+	server, count := newMultipartServer(true)
+	defer server.Close()
+
+	var last progress.Progress
+	form := &MultipartForm{
+		Files: []FormFile{
+			{FieldName: "a", FilePath: fileA},
+			{FieldName: "b", FilePath: fileB, FileName: "b.txt"},
+		},
+		Fields:   map[string]string{"title": "test", "desc": "hello"},
+		Progress: &progress.Observer{Interval: -1, Func: func(p progress.Progress) { last = p }},
+	}
+	header := http.Header{}
+	client := NewClient(WithRetry(2, 0))
+	got, err := client.PostMultipart(context.Background(), server.URL, form, header)
+	assert.Nil(t, err)
+	want := fmt.Sprintf("desc=hello;title=test;a:%s=aaa;b:b.txt=bbbbb;", path.Base(fileA))
+	assert.Equal(t, want, string(got))
+	assert.Equal(t, int32(2), atomic.LoadInt32(count))
+	assert.Equal(t, int64(8), last.Done)
+	assert.Equal(t, int64(8), last.Total)
+	// 不修改调用者的 header
+	assert.Equal(t, "", header.Get("Content-Type"))
+
+	form.Files = append(form.Files, FormFile{FieldName: "c", FilePath: path.Join(os.TempDir(), uuid.New().String())})
+	_, err = client.PostMultipart(context.Background(), server.URL, form, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(count))
+}
+
+func Test_multipartBody_open(t *testing.T) {
+	file := path.Join(os.TempDir(), uuid.New().String())
+	if err := ioutil.WriteFile(file, []byte(strings.Repeat("x", 1<<20)), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	body, err := newMultipartBody(context.Background(), &MultipartForm{Files: []FormFile{{FieldName: "f", FilePath: file}}})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, int64(1<<20), body.total)
+	assert.Greater(t, body.contentLength(), body.total)
+
+	// 每次 open 都得到完整的内容
+	for i := 0; i < 2; i++ {
+		reader, err := body.open()
+		assert.Nil(t, err)
+		data, err := ioutil.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.True(t, strings.Contains(string(data), body.boundary))
+		assert.Equal(t, body.contentLength(), int64(len(data)))
+	}
+
+	// 提前关闭读端，写入协程退出
+	reader, _ := body.open()
+	closeBody(reader)
+}
+
+func TestClient_PostMultipartBandwidth(t *testing.T) {
+	file := path.Join(os.TempDir(), uuid.New().String())
+	if err := ioutil.WriteFile(file, make([]byte, 120<<10), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	server := httptestutil.NewServer()
+	defer server.Close()
+	server.Handle("/upload", httptestutil.Response{Body: []byte("ok")})
+
+	tests := []struct {
+		name    string
+		client  *Client
+		form    *MultipartForm
+		minTime time.Duration
+	}{
+		{"per_upload", NewClient(), &MultipartForm{BytesPerSecond: 100 << 10}, 150 * time.Millisecond},
+		{"shared", NewClient(), &MultipartForm{Bandwidth: bandwidth.NewLimiter(100 << 10)}, 150 * time.Millisecond},
+		{"client", NewClient(WithBandwidth(bandwidth.NewLimiter(100 << 10))), &MultipartForm{}, 150 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.form.Files = []FormFile{{FieldName: "f", FilePath: file}}
+			start := time.Now()
+			got, err := tt.client.PostMultipart(context.Background(), server.URLFor("/upload"), tt.form, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, "ok", string(got))
+			assert.GreaterOrEqual(t, time.Since(start), tt.minTime)
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package httputil
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go-utils/src/bandwidth"
+)
+
+// RateLimiter 按请求数限速的令牌桶，可以在多个协程中同时使用，与下载限速共用 bandwidth.Limiter 的实现
+type RateLimiter struct {
+	bucket *bandwidth.Limiter
+}
+
+// NewRateLimiter 构造令牌桶，rate 为每秒允许的请求数，burst 为允许的突发请求数(至少为 1)，初始时桶是满的
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{bucket: bandwidth.NewLimiterWithBurst(rate, float64(burst))}
+}
+
+// Wait 等待直到获得一个令牌，返回等待的时间；ctx 结束或 deadline 早于可用时间时返回错误，不消耗令牌
+func (l *RateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	now := time.Now()
+	wait := l.bucket.ReserveN(now, 1)
+	if wait <= 0 {
+		return 0, nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(wait)) {
+		l.bucket.CancelN(1)
+		return 0, fmt.Errorf("rate limit wait %v exceeds ctx deadline: %w", wait, context.DeadlineExceeded)
+	}
+	if err := sleepContext(ctx, wait); err != nil {
+		l.bucket.CancelN(1)
+		return time.Since(now), err
+	}
+	return wait, nil
+}
+
+// ThrottleStats 限速等待的统计
+type ThrottleStats struct {
+	Requests int64         // 经过限速器的请求数
+	Waits    int64         // 需要等待的请求数
+	WaitTime time.Duration // 等待的总时间
+}
+
+// 按 host 懒加载的限速器及统计的清理参数
+const (
+	hostIdleTimeout   = 10 * time.Minute // 闲置超过该时间的 host 被清理
+	hostSweepInterval = time.Minute      // 两次清理的最小间隔
+)
+
+// rateLimits Client 的全局及按 host 的限速器。访问过的 host 都会有懒加载的限速器及统计，
+// 闲置超过 hostIdleTimeout 后清理，避免爬虫等访问大量 host 的 Client 无限增长
+type rateLimits struct {
+	global    *RateLimiter
+	perHost   func() *RateLimiter     // 每个 host 默认的限速器构造函数，nil 表示不限制
+	hosts     map[string]*RateLimiter // 指定 host 的限速器，不会被清理
+	mu        sync.Mutex
+	lazy      map[string]*RateLimiter // 其他 host 使用 perHost 懒加载的限速器
+	stats     map[string]*ThrottleStats
+	lastUse   map[string]time.Time // 每个 host 最后一次请求的时间
+	lastSweep time.Time
+}
+
+func (r *rateLimits) setHost(host string, limiter *RateLimiter) {
+	if r.hosts == nil {
+		r.hosts = make(map[string]*RateLimiter)
+	}
+	r.hosts[strings.ToLower(host)] = limiter
+}
+
+// touch 记录 host 的使用时间，并清理闲置的 host，需要持有 r.mu
+func (r *rateLimits) touch(host string, now time.Time) {
+	if r.lastUse == nil {
+		r.lastUse = make(map[string]time.Time)
+	}
+	r.lastUse[host] = now
+	if now.Sub(r.lastSweep) < hostSweepInterval {
+		return
+	}
+	r.lastSweep = now
+	for h, last := range r.lastUse {
+		// 闲置足够久的令牌桶通常已经回满，清理后重新构造的效果相同
+		if now.Sub(last) > hostIdleTimeout {
+			delete(r.lastUse, h)
+			delete(r.lazy, h)
+			delete(r.stats, h)
+		}
+	}
+}
+
+// hostLimiter 返回 host 对应的限速器，没有时返回 nil
+func (r *rateLimits) hostLimiter(host string, now time.Time) *RateLimiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	host = strings.ToLower(host)
+	r.touch(host, now)
+	if limiter, ok := r.hosts[host]; ok {
+		return limiter
+	}
+	if r.perHost == nil {
+		return nil
+	}
+	if limiter, ok := r.lazy[host]; ok {
+		return limiter
+	}
+	if r.lazy == nil {
+		r.lazy = make(map[string]*RateLimiter)
+	}
+	limiter := r.perHost()
+	r.lazy[host] = limiter
+	return limiter
+}
+
+// wait 请求 rawURL 前先后等待全局及 host 的令牌
+func (r *rateLimits) wait(ctx context.Context, rawURL string) error {
+	if r == nil {
+		return nil
+	}
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(u.Host)
+	}
+	waitGlobal, err := r.global.Wait(ctx)
+	if err == nil {
+		var waitHost time.Duration
+		waitHost, err = r.hostLimiter(host, time.Now()).Wait(ctx)
+		waitGlobal += waitHost
+	}
+	r.record(host, waitGlobal, time.Now())
+	return err
+}
+
+func (r *rateLimits) record(host string, wait time.Duration, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.touch(host, now)
+	if r.stats == nil {
+		r.stats = make(map[string]*ThrottleStats)
+	}
+	stats, ok := r.stats[host]
+	if !ok {
+		stats = &ThrottleStats{}
+		r.stats[host] = stats
+	}
+	stats.Requests++
+	if wait > 0 {
+		stats.Waits++
+		stats.WaitTime += wait
+	}
+}
+
+// ThrottleStats 按 host 统计的限速等待情况，闲置超过 10 分钟的 host 不再统计，未配置限速时返回空
+func (c *Client) ThrottleStats() map[string]ThrottleStats {
+	result := make(map[string]ThrottleStats)
+	if c.limits == nil {
+		return result
+	}
+	c.limits.mu.Lock()
+	defer c.limits.mu.Unlock()
+	for host, stats := range c.limits.stats {
+		result[host] = *stats
+	}
+	return result
+}
@@ -0,0 +1,133 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewRateLimiter(100, 2)
+
+	// 突发的请求不需要等待
+	for i := 0; i < 2; i++ {
+		wait, err := limiter.Wait(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+
+	start := time.Now()
+	wait, err := limiter.Wait(ctx)
+	assert.Nil(t, err)
+	assert.Greater(t, wait, time.Duration(0))
+	assert.GreaterOrEqual(t, time.Since(start), 5*time.Millisecond)
+
+	// deadline 不足时直接返回且不消耗令牌
+	limiter = NewRateLimiter(1, 1)
+	limiter.Wait(ctx)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = limiter.Wait(timeoutCtx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	// 令牌未被消耗时下一个令牌约 1s 后可用，被消耗时需要约 2s
+	assert.InDelta(t, float64(time.Second), float64(limiter.bucket.ReserveN(time.Now(), 1)), float64(100*time.Millisecond))
+
+	cancelCtx, cancel2 := context.WithCancel(ctx)
+	cancel2()
+	_, err = limiter.Wait(cancelCtx)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	var nilLimiter *RateLimiter
+	wait, err = nilLimiter.Wait(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	unlimited := NewRateLimiter(0, 1)
+	for i := 0; i < 5; i++ {
+		wait, _ = unlimited.Wait(ctx)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer other.Close()
+	serverURL, _ := url.Parse(server.URL)
+	otherURL, _ := url.Parse(other.URL)
+
+	client := NewClient(WithPerHostRateLimit(1000, 10), WithHostRateLimit(serverURL.Host, 10, 1))
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		for _, u := range []string{server.URL, other.URL} {
+			resp, _, err := client.TryCountGetRespRedirect(ctx, http.MethodGet, u, nil, nil)
+			if assert.Nil(t, err) {
+				resp.Body.Close()
+			}
+		}
+	}
+	// server 每 100ms 一个请求，4 个请求至少等待 300ms
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+
+	stats := client.ThrottleStats()
+	assert.Equal(t, int64(4), stats[serverURL.Host].Requests)
+	assert.Equal(t, int64(3), stats[serverURL.Host].Waits)
+	assert.GreaterOrEqual(t, stats[serverURL.Host].WaitTime, 200*time.Millisecond)
+	assert.Equal(t, int64(4), stats[otherURL.Host].Requests)
+	assert.Equal(t, int64(0), stats[otherURL.Host].Waits)
+
+	// 等待期间 ctx 取消
+	client = NewClient(WithRateLimit(1, 1), WithRetry(3, 0))
+	resp, _, err := client.TryCountGetRespRedirect(ctx, http.MethodGet, server.URL, nil, nil)
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, _, err = client.TryCountGetRespRedirect(timeoutCtx, http.MethodGet, server.URL, nil, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	assert.Equal(t, 0, len(NewClient().ThrottleStats()))
+}
+
+func Test_rateLimits_sweep(t *testing.T) {
+	fixed := NewRateLimiter(1, 1)
+	r := &rateLimits{perHost: func() *RateLimiter { return NewRateLimiter(1, 1) }}
+	r.setHost("fixed.com", fixed)
+	now := time.Now()
+	a := r.hostLimiter("a.com", now)
+	r.record("a.com", 0, now)
+	r.hostLimiter("fixed.com", now)
+	r.record("fixed.com", 0, now)
+	assert.Same(t, a, r.hostLimiter("a.com", now.Add(time.Second)))
+
+	// 闲置超过 hostIdleTimeout 的 host 被清理，指定 host 的限速器保留
+	later := now.Add(hostIdleTimeout + 2*time.Second)
+	r.hostLimiter("b.com", later)
+	r.record("b.com", 0, later)
+	assert.Equal(t, 1, len(r.lazy))
+	assert.Equal(t, []string{"b.com"}, keys(r.stats))
+	assert.Same(t, fixed, r.hostLimiter("fixed.com", later))
+	assert.NotSame(t, a, r.hostLimiter("a.com", later))
+}
+
+func keys(m map[string]*ThrottleStats) []string {
+	var result []string
+	for k := range m {
+		result = append(result, k)
+	}
+	return result
+}
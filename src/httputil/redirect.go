@@ -0,0 +1,115 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrTooManyRedirects 重定向次数超过 maxRedirects
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// redirectCodes 需要跟随的重定向状态码
+var redirectCodes = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// Response 跟随重定向后的响应
+type Response struct {
+	*http.Response
+	Method    string   // 最后一次请求的方法，303 等重定向后可能变为 GET
+	URL       string   // 最后一次请求的 url
+	Redirects []string // 重定向经过的 url，按请求顺序排列，不包含 URL
+}
+
+// redirectMethod 按照 RFC 7231 计算重定向后的请求方法，303 除 HEAD 外改为 GET，301/302 的 POST 改为 GET
+func redirectMethod(code int, method string) string {
+	switch code {
+	case http.StatusSeeOther:
+		if method != http.MethodHead {
+			return http.MethodGet
+		}
+	case http.StatusMovedPermanently, http.StatusFound:
+		if method == http.MethodPost {
+			return http.MethodGet
+		}
+	}
+	return method
+}
+
+// sensitiveHeaders 重定向到其他 host 时需要删除的 header
+var sensitiveHeaders = []string{"Authorization", "Cookie"}
+
+// redirectHeader 返回重定向请求使用的 header，不修改传入的 header。
+// 方法改为 GET 时删除 body 相关的 header，重定向到其他 host 时删除认证相关的 header
+func redirectHeader(header http.Header, bodyDropped, crossHost bool) http.Header {
+	if !bodyDropped && !crossHost {
+		return header
+	}
+	header = header.Clone()
+	if bodyDropped {
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+	}
+	if crossHost {
+		for _, key := range sensitiveHeaders {
+			header.Del(key)
+		}
+	}
+	return header
+}
+
+// getRespRedirect 跟随重定向直到非重定向响应，状态码 >299 的响应也原样返回。
+// 相对地址的 Location 基于当前 url 解析，没有 Location 的重定向响应原样返回，超过 maxRedirects 次返回 ErrTooManyRedirects。
+// 返回的 Response 不会为 nil，出错时 Response.Response 为 nil，但 Method/URL/Redirects 仍然有效
+func (c *Client) getRespRedirect(
+	ctx context.Context,
+	method, rawURL string,
+	header http.Header,
+	body requestBody,
+) (*Response, error) {
+	r := &Response{Method: method, URL: rawURL}
+	origin, err := url.Parse(rawURL)
+	if err != nil {
+		return r, err
+	}
+	current := origin
+	// 未指定 host 的认证 Middleware 只对原始请求的 host 生效
+	ctx = contextWithOrigin(ctx, origin)
+	for i := 0; ; i++ {
+		resp, err := c.getResp(ctx, r.Method, r.URL, header, body)
+		if err != nil {
+			return r, err
+		}
+		location := resp.Header.Get("Location")
+		if !find(redirectCodes, resp.StatusCode) || location == "" {
+			r.Response = resp
+			return r, nil
+		}
+		resp.Body.Close()
+		if i >= c.maxRedirects {
+			return r, fmt.Errorf("%w, stopped after %d redirects", ErrTooManyRedirects, c.maxRedirects)
+		}
+
+		next, err := current.Parse(location)
+		if err != nil {
+			return r, fmt.Errorf("invalid redirect location %q: %w", location, err)
+		}
+		nextMethod := redirectMethod(resp.StatusCode, r.Method)
+		bodyDropped := nextMethod != r.Method && nextMethod == http.MethodGet
+		if bodyDropped {
+			body = bytesBody(nil)
+		}
+		header = redirectHeader(header, bodyDropped, !strings.EqualFold(next.Host, origin.Host))
+
+		r.Redirects = append(r.Redirects, r.URL)
+		r.Method, r.URL, current = nextMethod, next.String(), next
+	}
+}
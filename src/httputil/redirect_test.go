@@ -0,0 +1,176 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go-utils/src/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_redirectMethod(t *testing.T) {
+	tests := []struct {
+		code   int
+		method string
+		want   string
+	}{
+		{http.StatusMovedPermanently, http.MethodPost, http.MethodGet},
+		{http.StatusMovedPermanently, http.MethodPut, http.MethodPut},
+		{http.StatusFound, http.MethodPost, http.MethodGet},
+		{http.StatusFound, http.MethodGet, http.MethodGet},
+		{http.StatusSeeOther, http.MethodPut, http.MethodGet},
+		{http.StatusSeeOther, http.MethodHead, http.MethodHead},
+		{http.StatusTemporaryRedirect, http.MethodPost, http.MethodPost},
+		{http.StatusPermanentRedirect, http.MethodPost, http.MethodPost},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d_%s", tt.code, tt.method), func(t *testing.T) {
+			assert.Equal(t, tt.want, redirectMethod(tt.code, tt.method))
+		})
+	}
+}
+
+// echoHandler 返回 "方法 body Authorization Content-Type"
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	fmt.Fprintf(w, "%s %s %s %s", r.Method, body, r.Header.Get("Authorization"), r.Header.Get("Content-Type"))
+}
+
+func TestClient_Do(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer other.Close()
+
+	var loopCount int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", echoHandler)
+	mux.HandleFunc("/relative/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "../echo")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/no_location", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusFound)
+	})
+	mux.HandleFunc("/see_other", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/echo", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/echo", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/other_host", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/echo", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&loopCount, 1)
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient(WithRetry(3, 0), WithMaxRedirects(3), WithDefaultHeader(nil))
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	header.Set("Content-Type", "text/plain")
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		wantBody      string
+		wantMethod    string
+		wantURL       string
+		wantRedirects []string
+	}{
+		{"relative", http.MethodPost, "/relative/a", "POST data Bearer token text/plain",
+			http.MethodPost, server.URL + "/echo", []string{server.URL + "/relative/a"}},
+		{"see_other", http.MethodPut, "/see_other", "GET  Bearer token ",
+			http.MethodGet, server.URL + "/echo", []string{server.URL + "/see_other"}},
+		{"moved_post", http.MethodPost, "/moved", "GET  Bearer token ",
+			http.MethodGet, server.URL + "/echo", []string{server.URL + "/moved"}},
+		{"moved_put", http.MethodPut, "/moved", "PUT data Bearer token text/plain",
+			http.MethodPut, server.URL + "/echo", []string{server.URL + "/moved"}},
+		{"other_host", http.MethodPost, "/other_host", "POST data  text/plain",
+			http.MethodPost, other.URL + "/echo", []string{server.URL + "/other_host"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Do(ctx, tt.method, server.URL+tt.path, header, []byte("data"))
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			assert.Equal(t, tt.wantBody, string(body))
+			assert.Equal(t, tt.wantMethod, resp.Method)
+			assert.Equal(t, tt.wantURL, resp.URL)
+			assert.Equal(t, tt.wantRedirects, resp.Redirects)
+		})
+	}
+	// 调用者的 header 不会被修改
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+
+	// 没有 Location 的重定向响应作为失败返回
+	_, err := client.Do(ctx, http.MethodGet, server.URL+"/no_location", nil, nil)
+	assert.Equal(t, http.StatusFound, StatusCode(err))
+
+	// 重定向次数过多不重试
+	_, err = client.Do(ctx, http.MethodGet, server.URL+"/loop", nil, nil)
+	assert.True(t, errors.Is(err, ErrTooManyRedirects))
+	assert.False(t, IsRetryable(err))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&loopCount))
+	var httpErr *HTTPError
+	if assert.True(t, errors.As(err, &httpErr)) {
+		assert.Equal(t, 3, len(httpErr.Redirects))
+	}
+}
+
+func TestDo_redirect(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer other.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", echoHandler)
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/echo", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/other_host", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/echo", http.StatusTemporaryRedirect)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	maxRedirects := config.ServerCnf.ControlConfig.MaxRedirectCounts
+	config.ServerCnf.ControlConfig.MaxRedirectCounts = 1
+	defer func() {
+		config.ServerCnf.ControlConfig.MaxRedirectCounts = maxRedirects
+	}()
+
+	// 包级函数同样由 GetRespRedirect 处理重定向，跨 host 时去掉 Authorization
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	resp, err := Do(context.Background(), http.MethodPost, server.URL+"/other_host", header, []byte("data"))
+	if assert.Nil(t, err) {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, "POST data  ", string(body))
+		assert.Equal(t, other.URL+"/echo", resp.URL)
+		assert.Equal(t, []string{server.URL + "/other_host"}, resp.Redirects)
+	}
+
+	resp2, redirectURL, err := TryCountGetRespRedirect(context.Background(), http.MethodGet, server.URL+"/moved", nil, nil)
+	if assert.Nil(t, err) {
+		resp2.Body.Close()
+		assert.Equal(t, server.URL+"/echo", redirectURL)
+	}
+
+	// 超过 config 中的最大重定向次数
+	config.ServerCnf.ControlConfig.MaxRedirectCounts = 0
+	_, err = Do(context.Background(), http.MethodGet, server.URL+"/moved", nil, nil)
+	assert.True(t, errors.Is(err, ErrTooManyRedirects))
+}
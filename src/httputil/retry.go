@@ -0,0 +1,176 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-utils/src/contextutil"
+)
+
+// ExponentialBackoff 默认参数
+const (
+	defaultRetryInitialInterval = 500 * time.Millisecond
+	defaultRetryMaxInterval     = 10 * time.Second
+	defaultRetryMultiplier      = 2
+	defaultRetryJitter          = 0.2
+	defaultRetryMaxElapsedTime  = time.Minute
+	defaultRetryMaxRetryAfter   = time.Minute
+)
+
+// RetryPolicy 重试策略
+type RetryPolicy interface {
+	// Retry 第 attempt 次(从 1 开始)请求失败后判断是否需要重试，以及重试前需要等待的时间。
+	// resp 为最后一次的响应(状态码 >299，body 未读取)，网络错误时为 nil；elapsed 为从第一次请求开始已经过的时间
+	Retry(attempt int, elapsed time.Duration, resp *http.Response, err error) (wait time.Duration, retry bool)
+}
+
+// ExponentialBackoff 带随机抖动的指数退避重试策略，只重试 IsRetryableResponse 判断为可重试的错误，
+// 响应中带有 Retry-After 时以 Retry-After 为准，但不超过 MaxRetryAfter
+type ExponentialBackoff struct {
+	MaxAttempts     int           // 最大尝试次数，包含第一次请求，<=0 表示不限制次数
+	InitialInterval time.Duration // 第一次重试的等待时间
+	MaxInterval     time.Duration // 单次等待的最大时间，0 表示不限制
+	Multiplier      float64       // 每次重试等待时间的增长倍数
+	Jitter          float64       // 随机抖动比例，取值 [0, 1]，实际等待时间在 interval*(1±Jitter) 之间
+	MaxElapsedTime  time.Duration // 从第一次请求开始的最长总耗时，超过后不再重试，0 表示不限制
+	// MaxRetryAfter 响应中 Retry-After 的上限，超过时只等待 MaxRetryAfter，避免错误或恶意的 Retry-After 导致长时间等待，
+	// 0 表示使用默认的 1 分钟
+	MaxRetryAfter time.Duration
+}
+
+// NewExponentialBackoff 使用默认参数构造指数退避重试策略
+func NewExponentialBackoff(maxAttempts int) *ExponentialBackoff {
+	return &ExponentialBackoff{
+		MaxAttempts:     maxAttempts,
+		InitialInterval: defaultRetryInitialInterval,
+		MaxInterval:     defaultRetryMaxInterval,
+		Multiplier:      defaultRetryMultiplier,
+		Jitter:          defaultRetryJitter,
+		MaxElapsedTime:  defaultRetryMaxElapsedTime,
+	}
+}
+
+// NewConstantBackoff 固定间隔的重试策略，最多尝试 maxAttempts 次(至少一次)
+func NewConstantBackoff(maxAttempts int, interval time.Duration) *ExponentialBackoff {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &ExponentialBackoff{
+		MaxAttempts:     maxAttempts,
+		InitialInterval: interval,
+		MaxInterval:     interval,
+		Multiplier:      1,
+	}
+}
+
+// Retry 实现 RetryPolicy 接口
+func (b *ExponentialBackoff) Retry(attempt int, elapsed time.Duration, resp *http.Response, err error) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempt >= b.MaxAttempts {
+		return 0, false
+	}
+	if !IsRetryableResponse(resp, err) {
+		return 0, false
+	}
+	wait := b.backoff(attempt)
+	retryAfter, ok := parseRetryAfter(resp, time.Now())
+	if ok {
+		wait = retryAfter
+	}
+	// Retry-After 超过剩余的总耗时时不再等待，直接返回错误
+	if b.MaxElapsedTime > 0 && elapsed+wait > b.MaxElapsedTime {
+		return 0, false
+	}
+	if ok && wait > b.maxRetryAfter() {
+		wait = b.maxRetryAfter()
+	}
+	return wait, true
+}
+
+func (b *ExponentialBackoff) maxRetryAfter() time.Duration {
+	if b.MaxRetryAfter <= 0 {
+		return defaultRetryMaxRetryAfter
+	}
+	return b.MaxRetryAfter
+}
+
+// backoff 第 attempt 次失败后的等待时间
+func (b *ExponentialBackoff) backoff(attempt int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	interval := float64(b.InitialInterval) * math.Pow(multiplier, float64(attempt-1))
+	if b.MaxInterval > 0 && interval > float64(b.MaxInterval) {
+		interval = float64(b.MaxInterval)
+	}
+	if b.Jitter > 0 {
+		jitter := math.Min(b.Jitter, 1)
+		interval *= 1 + jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(interval)
+}
+
+// IsRetryableResponse 判断请求失败是否可以重试：网络错误、408、429 及 5xx(501 除外) 可以重试，
+// ctx 结束、重定向次数过多及其他 4xx 不重试
+func IsRetryableResponse(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+			!errors.Is(err, ErrTooManyRedirects)
+	}
+	if resp == nil {
+		return false
+	}
+	return isRetryableStatus(resp.StatusCode)
+}
+
+// isRetryableStatus 408、429 及 5xx(501 除外) 可以重试
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented:
+		return false
+	}
+	return code >= 500
+}
+
+// parseRetryAfter 解析 Retry-After 头，支持秒数和 HTTP 时间两种格式
+func parseRetryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if t.Before(now) {
+			return 0, true
+		}
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// sleepContext 等待 d 时间，期间 ctx 结束则立即返回 ctx.Err()
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return contextutil.CheckCtx(ctx)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,209 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"go-utils/src/httputil/httptestutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableResponse(t *testing.T) {
+	type args struct {
+		resp *http.Response
+		err  error
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{"network_error", args{nil, io.ErrUnexpectedEOF}, true},
+		{"ctx_canceled", args{nil, context.Canceled}, false},
+		{"ctx_deadline", args{nil, context.DeadlineExceeded}, false},
+		{"nil", args{nil, nil}, false},
+		{"400", args{&http.Response{StatusCode: 400}, nil}, false},
+		{"404", args{&http.Response{StatusCode: 404}, nil}, false},
+		{"408", args{&http.Response{StatusCode: 408}, nil}, true},
+		{"429", args{&http.Response{StatusCode: 429}, nil}, true},
+		{"500", args{&http.Response{StatusCode: 500}, nil}, true},
+		{"501", args{&http.Response{StatusCode: 501}, nil}, false},
+		{"503", args{&http.Response{StatusCode: 503}, nil}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryableResponse(tt.args.resp, tt.args.err); got != tt.want {
+				t.Errorf("IsRetryableResponse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		resp   *http.Response
+		want   time.Duration
+		wantOk bool
+	}{
+		{"nil", nil, 0, false},
+		{"empty", newRetryAfterResp(""), 0, false},
+		{"seconds", newRetryAfterResp("120"), 2 * time.Minute, true},
+		{"date", newRetryAfterResp(now.Add(time.Minute).Format(http.TimeFormat)), time.Minute, true},
+		{"past_date", newRetryAfterResp(now.Add(-time.Minute).Format(http.TimeFormat)), 0, true},
+		{"invalid", newRetryAfterResp("soon"), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.resp, now)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseRetryAfter() = %v %v, want %v %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestExponentialBackoff_Retry(t *testing.T) {
+	policy := &ExponentialBackoff{
+		MaxAttempts:     4,
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     300 * time.Millisecond,
+		Multiplier:      2,
+		MaxElapsedTime:  time.Second,
+	}
+	resp503 := &http.Response{StatusCode: 503, Header: http.Header{}}
+	tests := []struct {
+		name      string
+		attempt   int
+		elapsed   time.Duration
+		resp      *http.Response
+		err       error
+		wantWait  time.Duration
+		wantRetry bool
+	}{
+		{"first", 1, 0, resp503, nil, 100 * time.Millisecond, true},
+		{"second", 2, 0, resp503, nil, 200 * time.Millisecond, true},
+		{"max_interval", 3, 0, resp503, nil, 300 * time.Millisecond, true},
+		{"max_attempts", 4, 0, resp503, nil, 0, false},
+		{"max_elapsed", 1, 950 * time.Millisecond, resp503, nil, 0, false},
+		{"not_retryable", 1, 0, &http.Response{StatusCode: 404}, nil, 0, false},
+		{"network_error", 1, 0, nil, errors.New("connection reset"), 100 * time.Millisecond, true},
+		{"retry_after", 1, 0, newRetryAfterResp("0"), nil, 0, true},
+		{"retry_after_too_long", 1, 0, newRetryAfterResp("10"), nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotWait, gotRetry := policy.Retry(tt.attempt, tt.elapsed, tt.resp, tt.err)
+			if gotWait != tt.wantWait || gotRetry != tt.wantRetry {
+				t.Errorf("Retry() = %v %v, want %v %v", gotWait, gotRetry, tt.wantWait, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func newRetryAfterResp(value string) *http.Response {
+	header := http.Header{}
+	header.Set("Retry-After", value)
+	return &http.Response{StatusCode: http.StatusTooManyRequests, Header: header}
+}
+
+func TestExponentialBackoff_RetryAfterCap(t *testing.T) {
+	// 固定间隔的策略没有总耗时限制，Retry-After 按照上限等待
+	policy := NewConstantBackoff(3, 0)
+	wait, retry := policy.Retry(1, 0, newRetryAfterResp("86400"), nil)
+	assert.True(t, retry)
+	assert.Equal(t, defaultRetryMaxRetryAfter, wait)
+	wait, retry = policy.Retry(1, 0, newRetryAfterResp(time.Now().Add(24*time.Hour).UTC().Format(http.TimeFormat)), nil)
+	assert.True(t, retry)
+	assert.Equal(t, defaultRetryMaxRetryAfter, wait)
+
+	policy.MaxRetryAfter = 2 * time.Second
+	wait, retry = policy.Retry(1, 0, newRetryAfterResp("1"), nil)
+	assert.True(t, retry)
+	assert.Equal(t, time.Second, wait)
+	wait, retry = policy.Retry(1, 0, newRetryAfterResp("3"), nil)
+	assert.True(t, retry)
+	assert.Equal(t, 2*time.Second, wait)
+
+	// 超过剩余的总耗时时不重试
+	policy.MaxElapsedTime = time.Minute
+	_, retry = policy.Retry(1, 30*time.Second, newRetryAfterResp("86400"), nil)
+	assert.False(t, retry)
+}
+
+func TestExponentialBackoff_backoffJitter(t *testing.T) {
+	policy := NewExponentialBackoff(0)
+	for attempt := 1; attempt < 10; attempt++ {
+		wait := policy.backoff(attempt)
+		assert.LessOrEqual(t, float64(wait), float64(policy.MaxInterval)*(1+policy.Jitter))
+		assert.GreaterOrEqual(t, float64(wait), float64(policy.InitialInterval)*(1-policy.Jitter))
+	}
+}
+
+func TestNewConstantBackoff(t *testing.T) {
+	policy := NewConstantBackoff(0, time.Second)
+	assert.Equal(t, 1, policy.MaxAttempts)
+	_, retry := policy.Retry(1, 0, nil, io.ErrUnexpectedEOF)
+	assert.False(t, retry)
+
+	policy = NewConstantBackoff(3, time.Second)
+	for attempt := 1; attempt < 3; attempt++ {
+		wait, retry := policy.Retry(attempt, 0, nil, io.ErrUnexpectedEOF)
+		assert.True(t, retry)
+		assert.Equal(t, time.Second, wait)
+	}
+}
+
+func Test_sleepContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Nil(t, sleepContext(ctx, 0))
+	assert.Nil(t, sleepContext(ctx, time.Millisecond))
+	cancel()
+	start := time.Now()
+	assert.Equal(t, context.Canceled, sleepContext(ctx, time.Hour))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, context.Canceled, sleepContext(ctx, 0))
+}
+
+func TestClient_RetryFaults(t *testing.T) {
+	server := httptestutil.NewServer()
+	defer server.Close()
+	server.Handle("/ok", httptestutil.Response{Body: []byte("ok")})
+	// 不复用连接，避免 http.Transport 自动重试影响请求计数
+	client := NewClient(WithRetry(3, 0), WithTransport(&http.Transport{DisableKeepAlives: true}))
+
+	tests := []struct {
+		name       string
+		responses  []httptestutil.Response
+		wantErr    bool
+		wantStatus int
+		wantCount  int
+	}{
+		{"abort_then_ok", append(httptestutil.Times(2, httptestutil.Response{Fault: httptestutil.FaultAbort}), httptestutil.Response{Body: []byte("ok")}), false, http.StatusOK, 3},
+		{"unavailable_then_ok", []httptestutil.Response{{Status: http.StatusServiceUnavailable}, {Body: []byte("ok")}}, false, http.StatusOK, 2},
+		{"redirect", []httptestutil.Response{{Redirect: "/ok"}}, false, http.StatusOK, 1},
+		{"not_found", []httptestutil.Response{{Status: http.StatusNotFound}}, true, http.StatusNotFound, 1},
+		{"exhausted", httptestutil.Times(3, httptestutil.Response{Status: http.StatusBadGateway}), true, http.StatusBadGateway, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := "/" + tt.name
+			server.Handle(p, tt.responses...)
+			resp, _, err := client.TryCountGetRespRedirect(context.Background(), http.MethodGet, server.URLFor(p), nil, nil)
+			assert.Equal(t, tt.wantErr, err != nil, "%v", err)
+			if err != nil {
+				assert.Equal(t, tt.wantStatus, StatusCode(err))
+			} else {
+				resp.Body.Close()
+				assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			}
+			assert.Equal(t, tt.wantCount, server.Count(p))
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Package progress 提供数据传输进度的统计和回调
+package progress
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultInterval 默认的回调间隔
+const DefaultInterval = 500 * time.Millisecond
+
+// Progress 传输进度
+type Progress struct {
+	Done    int64         // 已完成字节数，包含 Skip 的部分
+	Total   int64         // 总字节数，未知时为 -1
+	Rate    float64       // 平均速率，单位字节/秒，不包含 Skip 的部分
+	ETA     time.Duration // 预计剩余时间，无法估计时为 -1，完成时为 0
+	Elapsed time.Duration // 已用时间
+}
+
+// Percent 完成百分比，总字节数未知时返回 -1
+func (p Progress) Percent() float64 {
+	if p.Total < 0 {
+		return -1
+	}
+	if p.Total == 0 {
+		return 100
+	}
+	return float64(p.Done) * 100 / float64(p.Total)
+}
+
+// Func 进度回调函数
+type Func func(p Progress)
+
+// Observer 进度观察者，回调按 Interval 节流，传输结束调用 Tracker.Finish 时一定会回调一次
+type Observer struct {
+	Func     Func          // 回调函数，同一 Tracker 的回调不会并发执行
+	Interval time.Duration // 两次回调的最小间隔，0 使用 DefaultInterval，小于 0 时每次更新都回调
+}
+
+func (o *Observer) getInterval() time.Duration {
+	if o.Interval == 0 {
+		return DefaultInterval
+	}
+	return o.Interval
+}
+
+// Tracker 累计传输字节数并通知 Observer，可以在多个协程中同时使用
+type Tracker struct {
+	done     int64
+	skipped  int64
+	last     int64 // 上次回调的时间，UnixNano
+	finished int32
+	total    int64
+	start    time.Time
+	observer *Observer
+	mu       sync.Mutex
+	reported bool // 已回调最终进度
+}
+
+// NewTracker 构造 Tracker，total 未知时传 -1，observer 为 nil 时只统计不回调
+func NewTracker(total int64, observer *Observer) *Tracker {
+	if observer != nil && observer.Func == nil {
+		observer = nil
+	}
+	return &Tracker{total: total, start: time.Now(), observer: observer}
+}
+
+// Skip 记录无需传输的字节数，比如断点续传时已下载的部分，计入 Done 但不计入速率
+func (t *Tracker) Skip(n int64) {
+	if t == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&t.skipped, n)
+	t.Add(n)
+}
+
+// Add 增加已传输的字节数，达到总大小时也不会回调最终进度，传输结束(比如校验完成)后由调用者 Finish
+func (t *Tracker) Add(n int64) {
+	if t == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&t.done, n)
+	if t.observer == nil {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&t.last)
+	if now-last < int64(t.observer.getInterval()) || !atomic.CompareAndSwapInt64(&t.last, last, now) {
+		return
+	}
+	t.report(false)
+}
+
+// Finish 传输结束时调用，是唯一回调最终进度的地方，多次调用只生效一次
+func (t *Tracker) Finish() {
+	if t == nil || t.observer == nil || !atomic.CompareAndSwapInt32(&t.finished, 0, 1) {
+		return
+	}
+	t.report(true)
+}
+
+// Done 已完成的字节数
+func (t *Tracker) Done() int64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&t.done)
+}
+
+// Progress 当前进度
+func (t *Tracker) Progress() Progress {
+	if t == nil {
+		return Progress{Total: -1, ETA: -1}
+	}
+	p := Progress{Done: t.Done(), Total: t.total, ETA: -1}
+	p.Elapsed = time.Since(t.start)
+	if p.Elapsed > 0 {
+		p.Rate = float64(p.Done-atomic.LoadInt64(&t.skipped)) / p.Elapsed.Seconds()
+	}
+	if atomic.LoadInt32(&t.finished) == 1 || (p.Total >= 0 && p.Done >= p.Total) {
+		p.ETA = 0
+	} else if p.Total > 0 && p.Rate > 0 {
+		p.ETA = time.Duration(float64(p.Total-p.Done) / p.Rate * float64(time.Second))
+	}
+	return p
+}
+
+// report 回调当前进度，加锁保证回调串行且 Done 不回退，最终进度之后不再回调
+func (t *Tracker) report(final bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reported {
+		return
+	}
+	t.reported = final
+	t.observer.Func(t.Progress())
+}
+
+// Reader 返回读取时统计进度的 io.Reader
+func (t *Tracker) Reader(r io.Reader) io.Reader {
+	return &reader{r: r, t: t}
+}
+
+// Writer 返回写入时统计进度的 io.Writer
+func (t *Tracker) Writer(w io.Writer) io.Writer {
+	return &writer{w: w, t: t}
+}
+
+type reader struct {
+	r io.Reader
+	t *Tracker
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.t.Add(int64(n))
+	return n, err
+}
+
+type writer struct {
+	w io.Writer
+	t *Tracker
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.t.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,117 @@
+package progress
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker(t *testing.T) {
+	var last Progress
+	var mu sync.Mutex
+	tracker := NewTracker(100, &Observer{Interval: -1, Func: func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.GreaterOrEqual(t, p.Done, last.Done)
+		last = p
+	}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(ioutil.Discard, tracker.Reader(bytes.NewReader(make([]byte, 20))))
+		}()
+	}
+	wg.Wait()
+	io.Copy(tracker.Writer(ioutil.Discard), bytes.NewReader(make([]byte, 20)))
+	tracker.Finish()
+
+	assert.Equal(t, int64(100), tracker.Done())
+	assert.Equal(t, int64(100), last.Done)
+	assert.Equal(t, int64(100), last.Total)
+	assert.Equal(t, time.Duration(0), last.ETA)
+	assert.Equal(t, float64(100), last.Percent())
+}
+
+func TestTrackerInterval(t *testing.T) {
+	var reports []Progress
+	tracker := NewTracker(-1, &Observer{Interval: time.Hour, Func: func(p Progress) {
+		reports = append(reports, p)
+	}})
+	for i := 0; i < 10; i++ {
+		tracker.Add(10)
+	}
+	// 第一次更新立即回调，之后在间隔内被节流
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, int64(10), reports[0].Done)
+	assert.Equal(t, time.Duration(-1), reports[0].ETA)
+	assert.Equal(t, float64(-1), reports[0].Percent())
+
+	// 结束时回调最终进度，只回调一次
+	tracker.Finish()
+	tracker.Finish()
+	tracker.Add(10)
+	assert.Equal(t, 2, len(reports))
+	assert.Equal(t, int64(100), reports[1].Done)
+	assert.Equal(t, time.Duration(0), reports[1].ETA)
+}
+
+func TestTrackerFinish(t *testing.T) {
+	var reports []Progress
+	tracker := NewTracker(100, &Observer{Interval: time.Hour, Func: func(p Progress) {
+		reports = append(reports, p)
+	}})
+	tracker.Add(50)
+	// 达到总大小时不自动结束，调用者可能还需要校验、重命名，仍可能失败
+	tracker.Add(50)
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, int64(50), reports[0].Done)
+
+	tracker.Finish()
+	if assert.Equal(t, 2, len(reports)) {
+		assert.Equal(t, int64(100), reports[1].Done)
+		assert.Equal(t, time.Duration(0), reports[1].ETA)
+	}
+}
+
+func TestTrackerSkip(t *testing.T) {
+	var last Progress
+	tracker := NewTracker(100, &Observer{Interval: -1, Func: func(p Progress) { last = p }})
+	tracker.Skip(50)
+	assert.Equal(t, int64(50), last.Done)
+	assert.Equal(t, float64(0), last.Rate)
+	assert.Equal(t, time.Duration(-1), last.ETA)
+
+	time.Sleep(10 * time.Millisecond)
+	tracker.Add(25)
+	assert.Equal(t, int64(75), last.Done)
+	assert.Greater(t, last.Rate, float64(0))
+	assert.Greater(t, last.ETA, time.Duration(0))
+	assert.Equal(t, float64(75), last.Percent())
+}
+
+func TestTrackerNil(t *testing.T) {
+	var tracker *Tracker
+	tracker.Add(10)
+	tracker.Finish()
+	assert.Equal(t, int64(0), tracker.Done())
+	assert.Equal(t, int64(-1), tracker.Progress().Total)
+
+	tracker = NewTracker(-1, nil)
+	n, err := io.Copy(ioutil.Discard, tracker.Reader(bytes.NewReader(make([]byte, 10))))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), n)
+	assert.Equal(t, int64(10), tracker.Done())
+	tracker.Finish()
+
+	tracker = NewTracker(10, &Observer{})
+	tracker.Add(10)
+	assert.Equal(t, int64(10), tracker.Done())
+}